@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"vs_exporter/internal/productmetrics"
+)
+
+// debugTargetsPath prefixes the per-target raw snapshot endpoint.
+const debugTargetsPath = "/debug/targets/"
+
+// debugTargetHandler serves /debug/targets/{target}: the target's per-pod
+// families as scraped and labelled, before they are merged.
+func debugTargetHandler(store *productmetrics.Store, logger logrus.FieldLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := strings.TrimPrefix(r.URL.Path, debugTargetsPath)
+		if target == "" {
+			http.Error(w, "target name is required", http.StatusBadRequest)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := store.WriteRaw(&buf, target); err != nil {
+			if errors.Is(err, productmetrics.ErrUnknownTarget) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			logger.Errorf("failed to render raw snapshot of %s: %v", target, err)
+			http.Error(w, "failed to render metrics", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", productmetrics.MetricsContentType)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			logger.Warnf("failed to write raw snapshot response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
+
+	"vs_exporter/internal/config"
+	"vs_exporter/internal/discovery"
+)
+
+// discoverServiceMonitorTargets translates the matching ServiceMonitors into
+// product targets, logging those that cannot be translated.
+func discoverServiceMonitorTargets(client dynamic.Interface, selector string, logger logrus.FieldLogger) []config.ProductMetricsTarget {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	targets, errs := discovery.ServiceMonitorTargets(ctx, client, selector)
+	for _, err := range errs {
+		logger.Warnf("ignoring ServiceMonitor: %v", err)
+	}
+	logger.Infof("discovered %d product targets from ServiceMonitors", len(targets))
+	return targets
+}
+
+// withDiscovered appends discovered targets to the configured ones, skipping
+// any whose name is already configured.
+func withDiscovered(configured, discovered []config.ProductMetricsTarget, logger logrus.FieldLogger) []config.ProductMetricsTarget {
+	if len(discovered) == 0 {
+		return configured
+	}
+	names := make(map[string]bool, len(configured))
+	for _, target := range configured {
+		names[target.Name] = true
+	}
+	merged := append([]config.ProductMetricsTarget(nil), configured...)
+	for _, target := range discovered {
+		if names[target.Name] {
+			logger.Warnf("discovered target %s is shadowed by a configured target of the same name", target.Name)
+			continue
+		}
+		merged = append(merged, target)
+	}
+	return merged
+}
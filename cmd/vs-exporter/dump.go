@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"vs_exporter/internal/clock"
+	"vs_exporter/internal/productmetrics"
+)
+
+// runDump writes the merged exposition to output every interval until ctx is
+// cancelled, for deployments where no Prometheus can reach the exporter but a
+// log pipeline collects its stdout or a file. Ticks come from clk.
+func runDump(ctx context.Context, clk clock.Clock, store *productmetrics.Store, interval time.Duration, output string, logger logrus.FieldLogger) {
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if err := writeSnapshot(store, output); err != nil {
+				logger.Warnf("failed to write metrics dump: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"vs_exporter/internal/clock"
+	"vs_exporter/internal/productmetrics"
+)
+
+func TestRunDumpReplacesOutputOnEachTick(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "metrics.prom")
+	store := productmetrics.NewStore()
+	store.Replace("alpha", upFamily(1))
+	fakeClock := clock.NewFake(time.Unix(1000, 0))
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runDump(ctx, fakeClock, store, time.Minute, output, logger)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	fakeClock.BlockUntil(1)
+	fakeClock.Advance(time.Minute)
+	waitForDump(t, output, "up 1")
+
+	store.Replace("alpha", upFamily(0))
+	fakeClock.Advance(time.Minute)
+	waitForDump(t, output, "up 0")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the output file to remain, got %d entries", len(entries))
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o644 {
+		t.Fatalf("expected the output to be world-readable, got %v", mode)
+	}
+}
+
+func waitForDump(t *testing.T, output, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(output)
+		if strings.Contains(string(data), want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to contain %q, got %q", output, want, data)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func upFamily(value float64) map[string]*dto.MetricFamily {
+	return map[string]*dto.MetricFamily{"up": {
+		Name:   proto.String("up"),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(value)}}},
+	}}
+}
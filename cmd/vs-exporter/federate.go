@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+
+	"vs_exporter/internal/productmetrics"
+)
+
+// federateHandler serves the store's metrics matched by the request's
+// match[] selectors, like Prometheus's /federate endpoint.
+func federateHandler(store *productmetrics.Store, logger logrus.FieldLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid request parameters", http.StatusBadRequest)
+			return
+		}
+		inputs := r.Form["match[]"]
+		if len(inputs) == 0 {
+			http.Error(w, "at least one match[] selector is required", http.StatusBadRequest)
+			return
+		}
+
+		selectors := make([]productmetrics.Selector, 0, len(inputs))
+		for _, input := range inputs {
+			selector, err := productmetrics.ParseSelector(input)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			selectors = append(selectors, selector)
+		}
+
+		families, err := store.Gather()
+		if err != nil {
+			logger.Errorf("failed to gather product metrics: %v", err)
+			http.Error(w, "failed to render metrics", http.StatusInternalServerError)
+			return
+		}
+
+		var buf bytes.Buffer
+		encoder := expfmt.NewEncoder(&buf, expfmt.FmtText)
+		for _, family := range productmetrics.FilterFamilies(families, selectors) {
+			if err := encoder.Encode(family); err != nil {
+				logger.Errorf("failed to encode federated metrics: %v", err)
+				http.Error(w, "failed to encode metrics", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", productmetrics.MetricsContentType)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			logger.Warnf("failed to write federate response: %v", err)
+		}
+	}
+}
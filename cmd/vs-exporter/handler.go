@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+
+	"vs_exporter/internal/clock"
+	"vs_exporter/internal/productmetrics"
+)
+
+// scrapeTimeoutHeader carries the scraping Prometheus server's timeout in seconds.
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// metricsHandler serves the store, i.e. the cached product metrics merged with
+// its in-process pseudo-targets such as the exporter's own registry.
+// When the request carries X-Prometheus-Scrape-Timeout-Seconds, a request whose
+// render does not finish within the timeout is answered with a 503; the render
+// itself continues for the requests that follow. The number of series in every
+// successful response is observed on servedSeries.
+func metricsHandler(output *renderCache, servedSeries prometheus.Observer, logger logrus.FieldLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if timeout := scrapeTimeout(r); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		body, series, err := output.render(ctx)
+		if err != nil && err == ctx.Err() {
+			logger.Warnf("metrics rendering did not finish before the scrape timeout: %v", err)
+			http.Error(w, "metrics rendering timed out", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			logger.Errorf("failed to render metrics: %v", err)
+			http.Error(w, "failed to render metrics", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", productmetrics.MetricsContentType)
+		if _, err := w.Write(body); err != nil {
+			logger.Warnf("failed to write metrics response: %v", err)
+			return
+		}
+		servedSeries.Observe(float64(series))
+	}
+}
+
+// countRequests counts the requests served by next on requests, labelled with
+// path and the response status code.
+func countRequests(requests *prometheus.CounterVec, path string, next http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerCounter(requests.MustCurryWith(prometheus.Labels{"path": path}), next)
+}
+
+// renderCache renders the store for /metrics, reusing the output for up to ttl
+// so that several Prometheus replicas scraping at once share one encoding.
+// Cached output is discarded as soon as a target's families change; the
+// exporter's own metrics may lag by up to ttl. A zero ttl renders every time.
+type renderCache struct {
+	store *productmetrics.Store
+	ttl   time.Duration
+	// clock times the ttl; tests replace it.
+	clock clock.Clock
+
+	mu         sync.Mutex
+	body       []byte
+	series     int
+	renderedAt time.Time
+	generation uint64
+	// inflight is the render in progress, if any, which callers join
+	// instead of starting their own.
+	inflight *renderFlight
+}
+
+// renderFlight is one render of the store, shared by every caller that asked
+// for output while it ran. Its results may be read once done is closed.
+type renderFlight struct {
+	done   chan struct{}
+	body   []byte
+	series int
+	err    error
+}
+
+func newRenderCache(store *productmetrics.Store, ttl time.Duration) *renderCache {
+	return &renderCache{store: store, ttl: ttl, clock: clock.Real()}
+}
+
+// render returns the store's text exposition and the number of series in it.
+// Concurrent callers share a single render rather than encoding the store in
+// parallel. When ctx is done first, render returns ctx's error and the render
+// finishes in the background, so that callers giving up cannot pile up renders.
+// The returned slice must not be modified.
+func (c *renderCache) render(ctx context.Context) ([]byte, int, error) {
+	c.mu.Lock()
+	// The generation is read before rendering so that an update racing the
+	// render invalidates the result.
+	generation := c.store.Generation()
+	if c.ttl > 0 && !c.renderedAt.IsZero() && generation == c.generation && c.clock.Now().Sub(c.renderedAt) < c.ttl {
+		body, series := c.body, c.series
+		c.mu.Unlock()
+		return body, series, nil
+	}
+	flight := c.inflight
+	if flight == nil {
+		flight = &renderFlight{done: make(chan struct{})}
+		c.inflight = flight
+		go c.run(flight, generation)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-flight.done:
+		return flight.body, flight.series, flight.err
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+}
+
+// run renders the store for flight and caches the output.
+func (c *renderCache) run(flight *renderFlight, generation uint64) {
+	var buf bytes.Buffer
+	flight.series, flight.err = c.store.WriteAllSeries(&buf)
+	if flight.err == nil {
+		flight.body = buf.Bytes()
+	}
+
+	c.mu.Lock()
+	c.inflight = nil
+	if flight.err == nil && c.ttl > 0 {
+		c.body, c.series, c.renderedAt, c.generation = flight.body, flight.series, c.clock.Now(), generation
+	}
+	c.mu.Unlock()
+	close(flight.done)
+}
+
+// scrapeTimeout parses the scrape timeout header, returning zero when it is
+// absent or invalid.
+func scrapeTimeout(r *http.Request) time.Duration {
+	value := r.Header.Get(scrapeTimeoutHeader)
+	if value == "" {
+		return 0
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
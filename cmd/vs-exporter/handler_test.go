@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"vs_exporter/internal/clock"
+	"vs_exporter/internal/productmetrics"
+)
+
+func TestMetricsHandlerTimesOutWithoutPilingUpRenders(t *testing.T) {
+	release := make(chan struct{})
+	var renders atomic.Int32
+	store := productmetrics.NewStore()
+	store.AddGatherer("slow", prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		renders.Add(1)
+		<-release
+		return nil, nil
+	}))
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := metricsHandler(newRenderCache(store, 0), prometheus.NewHistogram(prometheus.HistogramOpts{Name: "served"}), logger)
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set(scrapeTimeoutHeader, "0.01")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("request %d: expected 503 after the scrape timeout, got %d", i, rec.Code)
+		}
+	}
+	if got := renders.Load(); got != 1 {
+		t.Fatalf("expected timed-out requests to share one render, got %d", got)
+	}
+
+	close(release)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 once rendering is unblocked, got %d", rec.Code)
+	}
+}
+
+func TestRenderCacheReusesOutputUntilExpiryOrChange(t *testing.T) {
+	var renders atomic.Int32
+	store := productmetrics.NewStore()
+	store.AddGatherer("counting", prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		renders.Add(1)
+		return nil, nil
+	}))
+	fakeClock := clock.NewFake(time.Unix(1000, 0))
+	cache := newRenderCache(store, 30*time.Second)
+	cache.clock = fakeClock
+
+	render := func() string {
+		t.Helper()
+		body, _, err := cache.render(context.Background())
+		if err != nil {
+			t.Fatalf("render() error = %v", err)
+		}
+		return string(body)
+	}
+
+	render()
+	fakeClock.Advance(29 * time.Second)
+	render()
+	if got := renders.Load(); got != 1 {
+		t.Fatalf("expected a render within the ttl to hit the cache, got %d renders", got)
+	}
+
+	fakeClock.Advance(time.Second)
+	render()
+	if got := renders.Load(); got != 2 {
+		t.Fatalf("expected the cache to expire after the ttl, got %d renders", got)
+	}
+
+	store.Replace("alpha", upFamily(1))
+	if body := render(); !strings.Contains(body, "up 1") {
+		t.Fatalf("expected the replaced families to be rendered, got %q", body)
+	}
+	if got := renders.Load(); got != 3 {
+		t.Fatalf("expected a store change to invalidate the cache, got %d renders", got)
+	}
+}
+
+func TestCountRequestsCountsRejectedRequests(t *testing.T) {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"path", "code"})
+	reloaded := false
+	handler := countRequests(requests, "/-/reload", requireBearerToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloaded = true
+	})))
+
+	for _, authorization := range []string{"", "Bearer wrong", "Bearer secret"} {
+		req := httptest.NewRequest(http.MethodPost, "/-/reload", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if !reloaded {
+		t.Fatal("expected the request with the right token to reach the handler")
+	}
+	if got := testutil.ToFloat64(requests.WithLabelValues("/-/reload", "401")); got != 2 {
+		t.Fatalf("401 requests = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(requests.WithLabelValues("/-/reload", "200")); got != 1 {
+		t.Fatalf("200 requests = %v, want 1", got)
+	}
+}
+
+func TestMetricsHandlerObservesServedSeries(t *testing.T) {
+	store := productmetrics.NewStore()
+	store.AddGatherer("static", prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		family := &dto.MetricFamily{Name: proto.String("sample"), Type: dto.MetricType_GAUGE.Enum()}
+		for _, pod := range []string{"pod-1", "pod-2", "pod-3"} {
+			family.Metric = append(family.Metric, &dto.Metric{
+				Label: []*dto.LabelPair{{Name: proto.String("pod"), Value: proto.String(pod)}},
+				Gauge: &dto.Gauge{Value: proto.Float64(1)},
+			})
+		}
+		return []*dto.MetricFamily{family}, nil
+	}))
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	servedSeries := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "served", Buckets: []float64{1, 10}})
+	handler := metricsHandler(newRenderCache(store, 0), servedSeries, logger)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var metric dto.Metric
+	if err := servedSeries.Write(&metric); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if got := metric.GetHistogram(); got.GetSampleCount() != 1 || got.GetSampleSum() != 3 {
+		t.Fatalf("served series: count %d sum %v, want one observation of 3", got.GetSampleCount(), got.GetSampleSum())
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// helpConflictWarner returns the onHelpConflict callback for the warn policy:
+// it counts every conflict and logs each conflicting family once.
+func helpConflictWarner(conflicts *prometheus.CounterVec, logger logrus.FieldLogger) func(string) {
+	var mu sync.Mutex
+	warned := make(map[string]bool)
+	return func(family string) {
+		conflicts.WithLabelValues(family).Inc()
+		mu.Lock()
+		defer mu.Unlock()
+		if !warned[family] {
+			warned[family] = true
+			logger.Warnf("product metric family %s has conflicting HELP text across targets; keeping the first", family)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"vs_exporter/internal/collector"
+)
+
+// version is overridden at build time via -ldflags "-X main.version=...".
+var version = "dev"
+
+type infoResponse struct {
+	Version        string              `json:"version"`
+	GoVersion      string              `json:"goVersion"`
+	Ready          bool                `json:"ready"`
+	VirtualService virtualServiceInfo  `json:"virtualServiceCollector"`
+	Targets        []productTargetInfo `json:"targets"`
+}
+
+type virtualServiceInfo struct {
+	Enabled  bool   `json:"enabled"`
+	Interval string `json:"interval,omitempty"`
+	Ready    bool   `json:"ready"`
+}
+
+type productTargetInfo struct {
+	Name              string   `json:"name"`
+	Interval          string   `json:"interval"`
+	Port              int      `json:"port"`
+	Path              string   `json:"path"`
+	NamespaceSelector string   `json:"namespaceSelector"`
+	PodSelector       string   `json:"podSelector"`
+	PodSelectors      []string `json:"podSelectors,omitempty"`
+	Ready             bool     `json:"ready"`
+}
+
+// infoHandler serves a read-only JSON description of the running exporter.
+func infoHandler(reload *reloader, manager *scraperManager, vsCollector *collector.VirtualServiceCollector, logger logrus.FieldLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := reload.Config()
+		scrapers := manager.Scrapers()
+		resp := infoResponse{
+			Version:   version,
+			GoVersion: runtime.Version(),
+			Ready:     true,
+			Targets:   make([]productTargetInfo, 0, len(cfg.ProductMetrics)),
+		}
+
+		if cfg.EnableVirtualServiceScrapeJob {
+			resp.VirtualService = virtualServiceInfo{
+				Enabled:  true,
+				Interval: cfg.VirtualServiceInterval.String(),
+				Ready:    vsCollector != nil && vsCollector.Ready(),
+			}
+			resp.Ready = resp.VirtualService.Ready
+		}
+
+		ready := make(map[string]bool, len(scrapers))
+		for _, scraper := range scrapers {
+			ready[scraper.Name()] = scraper.Ready()
+		}
+		for _, target := range cfg.ProductMetrics {
+			info := productTargetInfo{
+				Name:              target.Name,
+				Interval:          target.Interval.String(),
+				Port:              target.Port,
+				Path:              target.Path,
+				NamespaceSelector: target.NamespaceSelector,
+				PodSelector:       target.PodSelector,
+				PodSelectors:      target.PodSelectors,
+				Ready:             ready[target.Name],
+			}
+			resp.Ready = resp.Ready && info.Ready
+			resp.Targets = append(resp.Targets, info)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Warnf("failed to write info response: %v", err)
+		}
+	}
+}
+
+// readyzHandler responds 200 once the VirtualService collector, when enabled,
+// and every configured product target are ready, and 503 naming the ones that
+// are not otherwise. It is meant for readiness probes and needs no token.
+func readyzHandler(reload *reloader, manager *scraperManager, vsCollector *collector.VirtualServiceCollector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := reload.Config()
+		var pending []string
+		if cfg.EnableVirtualServiceScrapeJob && (vsCollector == nil || !vsCollector.Ready()) {
+			pending = append(pending, "virtualServiceCollector")
+		}
+
+		ready := make(map[string]bool)
+		for _, scraper := range manager.Scrapers() {
+			ready[scraper.Name()] = scraper.Ready()
+		}
+		for _, target := range cfg.ProductMetrics {
+			if !ready[target.Name] {
+				pending = append(pending, target.Name)
+			}
+		}
+
+		if len(pending) > 0 {
+			http.Error(w, "not ready: "+strings.Join(pending, ", "), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
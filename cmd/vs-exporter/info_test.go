@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"vs_exporter/internal/config"
+	"vs_exporter/internal/productmetrics"
+)
+
+func TestReadyzHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "sample_up 1")
+	}))
+	t.Cleanup(server.Close)
+	port, err := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+	if err != nil {
+		t.Fatalf("parse test server port: %v", err)
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-a", Labels: map[string]string{"product": "alpha"}}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-a", Name: "pod-1", Labels: map[string]string{"app": "alpha"}},
+			Status:     corev1.PodStatus{PodIP: "127.0.0.1"},
+		},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	manager := newScraperManager(ctx, clientset, productmetrics.TransportOptions{}, "", productmetrics.NewStore(), productmetrics.NewMetrics(), nil, nil, nil,
+		func() time.Duration { return 0 }, logger)
+
+	target := config.ProductMetricsTarget{
+		Name:              "alpha",
+		Interval:          time.Minute,
+		Port:              port,
+		Path:              "/metrics",
+		Scheme:            "http",
+		NamespaceSelector: "product=alpha",
+		PodSelector:       "app=alpha",
+	}
+	cfg := config.Config{EnableVirtualServiceScrapeJob: true, ProductMetrics: []config.ProductMetricsTarget{target}}
+	handler := readyzHandler(newReloader("", cfg, cfg, manager, nil, logger), manager, nil)
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec
+	}
+
+	rec := get()
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "not ready: virtualServiceCollector, alpha") {
+		t.Fatalf("before any cycle: got %d %q, want 503 naming the collector and alpha", rec.Code, rec.Body.String())
+	}
+
+	manager.Apply([]config.ProductMetricsTarget{target})
+	deadline := time.Now().Add(5 * time.Second)
+	for !manager.Scrapers()[0].Ready() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the first scrape cycle")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec = get()
+	if rec.Code != http.StatusServiceUnavailable || strings.TrimSpace(rec.Body.String()) != "not ready: virtualServiceCollector" {
+		t.Fatalf("with alpha ready: got %d %q, want 503 naming only the collector", rec.Code, rec.Body.String())
+	}
+
+	cfg.EnableVirtualServiceScrapeJob = false
+	handler = readyzHandler(newReloader("", cfg, cfg, manager, nil, logger), manager, nil)
+	rec = get()
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "ok" {
+		t.Fatalf("with everything ready: got %d %q, want 200 ok", rec.Code, rec.Body.String())
+	}
+}
@@ -1,30 +1,38 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"flag"
+	"net"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/common/expfmt"
 	"github.com/sirupsen/logrus"
 	"istio.io/client-go/pkg/clientset/versioned"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 
+	"vs_exporter/internal/clock"
 	"vs_exporter/internal/collector"
 	"vs_exporter/internal/config"
 	"vs_exporter/internal/kube"
 	"vs_exporter/internal/productmetrics"
 )
 
+// exporterPseudoTarget names the exporter's own registry in the store.
+const exporterPseudoTarget = "vs-exporter"
+
 func main() {
 	configPath := flag.String("config", "config.yaml", "Path to config file")
+	oneshot := flag.Bool("oneshot", false, "Scrape every target and refresh the VirtualService collector once, write the merged metrics to -output, and exit")
+	output := flag.String("output", "-", "File written by -oneshot, or - for stdout")
 	flag.Parse()
 
 	logrus.SetFormatter(&logrus.TextFormatter{
@@ -36,102 +44,216 @@ func main() {
 	if err != nil {
 		appLogger.Fatalf("failed to load config: %v", err)
 	}
+	loaded := cfg
 
-	cfgKube, err := kube.BuildConfig()
+	cfgKube, err := kube.BuildConfig(kube.Options{
+		CAFile: cfg.KubeCAFile,
+		QPS:    cfg.KubeQPS,
+		Burst:  cfg.KubeBurst,
+	})
 	if err != nil {
 		appLogger.Fatalf("failed to build Kubernetes configuration: %v", err)
 	}
 
+	throttled := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vs_exporter_apiserver_throttled_total",
+		Help: "Kubernetes API requests delayed by the client-side rate limiter (source=client) or rejected with 429 by the API server (source=server).",
+	}, []string{"source"})
+	throttleWarnings := newThrottleWarner(appLogger)
+	onThrottle := func(source string) {
+		throttled.WithLabelValues(source).Inc()
+		throttleWarnings.warn(source)
+	}
+
+	clientset, err := kubernetes.NewForConfig(kube.Instrument(cfgKube, onThrottle))
+	if err != nil {
+		appLogger.Fatalf("failed to create Kubernetes clientset: %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	var reg prometheus.Registerer = registry
+	if cfg.ClusterName != "" {
+		reg = prometheus.WrapRegistererWith(prometheus.Labels{"cluster": cfg.ClusterName}, registry)
+	}
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	istioAvailable := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "vs_exporter_istio_available",
+		Help: "Whether the Istio networking CRDs are served by the cluster (1) or not (0).",
+	})
+	reg.MustRegister(istioAvailable, throttled)
+
+	available, err := collector.IstioAvailable(clientset.Discovery())
+	if err != nil {
+		appLogger.Warnf("unable to detect Istio CRDs, assuming they are installed: %v", err)
+		available = true
+	}
+	if available {
+		istioAvailable.Set(1)
+	} else if cfg.EnableVirtualServiceScrapeJob {
+		appLogger.Warn("Istio networking CRDs not found; disabling the VirtualService collector")
+		cfg.EnableVirtualServiceScrapeJob = false
+	}
+
+	rbacOK := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "vs_exporter_rbac_ok",
+		Help: "Whether the startup self-test found every RBAC permission the configured features need (1) or some missing (0).",
+	})
+	reg.MustRegister(rbacOK)
+	if err := checkRBAC(clientset, cfg, rbacOK, appLogger); err != nil {
+		appLogger.Fatalf("RBAC self-test failed: %v", err)
+	}
+
+	var discover func() []config.ProductMetricsTarget
+	if cfg.ServiceMonitorDiscovery {
+		dynamicClient, err := dynamic.NewForConfig(kube.Instrument(cfgKube, onThrottle))
+		if err != nil {
+			appLogger.Fatalf("failed to create dynamic client: %v", err)
+		}
+		discover = func() []config.ProductMetricsTarget {
+			return discoverServiceMonitorTargets(dynamicClient, cfg.ServiceMonitorSelector, appLogger)
+		}
+		cfg.ProductMetrics = withDiscovered(cfg.ProductMetrics, discover(), appLogger)
+	}
+
 	var istioClient *versioned.Clientset
 	if cfg.EnableVirtualServiceScrapeJob {
-		istioClient, err = versioned.NewForConfig(cfgKube)
+		istioClient, err = versioned.NewForConfig(kube.Instrument(cfgKube, onThrottle))
 		if err != nil {
 			appLogger.Fatalf("failed to create Istio clientset: %v", err)
 		}
 	}
 
-	clientset, err := kubernetes.NewForConfig(cfgKube)
-	if err != nil {
-		appLogger.Fatalf("failed to create Kubernetes clientset: %v", err)
-	}
+	startupDelay := jitteredDelay(cfg.StartupDelay, cfg.StartupJitter)
 
 	var vsCollector *collector.VirtualServiceCollector
 	if cfg.EnableVirtualServiceScrapeJob {
-		vsCollector = collector.NewVirtualServiceCollector(clientset, istioClient)
-		prometheus.MustRegister(vsCollector)
+		collectorOpts := []collector.Option{
+			collector.WithStartupDelay(startupDelay()),
+			collector.WithMetricPrefix(cfg.MetricPrefix),
+			collector.WithHealthDebounce(cfg.VirtualServiceHealthDebounce),
+			collector.WithGatewayConcurrency(cfg.CollectorGatewayConcurrency),
+		}
+		if cfg.VirtualServiceInformers {
+			collectorOpts = append(collectorOpts, collector.WithInformers(cfg.VirtualServiceInterval))
+		}
+		vsCollector, err = collector.NewVirtualServiceCollector(clientset, istioClient, reg, collectorOpts...)
+		if err != nil {
+			appLogger.Fatalf("failed to register VirtualService collector: %v", err)
+		}
+	}
+
+	helpConflicts := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "product_help_conflicts_total",
+		Help: "Number of times a product metric family was rendered with conflicting HELP text across targets, when onHelpConflict is warn.",
+	}, []string{"family"})
+	reg.MustRegister(helpConflicts)
+	var onHelpConflict func(string)
+	if cfg.OnHelpConflict == string(productmetrics.HelpWarn) {
+		onHelpConflict = helpConflictWarner(helpConflicts, appLogger)
+	}
+
+	store := productmetrics.NewStore(
+		productmetrics.WithIsolateTargets(cfg.IsolateTargets),
+		productmetrics.WithNamespaceRollup(cfg.NamespaceRollup),
+		productmetrics.WithRawSnapshots(cfg.DebugRawSnapshots),
+		productmetrics.WithHelpConflictPolicy(productmetrics.HelpConflictPolicy(cfg.OnHelpConflict), onHelpConflict),
+	)
+	store.AddGatherer(exporterPseudoTarget, registry)
+	scrapeMetrics := productmetrics.NewMetrics()
+	reg.MustRegister(scrapeMetrics)
+
+	transport := productmetrics.TransportOptions{
+		Timeout:       productmetrics.DefaultScrapeTimeout,
+		SourceIP:      net.ParseIP(cfg.ScrapeSourceIP),
+		DNSServer:     cfg.ScrapeDNSServer,
+		MinTLSVersion: cfg.MinTLSVersion,
+		CipherSuites:  cfg.TLSCipherSuites,
 	}
 
-	store := productmetrics.NewStore()
-	httpClient := &http.Client{
-		Timeout: 10 * time.Second,
+	limiter := productmetrics.NewScrapeLimiter(cfg.GlobalMaxConcurrentScrapes)
+	activeGoroutines := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "vs_exporter_active_scrape_goroutines",
+		Help: "Goroutines currently scraping product pods, across all targets.",
+	})
+	reg.MustRegister(activeGoroutines)
+	goroutines := productmetrics.NewGoroutineLimiter(cfg.MaxActiveScrapeGoroutines, activeGoroutines)
+	var claims *productmetrics.EndpointClaims
+	if cfg.DedupEndpoints {
+		claims = productmetrics.NewEndpointClaims()
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	if *oneshot {
+		manager := newScraperManager(ctx, clientset, transport, cfg.MeshCertDir, store, scrapeMetrics, limiter, goroutines, claims, startupDelay, appLogger)
+		if err := runOneshot(ctx, *output, cfg.ProductMetrics, manager, vsCollector, store, appLogger); err != nil {
+			appLogger.Fatalf("one-shot dump failed: %v", err)
+		}
+		return
+	}
+
 	if cfg.EnableVirtualServiceScrapeJob {
 		go vsCollector.Run(ctx, cfg.VirtualServiceInterval)
 	}
 
-	if len(cfg.ProductMetrics) == 0 {
-		appLogger.Warn("no product metrics targets configured; exposing only existing metrics")
-	} else {
-		for _, target := range cfg.ProductMetrics {
-			appLogger.WithField("target", target.Name).Infof("configuring product metrics scraper interval=%s port=%d path=%s namespaceSelector=%q podSelector=%q",
-				target.Interval, target.Port, target.Path, target.NamespaceSelector, target.PodSelector)
-
-			scraperLogger := logrus.WithFields(logrus.Fields{
-				"component": "product-scraper",
-				"target":    target.Name,
-			})
-
-			scraper := productmetrics.NewScraper(
-				target.Name,
-				clientset,
-				httpClient,
-				store,
-				target.Interval,
-				target.Port,
-				target.Path,
-				target.NamespaceSelector,
-				target.PodSelector,
-				scraperLogger,
-			)
-			go scraper.Run(ctx)
-		}
+	manager := newScraperManager(ctx, clientset, transport, cfg.MeshCertDir, store, scrapeMetrics, limiter, goroutines, claims, startupDelay, appLogger)
+	manager.Apply(cfg.ProductMetrics)
+	if cfg.WatchdogMultiplier > 0 {
+		go manager.runWatchdog(ctx, cfg.WatchdogMultiplier, cfg.WatchdogAction)
 	}
+	if cfg.DumpInterval > 0 {
+		go runDump(ctx, clock.Real(), store, cfg.DumpInterval, cfg.DumpOutput, appLogger)
+	}
+	reload := newReloader(*configPath, loaded, cfg, manager, discover, appLogger)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		var buf bytes.Buffer
-		encoder := expfmt.NewEncoder(&buf, expfmt.FmtText)
-
-		metricFamilies, err := prometheus.DefaultGatherer.Gather()
-		if err != nil {
-			appLogger.Errorf("failed to gather Prometheus metrics: %v", err)
-			http.Error(w, "failed to gather metrics", http.StatusInternalServerError)
-			return
-		}
-
-		for _, family := range metricFamilies {
-			if err := encoder.Encode(family); err != nil {
-				appLogger.Errorf("failed to encode Prometheus metrics: %v", err)
-				http.Error(w, "failed to encode metrics", http.StatusInternalServerError)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
 				return
+			case <-hup:
+				if err := reload.Reload(); err != nil {
+					appLogger.Errorf("config reload failed: %v", err)
+				}
 			}
 		}
+	}()
 
-		if err := store.WriteAll(&buf); err != nil {
-			appLogger.Errorf("failed to render product metrics: %v", err)
-			http.Error(w, "failed to render metrics", http.StatusInternalServerError)
-			return
-		}
-
-		w.Header().Set("Content-Type", productmetrics.MetricsContentType)
-		if _, err := w.Write(buf.Bytes()); err != nil {
-			appLogger.Warnf("failed to write metrics response: %v", err)
-		}
+	servedSeries := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "vs_exporter_http_metrics_served_series",
+		Help:    "Number of series written in each /metrics response.",
+		Buckets: prometheus.ExponentialBuckets(100, 4, 8),
 	})
+	httpRequests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vs_exporter_http_requests_total",
+		Help: "HTTP requests served by the exporter, by path and status code.",
+	}, []string{"path", "code"})
+	reg.MustRegister(servedSeries, httpRequests)
+
+	mux := http.NewServeMux()
+	handle := func(path string, handler http.Handler) {
+		mux.Handle(path, countRequests(httpRequests, path, handler))
+	}
+	handle("/metrics", metricsHandler(newRenderCache(store, cfg.MetricsCacheTTL), servedSeries, appLogger))
+	handle("/federate", federateHandler(store, appLogger))
+	handle("/info", infoHandler(reload, manager, vsCollector, appLogger))
+	handle("/status", statusHandler(manager, appLogger))
+	handle("/readyz", readyzHandler(reload, manager, vsCollector))
+	if cfg.DebugRawSnapshots {
+		handle(debugTargetsPath, debugTargetHandler(store, appLogger))
+	}
+	if cfg.EnableReloadEndpoint {
+		// Requests are counted outside the bearer-token check so that
+		// rejected ones show up with code="401".
+		handle("/-/reload", requireBearerToken(cfg.HTTPBearerToken, reloadHandler(reload)))
+	}
 
 	srv := &http.Server{
 		Addr:    cfg.ListenAddress,
@@ -139,7 +261,7 @@ func main() {
 	}
 	internalSrv := &http.Server{
 		Addr:    cfg.InternalMetricsAddress,
-		Handler: promhttp.Handler(),
+		Handler: promhttp.InstrumentMetricHandler(reg, promhttp.HandlerFor(registry, promhttp.HandlerOpts{})),
 	}
 
 	go func() {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"vs_exporter/internal/collector"
+	"vs_exporter/internal/config"
+	"vs_exporter/internal/productmetrics"
+)
+
+// runOneshot scrapes every target and refreshes the VirtualService collector
+// once, then writes the merged exposition to output, or to stdout when output
+// is empty or "-". Scrape errors are logged rather than returned so that a
+// partial snapshot is still written.
+func runOneshot(
+	ctx context.Context,
+	output string,
+	targets []config.ProductMetricsTarget,
+	manager *scraperManager,
+	vsCollector *collector.VirtualServiceCollector,
+	store *productmetrics.Store,
+	logger logrus.FieldLogger,
+) error {
+	manager.metrics.SetTargets(len(targets))
+	for _, target := range targets {
+		scraper, err := manager.newScraper(target)
+		if err != nil {
+			logger.WithField("target", target.Name).Errorf("skipping target: %v", err)
+			continue
+		}
+		if err := scraper.ScrapeOnce(ctx); err != nil {
+			logger.WithField("target", target.Name).Warnf("one-shot scrape incomplete: %v", err)
+		}
+	}
+
+	if vsCollector != nil {
+		if err := vsCollector.UpdateOnce(ctx); err != nil {
+			logger.Warnf("unable to update VirtualService metrics: %v", err)
+		}
+	}
+
+	if err := writeSnapshot(store, output); err != nil {
+		return err
+	}
+	if output != "" && output != "-" {
+		logger.Infof("wrote metrics snapshot to %s", output)
+	}
+	return nil
+}
+
+// writeSnapshot writes the merged exposition to output, or to stdout when
+// output is empty or "-". A file is written next to output and renamed over
+// it, so that readers never see a partial snapshot.
+func writeSnapshot(store *productmetrics.Store, output string) error {
+	if output == "" || output == "-" {
+		return store.WriteAll(os.Stdout)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create output file: %w", err)
+	}
+	// CreateTemp's 0600 would hide the snapshot from a collector running as
+	// another user.
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("chmod output file: %w", err)
+	}
+	if err := store.WriteAll(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("close output file: %w", err)
+	}
+	if err := os.Rename(file.Name(), output); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("replace output file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"vs_exporter/internal/collector"
+	"vs_exporter/internal/config"
+	"vs_exporter/internal/discovery"
+	"vs_exporter/internal/kube"
+)
+
+// requirement is a permission the configured features need. Unless
+// clusterWide is set, holding it in every namespace matching one of
+// selectors is enough.
+type requirement struct {
+	permission  kube.Permission
+	clusterWide bool
+	selectors   []string
+}
+
+// requirements collects requirements in the order they are first added,
+// merging repeated permissions.
+type requirements []*requirement
+
+// add records that permission is needed in the namespaces matching selector,
+// or cluster-wide when selector is empty.
+func (r *requirements) add(permission kube.Permission, selector string) {
+	var req *requirement
+	for _, existing := range *r {
+		if existing.permission == permission {
+			req = existing
+			break
+		}
+	}
+	if req == nil {
+		req = &requirement{permission: permission}
+		*r = append(*r, req)
+	}
+	if selector == "" {
+		req.clusterWide = true
+		return
+	}
+	for _, existing := range req.selectors {
+		if existing == selector {
+			return
+		}
+	}
+	req.selectors = append(req.selectors, selector)
+}
+
+// requiredPermissions lists the API accesses the configured features need.
+func requiredPermissions(cfg config.Config) requirements {
+	var required requirements
+	required.add(kube.Permission{Resource: "namespaces", Verb: "list"}, "")
+	for _, target := range cfg.ProductMetrics {
+		selector := target.NamespaceSelector
+		if target.Scope == config.ScopeCluster {
+			selector = ""
+		}
+		required.add(kube.Permission{Resource: "pods", Verb: "list"}, selector)
+		if target.OwnerKind != "" {
+			required.add(kube.Permission{Group: "apps", Resource: "replicasets", Verb: "get"}, selector)
+		}
+	}
+	if cfg.ServiceMonitorDiscovery {
+		// Discovered targets may select any namespace.
+		required.add(kube.Permission{Resource: "pods", Verb: "list"}, "")
+		required.add(kube.Permission{Group: discovery.ServiceMonitorResource.Group, Resource: discovery.ServiceMonitorResource.Resource, Verb: "list"}, "")
+	}
+	if cfg.EnableVirtualServiceScrapeJob {
+		// Informers watch every namespace; plain Lists only read product namespaces.
+		selector := collector.NamespaceSelector
+		verbs := []string{"list"}
+		if cfg.VirtualServiceInformers {
+			selector = ""
+			verbs = append(verbs, "watch")
+		}
+		for _, resource := range []string{"gateways", "virtualservices"} {
+			for _, verb := range verbs {
+				required.add(kube.Permission{Group: "networking.istio.io", Resource: resource, Verb: verb}, selector)
+			}
+		}
+		required.add(kube.Permission{Resource: "services", Verb: "list"}, collector.NamespaceSelector)
+	}
+	return required
+}
+
+// missingPermissions reviews every requirement cluster-wide first. A
+// namespace-scoped requirement denied cluster-wide is reviewed again in each
+// namespace its selectors match, so that RoleBindings limited to those
+// namespaces are not reported as missing.
+func missingPermissions(ctx context.Context, clientset kubernetes.Interface, required requirements) ([]kube.Permission, error) {
+	permissions := make([]kube.Permission, len(required))
+	for i, req := range required {
+		permissions[i] = req.permission
+	}
+	denied, err := kube.MissingPermissions(ctx, clientset, permissions)
+	if err != nil {
+		return nil, err
+	}
+	deniedSet := make(map[kube.Permission]bool, len(denied))
+	for _, permission := range denied {
+		deniedSet[permission] = true
+	}
+
+	var missing []kube.Permission
+	for _, req := range required {
+		if !deniedSet[req.permission] {
+			continue
+		}
+		if req.clusterWide {
+			missing = append(missing, req.permission)
+			continue
+		}
+		namespaced, err := missingInNamespaces(ctx, clientset, req)
+		if err != nil {
+			return nil, err
+		}
+		missing = append(missing, namespaced...)
+	}
+	return missing, nil
+}
+
+// missingInNamespaces reviews req in each namespace matching its selectors.
+// When the namespaces cannot be listed, the cluster-wide denial stands.
+func missingInNamespaces(ctx context.Context, clientset kubernetes.Interface, req *requirement) ([]kube.Permission, error) {
+	seen := make(map[string]bool)
+	var permissions []kube.Permission
+	for _, selector := range req.selectors {
+		namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return []kube.Permission{req.permission}, nil
+		}
+		for _, ns := range namespaces.Items {
+			if seen[ns.Name] {
+				continue
+			}
+			seen[ns.Name] = true
+			permission := req.permission
+			permission.Namespace = ns.Name
+			permissions = append(permissions, permission)
+		}
+	}
+	return kube.MissingPermissions(ctx, clientset, permissions)
+}
+
+// checkRBAC verifies at startup that the exporter may perform every access in
+// requiredPermissions, logging each missing one and recording the verdict in
+// rbacOK. It returns an error for missing permissions only when
+// failOnMissingRBAC is set; a failed review is logged and treated as unknown.
+func checkRBAC(clientset kubernetes.Interface, cfg config.Config, rbacOK prometheus.Gauge, logger logrus.FieldLogger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	missing, err := missingPermissions(ctx, clientset, requiredPermissions(cfg))
+	if err != nil {
+		logger.Warnf("unable to verify RBAC permissions: %v", err)
+		return nil
+	}
+	if len(missing) == 0 {
+		rbacOK.Set(1)
+		return nil
+	}
+
+	rbacOK.Set(0)
+	for _, permission := range missing {
+		if permission.Namespace == "" {
+			logger.Errorf("missing RBAC permission: the exporter's service account cannot %s cluster-wide", permission)
+		} else {
+			logger.Errorf("missing RBAC permission: the exporter's service account cannot %s", permission)
+		}
+	}
+	if cfg.FailOnMissingRBAC {
+		return fmt.Errorf("%d required RBAC permissions are missing", len(missing))
+	}
+	return nil
+}
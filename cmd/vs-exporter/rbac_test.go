@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"vs_exporter/internal/config"
+)
+
+// newRBACClientset grants list namespaces cluster-wide and list pods only in
+// the namespaces named in podNamespaces.
+func newRBACClientset(podNamespaces ...string) *fake.Clientset {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"team": "retail"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "cart", Labels: map[string]string{"team": "retail"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "billing", Labels: map[string]string{"team": "finance"}}},
+	)
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		switch attributes.Resource {
+		case "namespaces":
+			review.Status.Allowed = true
+		case "pods":
+			for _, ns := range podNamespaces {
+				review.Status.Allowed = review.Status.Allowed || attributes.Namespace == ns
+			}
+		}
+		return true, review, nil
+	})
+	return clientset
+}
+
+func TestCheckRBACAcceptsPermissionsGrantedInSelectedNamespaces(t *testing.T) {
+	cfg := config.Config{
+		FailOnMissingRBAC: true,
+		ProductMetrics: []config.ProductMetricsTarget{
+			{Name: "retail", NamespaceSelector: "team=retail", Scope: config.ScopeNamespace},
+		},
+	}
+	rbacOK := prometheus.NewGauge(prometheus.GaugeOpts{Name: "rbac_ok"})
+	logger, hook := logtest.NewNullLogger()
+
+	if err := checkRBAC(newRBACClientset("shop", "cart"), cfg, rbacOK, logger); err != nil {
+		t.Fatalf("checkRBAC() error = %v", err)
+	}
+	if got := testutil.ToFloat64(rbacOK); got != 1 {
+		t.Fatalf("rbac_ok = %v, want 1", got)
+	}
+	if len(hook.AllEntries()) != 0 {
+		t.Fatalf("unexpected log entries: %v", hook.AllEntries())
+	}
+}
+
+func TestCheckRBACReportsUngrantedNamespaces(t *testing.T) {
+	cfg := config.Config{
+		FailOnMissingRBAC: true,
+		ProductMetrics: []config.ProductMetricsTarget{
+			{Name: "retail", NamespaceSelector: "team=retail", Scope: config.ScopeNamespace},
+		},
+	}
+	rbacOK := prometheus.NewGauge(prometheus.GaugeOpts{Name: "rbac_ok"})
+	logger, hook := logtest.NewNullLogger()
+
+	if err := checkRBAC(newRBACClientset("shop"), cfg, rbacOK, logger); err == nil {
+		t.Fatal("checkRBAC() error = nil, want missing permissions")
+	}
+	if got := testutil.ToFloat64(rbacOK); got != 0 {
+		t.Fatalf("rbac_ok = %v, want 0", got)
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 1 || !strings.Contains(entries[0].Message, "cannot list pods in namespace cart") {
+		t.Fatalf("log entries = %v, want one naming namespace cart", entries)
+	}
+}
+
+func TestCheckRBACRequiresClusterScopeTargetsCluster(t *testing.T) {
+	cfg := config.Config{
+		ProductMetrics: []config.ProductMetricsTarget{
+			{Name: "all", Scope: config.ScopeCluster},
+		},
+	}
+	rbacOK := prometheus.NewGauge(prometheus.GaugeOpts{Name: "rbac_ok"})
+	logger, hook := logtest.NewNullLogger()
+
+	if err := checkRBAC(newRBACClientset("shop", "cart", "billing"), cfg, rbacOK, logger); err != nil {
+		t.Fatalf("checkRBAC() error = %v", err)
+	}
+	if got := testutil.ToFloat64(rbacOK); got != 0 {
+		t.Fatalf("rbac_ok = %v, want 0", got)
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 1 || !strings.Contains(entries[0].Message, "cannot list pods cluster-wide") {
+		t.Fatalf("log entries = %v, want one cluster-wide denial", entries)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"vs_exporter/internal/config"
+)
+
+// reloader re-reads the config file and applies product target changes. Only
+// productMetrics can change at runtime; other settings require a restart.
+type reloader struct {
+	path    string
+	manager *scraperManager
+	logger  logrus.FieldLogger
+	// discover, when set, returns the targets discovered from the cluster,
+	// which are re-read and appended to the configured ones on every reload.
+	discover func() []config.ProductMetricsTarget
+
+	// reloadMu serializes reloads so that targets are applied in the order
+	// they were loaded; mu only guards cfg, so Config does not wait for Apply.
+	reloadMu sync.Mutex
+	// loaded is the configuration read from the file at startup, before the
+	// adjustments and discovered targets that cfg carries. Reloads compare
+	// against it, so a change that needs a restart is warned about until then.
+	loaded config.Config
+	mu     sync.Mutex
+	cfg    config.Config
+}
+
+func newReloader(path string, loaded, cfg config.Config, manager *scraperManager, discover func() []config.ProductMetricsTarget, logger logrus.FieldLogger) *reloader {
+	return &reloader{
+		path:     path,
+		loaded:   loaded,
+		cfg:      cfg,
+		manager:  manager,
+		discover: discover,
+		logger:   logger,
+	}
+}
+
+// Config returns the currently applied configuration.
+func (r *reloader) Config() config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+// Reload loads and validates the config file and applies its product targets.
+// The running configuration is left untouched when loading fails.
+func (r *reloader) Reload() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	next, err := config.Load(r.path)
+	if err != nil {
+		return err
+	}
+
+	if !onlyTargetsChanged(r.loaded, next) {
+		r.logger.Warn("config reload only applies productMetrics; restart to apply other changes")
+	}
+
+	targets := next.ProductMetrics
+	if r.discover != nil {
+		targets = withDiscovered(next.ProductMetrics, r.discover(), r.logger)
+	}
+
+	r.mu.Lock()
+	r.cfg.ProductMetrics = targets
+	r.mu.Unlock()
+
+	// Apply takes the manager's lock, which must not block Config.
+	r.manager.Apply(targets)
+	r.logger.Infof("configuration reloaded from %s: %d product targets", r.path, len(targets))
+	return nil
+}
+
+// onlyTargetsChanged reports whether a and b differ at most in productMetrics,
+// the only section a reload applies.
+func onlyTargetsChanged(a, b config.Config) bool {
+	a.ProductMetrics, b.ProductMetrics = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// reloadHandler triggers a reload on POST, mirroring Prometheus's /-/reload.
+func reloadHandler(r *reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.Reload(); err != nil {
+			r.logger.Errorf("config reload failed: %v", err)
+			http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// requireBearerToken rejects requests without the expected bearer token. An
+// empty token disables authentication.
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"vs_exporter/internal/config"
+	"vs_exporter/internal/productmetrics"
+)
+
+const reloadTestConfig = `listenAddress: ":8080"
+internalMetricsAddress: ":9000"
+virtualServiceInterval: "1m"
+productMetrics:
+  - name: product-a
+    interval: "30s"
+    port: 8080
+    path: /metrics
+    namespaceSelector: product=a
+    podSelector: app=a
+`
+
+func TestReloadWarnsAboutEveryChangeOutsideProductMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+	}
+	write(reloadTestConfig)
+	loaded, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	// Startup adjustments, such as disabling the collector without Istio
+	// CRDs, are not changes to the file.
+	cfg := loaded
+	cfg.EnableVirtualServiceScrapeJob = false
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	quiet := logrus.New()
+	quiet.SetOutput(io.Discard)
+	manager := newScraperManager(ctx, fake.NewSimpleClientset(), productmetrics.TransportOptions{}, "", productmetrics.NewStore(), productmetrics.NewMetrics(), nil, nil, nil,
+		func() time.Duration { return 0 }, quiet)
+	logger, hook := logtest.NewNullLogger()
+	reload := newReloader(path, loaded, cfg, manager, nil, logger)
+
+	warned := func() bool {
+		t.Helper()
+		hook.Reset()
+		if err := reload.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel {
+				return true
+			}
+		}
+		return false
+	}
+
+	write(reloadTestConfig + "    timeout: \"5s\"\n")
+	if warned() {
+		t.Fatal("expected no warning when only productMetrics changed")
+	}
+	for _, change := range []string{"clusterName: east\n", "startupDelay: \"10s\"\n", "kubeQPS: 50\n", "virtualServiceInformers: true\n"} {
+		write(change + reloadTestConfig)
+		if !warned() {
+			t.Fatalf("expected a warning after adding %q", change)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+
+	"vs_exporter/internal/clock"
+	"vs_exporter/internal/config"
+	"vs_exporter/internal/productmetrics"
+)
+
+// scraperManager owns the running product scrapers and reconciles them against
+// the configured targets, so that config reloads only restart what changed.
+type scraperManager struct {
+	ctx        context.Context
+	clientset  kubernetes.Interface
+	transport  productmetrics.TransportOptions
+	httpClient *http.Client
+	meshCerts  string
+	store      *productmetrics.Store
+	metrics    *productmetrics.Metrics
+	limiter    *productmetrics.ScrapeLimiter
+	goroutines *productmetrics.GoroutineLimiter
+	claims     *productmetrics.EndpointClaims
+	logger     logrus.FieldLogger
+	// clock drives the scrapers and the watchdog; tests replace it.
+	clock clock.Clock
+	// startupDelay returns the delay before a scraper's first cycle. It only
+	// applies to the scrapers of the first Apply, at process startup.
+	startupDelay func() time.Duration
+
+	mu      sync.Mutex
+	running map[string]*runningScraper
+	order   []string
+	// applied is set once the first Apply has run; scrapers started by
+	// reloads and watchdog restarts scrape without delay.
+	applied bool
+}
+
+type runningScraper struct {
+	target  config.ProductMetricsTarget
+	scraper *productmetrics.Scraper
+	cancel  context.CancelFunc
+}
+
+func newScraperManager(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	transport productmetrics.TransportOptions,
+	meshCertDir string,
+	store *productmetrics.Store,
+	metrics *productmetrics.Metrics,
+	limiter *productmetrics.ScrapeLimiter,
+	goroutines *productmetrics.GoroutineLimiter,
+	claims *productmetrics.EndpointClaims,
+	startupDelay func() time.Duration,
+	logger logrus.FieldLogger,
+) *scraperManager {
+	return &scraperManager{
+		ctx:          ctx,
+		clientset:    clientset,
+		transport:    transport,
+		httpClient:   productmetrics.NewHTTPClient(transport),
+		meshCerts:    meshCertDir,
+		store:        store,
+		metrics:      metrics,
+		limiter:      limiter,
+		goroutines:   goroutines,
+		claims:       claims,
+		logger:       logger,
+		startupDelay: startupDelay,
+		clock:        clock.Real(),
+		running:      make(map[string]*runningScraper),
+	}
+}
+
+// Apply starts new targets, restarts changed ones, and stops removed ones.
+func (m *scraperManager) Apply(targets []config.ProductMetricsTarget) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[string]config.ProductMetricsTarget, len(targets))
+	order := make([]string, 0, len(targets))
+	for _, target := range targets {
+		wanted[target.Name] = target
+		order = append(order, target.Name)
+	}
+
+	for name, running := range m.running {
+		target, ok := wanted[name]
+		if ok && reflect.DeepEqual(target, running.target) {
+			continue
+		}
+		m.stopLocked(name, running)
+		if !ok {
+			m.store.Delete(name)
+			m.metrics.Forget(name)
+			m.logger.WithField("target", name).Info("product metrics scraper removed")
+		}
+	}
+
+	for _, target := range targets {
+		if _, ok := m.running[target.Name]; ok {
+			continue
+		}
+		m.startLocked(target)
+	}
+
+	m.order = order
+	m.applied = true
+	m.metrics.SetTargets(len(m.running))
+	if len(m.running) == 0 {
+		m.logger.Warn("no product metrics targets configured; exposing only existing metrics")
+	}
+}
+
+// Scrapers returns the running scrapers in configuration order.
+func (m *scraperManager) Scrapers() []*productmetrics.Scraper {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	scrapers := make([]*productmetrics.Scraper, 0, len(m.order))
+	for _, name := range m.order {
+		if running, ok := m.running[name]; ok {
+			scrapers = append(scrapers, running.scraper)
+		}
+	}
+	return scrapers
+}
+
+func (m *scraperManager) startLocked(target config.ProductMetricsTarget) {
+	m.logger.WithField("target", target.Name).Infof("configuring product metrics scraper interval=%s port=%d path=%s namespaceSelector=%q podSelector=%q",
+		target.Interval, target.Port, target.Path, target.NamespaceSelector, target.PodSelector)
+
+	var delay time.Duration
+	if !m.applied {
+		delay = m.startupDelay()
+	}
+	scraper, err := m.newScraper(target, productmetrics.WithStartupDelay(delay))
+	if err != nil {
+		m.logger.WithField("target", target.Name).Errorf("not starting product metrics scraper: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	running := &runningScraper{
+		target:  target,
+		scraper: scraper,
+		cancel:  cancel,
+	}
+	m.running[target.Name] = running
+
+	go scraper.Run(ctx)
+}
+
+// newScraper builds a scraper for target without starting it.
+func (m *scraperManager) newScraper(target config.ProductMetricsTarget, extra ...productmetrics.ScraperOption) (*productmetrics.Scraper, error) {
+	scraperLogger := logrus.WithFields(logrus.Fields{
+		"component": "product-scraper",
+		"target":    target.Name,
+	})
+
+	httpClient, err := m.scrapeClient(target)
+	if err != nil {
+		return nil, err
+	}
+
+	relabelings := make([]productmetrics.RelabelConfig, len(target.MetricRelabelings))
+	for i, rule := range target.MetricRelabelings {
+		relabelings[i] = productmetrics.RelabelConfig(rule)
+	}
+	rules, err := productmetrics.CompileRelabelings(relabelings)
+	if err != nil {
+		return nil, err
+	}
+	extra = append(extra, productmetrics.WithMetricRelabelings(rules), productmetrics.WithEndpointClaims(m.claims), productmetrics.WithGoroutineLimiter(m.goroutines), productmetrics.WithClock(m.clock))
+
+	scraper := productmetrics.NewScraper(
+		target.Name,
+		m.clientset,
+		httpClient,
+		m.store,
+		target.Interval,
+		target.Port,
+		target.Path,
+		target.NamespaceSelector,
+		target.PodSelector,
+		scraperLogger,
+		append(scraperOptions(target, m.metrics, m.limiter), extra...)...,
+	)
+	return scraper, nil
+}
+
+// scrapeClient returns the HTTP client the scraper of target owns. Targets
+// without transport overrides share the manager's default client; any
+// override gets a client of its own, so that one target's timeout, TLS or
+// proxy settings never affect another.
+func (m *scraperManager) scrapeClient(target config.ProductMetricsTarget) (*http.Client, error) {
+	opts := m.transport
+	custom := false
+	switch {
+	case target.HTTP2:
+		opts.HTTP2 = true
+		custom = true
+	case target.Scheme == "https":
+		tlsConfig, err := scrapeTLSConfig(target, m.meshCerts)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS = tlsConfig
+		custom = true
+	}
+	if target.Timeout > 0 {
+		opts.Timeout = target.Timeout
+		custom = true
+	}
+	if target.DialTimeout > 0 {
+		opts.DialTimeout = target.DialTimeout
+		custom = true
+	}
+	if target.ProxyURL != "" {
+		proxy, err := url.Parse(target.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxyURL: %w", err)
+		}
+		opts.Proxy = proxy
+		custom = true
+	}
+	if !custom {
+		return m.httpClient, nil
+	}
+	return productmetrics.NewHTTPClient(opts), nil
+}
+
+// scrapeTLSConfig builds the TLS settings for an https target. meshTLS
+// targets present the workload certificate from meshCertDir instead.
+func scrapeTLSConfig(target config.ProductMetricsTarget, meshCertDir string) (*tls.Config, error) {
+	if target.MeshTLS {
+		return productmetrics.NewMeshTLSConfig(meshCertDir)
+	}
+	tlsConfig := &tls.Config{ServerName: target.TLSServerName}
+	if target.TLSCAFile != "" {
+		pem, err := os.ReadFile(target.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read tlsCAFile: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tlsCAFile %s contains no PEM certificates", target.TLSCAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	return tlsConfig, nil
+}
+
+// stopLocked cancels a scraper and retires it so that it cannot write to the
+// store after being replaced or removed. It does not wait for the scraper to
+// exit: a scraper stuck in a hung scrape would otherwise block reloads and
+// everything else that takes m.mu.
+func (m *scraperManager) stopLocked(name string, running *runningScraper) {
+	running.cancel()
+	running.scraper.Retire()
+	delete(m.running, name)
+}
+
+// scraperOptions translates optional target settings into scraper options.
+func scraperOptions(target config.ProductMetricsTarget, metrics *productmetrics.Metrics, limiter *productmetrics.ScrapeLimiter) []productmetrics.ScraperOption {
+	opts := []productmetrics.ScraperOption{
+		productmetrics.WithMetrics(metrics),
+		productmetrics.WithGlobalLimiter(limiter),
+		productmetrics.WithMaxConcurrentScrapes(target.MaxConcurrentScrapes),
+		productmetrics.WithMaxPodsPerNamespace(target.MaxPodsPerNamespace),
+		productmetrics.WithScrapeTerminatingPods(target.ScrapeTerminatingPods),
+		productmetrics.WithMinReadyPods(target.MinReadyPods),
+		productmetrics.WithClusterScope(target.Scope == config.ScopeCluster),
+		productmetrics.WithMaxTimestampSkew(target.MaxTimestampSkew, target.StripStaleTimestamps),
+		productmetrics.WithFamilyCountWarning(target.MaxFamilies, target.MaxFamilyGrowth),
+		productmetrics.WithRequestTimeout(target.Timeout),
+		productmetrics.WithCopyNamespaceLabels(target.CopyNamespaceLabels),
+		productmetrics.WithPodStartTimestamps(target.PodStartTimestamps),
+		productmetrics.WithInstanceLabel(target.InjectInstanceLabel),
+		productmetrics.WithReparseRetries(target.ReparseRetries),
+		productmetrics.WithPodSelectors(target.PodSelectors),
+		productmetrics.WithScheme(target.Scheme),
+		productmetrics.WithRequest(target.Method, target.RequestBody),
+		productmetrics.WithSlowPodThresholds(target.SlowScrapeThreshold, target.LargeResponseThreshold),
+		productmetrics.WithAcceptStatusCodes(target.AcceptStatusCodes),
+		productmetrics.WithKeepOnPartialFailure(target.KeepOnPartialFailure),
+		productmetrics.WithMaxLabelValueLength(target.MaxLabelValueLength, target.LabelValueOverflow == "drop"),
+		productmetrics.WithRedactLabels(target.RedactLabels, target.RedactMode == "hash"),
+	}
+	if target.PortNamePattern != "" {
+		opts = append(opts, productmetrics.WithPortNamePattern(target.PortNamePattern))
+	}
+	if target.ProductLabelFrom != "" {
+		opts = append(opts, productmetrics.WithProductLabelFrom(target.ProductLabelFrom))
+	}
+	if target.OwnerKind != "" {
+		opts = append(opts, productmetrics.WithOwner(target.OwnerKind, target.OwnerName))
+	}
+	if target.OnlyReadyPods {
+		opts = append(opts, productmetrics.WithOnlyReadyPods(target.ReadyConditionType))
+	}
+	if target.DropRuntimeMetrics {
+		opts = append(opts, productmetrics.WithDropFamilies(productmetrics.RuntimeMetricPatterns))
+	}
+	switch target.CoerceUntypedTo {
+	case "gauge":
+		opts = append(opts, productmetrics.WithCoerceUntypedTo(dto.MetricType_GAUGE))
+	case "counter":
+		opts = append(opts, productmetrics.WithCoerceUntypedTo(dto.MetricType_COUNTER))
+	}
+	return opts
+}
+
+// jitteredDelay returns a function yielding base plus a random duration in [0, jitter).
+func jitteredDelay(base, jitter time.Duration) func() time.Duration {
+	return func() time.Duration {
+		if jitter <= 0 {
+			return base
+		}
+		return base + time.Duration(rand.Int63n(int64(jitter)))
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"vs_exporter/internal/config"
+	"vs_exporter/internal/productmetrics"
+)
+
+func TestApplyDelaysOnlyStartupScrapers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	delays := 0
+	manager := newScraperManager(ctx, fake.NewSimpleClientset(), productmetrics.TransportOptions{}, "", productmetrics.NewStore(), productmetrics.NewMetrics(), nil, nil, nil,
+		func() time.Duration {
+			delays++
+			return time.Hour
+		}, logger)
+
+	target := func(name string, interval time.Duration) config.ProductMetricsTarget {
+		return config.ProductMetricsTarget{
+			Name:              name,
+			Interval:          interval,
+			Port:              8080,
+			Path:              "/metrics",
+			Scheme:            "http",
+			NamespaceSelector: "product=" + name,
+			PodSelector:       "app=" + name,
+		}
+	}
+
+	manager.Apply([]config.ProductMetricsTarget{target("alpha", time.Minute), target("beta", time.Minute)})
+	if delays != 2 {
+		t.Fatalf("startup delay drawn %d times at startup, want 2", delays)
+	}
+
+	// A reload that changes alpha and adds gamma starts both right away.
+	manager.Apply([]config.ProductMetricsTarget{target("alpha", 2*time.Minute), target("beta", time.Minute), target("gamma", time.Minute)})
+	if delays != 2 {
+		t.Fatalf("startup delay drawn %d times after a reload, want still 2", delays)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"vs_exporter/internal/productmetrics"
+)
+
+type statusResponse struct {
+	Targets []productmetrics.ScrapeStatus `json:"targets"`
+}
+
+// statusHandler serves the outcome of every running target's latest scrape
+// cycle as JSON, in configuration order, for status pages.
+func statusHandler(manager *scraperManager, logger logrus.FieldLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scrapers := manager.Scrapers()
+		resp := statusResponse{Targets: make([]productmetrics.ScrapeStatus, 0, len(scrapers))}
+		for _, scraper := range scrapers {
+			resp.Targets = append(resp.Targets, scraper.Status())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Warnf("failed to write status response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"vs_exporter/internal/clock"
+	"vs_exporter/internal/kube"
+)
+
+// throttleWarnInterval is the least time between two throttling warnings of
+// the same source.
+const throttleWarnInterval = time.Minute
+
+// throttleWarner logs Kubernetes API throttling at most once per source per
+// throttleWarnInterval, since a saturated limiter delays every request. A
+// warning carries the number of occurrences suppressed since the previous one.
+type throttleWarner struct {
+	clock  clock.Clock
+	logger logrus.FieldLogger
+
+	mu         sync.Mutex
+	lastWarned map[string]time.Time
+	suppressed map[string]int
+}
+
+func newThrottleWarner(logger logrus.FieldLogger) *throttleWarner {
+	return &throttleWarner{
+		clock:      clock.Real(),
+		logger:     logger,
+		lastWarned: make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+// warn records a throttled request of source, logging it unless source was
+// already logged within throttleWarnInterval.
+func (w *throttleWarner) warn(source string) {
+	now := w.clock.Now()
+	w.mu.Lock()
+	last, warned := w.lastWarned[source]
+	if warned && now.Sub(last) < throttleWarnInterval {
+		w.suppressed[source]++
+		w.mu.Unlock()
+		return
+	}
+	suppressed := w.suppressed[source]
+	w.lastWarned[source] = now
+	w.suppressed[source] = 0
+	w.mu.Unlock()
+
+	logger := w.logger
+	if suppressed > 0 {
+		logger = logger.WithField("suppressed", suppressed)
+	}
+	if source == kube.ThrottleServer {
+		logger.Warn("Kubernetes API server throttled a request (429 Too Many Requests)")
+	} else {
+		logger.Warn("Kubernetes API request waited for the client-side rate limiter; consider raising kubeQPS/kubeBurst")
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	"vs_exporter/internal/clock"
+	"vs_exporter/internal/kube"
+)
+
+func TestThrottleWarnerLogsOncePerSourcePerInterval(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	fakeClock := clock.NewFake(time.Unix(1000, 0))
+	warner := newThrottleWarner(logger)
+	warner.clock = fakeClock
+
+	for i := 0; i < 5; i++ {
+		warner.warn(kube.ThrottleClient)
+	}
+	warner.warn(kube.ThrottleServer)
+	if got := len(hook.AllEntries()); got != 2 {
+		t.Fatalf("expected one warning per source, got %d", got)
+	}
+
+	fakeClock.Advance(throttleWarnInterval)
+	warner.warn(kube.ThrottleClient)
+	entries := hook.AllEntries()
+	if len(entries) != 3 {
+		t.Fatalf("expected another warning after the interval, got %d", len(entries))
+	}
+	if got := entries[2].Data["suppressed"]; got != 4 {
+		t.Fatalf("expected the warning to report 4 suppressed occurrences, got %v", got)
+	}
+}
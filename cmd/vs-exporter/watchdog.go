@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// watchdogCheckInterval is how often running scrapers are checked for stalls.
+const watchdogCheckInterval = 15 * time.Second
+
+// runWatchdog flags scrapers that have not completed a cycle within multiplier
+// times their interval until ctx is cancelled. A stalled scraper is restarted,
+// or the process panics when action is "panic" so Kubernetes restarts the pod.
+func (m *scraperManager) runWatchdog(ctx context.Context, multiplier int, action string) {
+	ticker := m.clock.NewTicker(watchdogCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			m.checkStalled(now, multiplier, action)
+		}
+	}
+}
+
+func (m *scraperManager) checkStalled(now time.Time, multiplier int, action string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, running := range m.running {
+		last := running.scraper.LastCycle()
+		if last.IsZero() {
+			// Still waiting out the startup delay.
+			continue
+		}
+
+		limit := time.Duration(multiplier) * running.scraper.Interval()
+		stalled := now.Sub(last) > limit
+		m.metrics.SetCycleStalled(name, stalled)
+		if !stalled {
+			continue
+		}
+
+		logger := m.logger.WithField("target", name)
+		logger.Errorf("scrape loop has not completed a cycle since %s (limit %s)", last.Format(time.RFC3339), limit)
+		if action == "panic" {
+			panic(fmt.Sprintf("product metrics scraper %s stalled", name))
+		}
+
+		// Retiring the stuck scraper keeps it from overwriting the
+		// replacement's data if it ever resumes.
+		m.stopLocked(name, running)
+		m.startLocked(running.target)
+		m.metrics.SetCycleStalled(name, false)
+		logger.Warn("restarted stalled product metrics scraper")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"vs_exporter/internal/clock"
+	"vs_exporter/internal/config"
+	"vs_exporter/internal/productmetrics"
+)
+
+func TestCheckStalledRestartsStuckScraper(t *testing.T) {
+	manager, fakeClock, registry := newStuckManager(t)
+	stuck := manager.Scrapers()[0]
+
+	manager.checkStalled(fakeClock.Now().Add(2*time.Minute), 3, "restart")
+	if got := manager.Scrapers()[0]; got != stuck {
+		t.Fatalf("expected a scraper within its limit to keep running")
+	}
+	if value, ok := stalledValue(t, registry, "alpha"); !ok || value != 0 {
+		t.Fatalf("expected product_scrape_cycle_stalled 0, got %v (present %v)", value, ok)
+	}
+
+	manager.checkStalled(fakeClock.Now().Add(4*time.Minute), 3, "restart")
+	if got := manager.Scrapers()[0]; got == stuck {
+		t.Fatalf("expected the stalled scraper to be replaced")
+	}
+	if value, ok := stalledValue(t, registry, "alpha"); !ok || value != 0 {
+		t.Fatalf("expected product_scrape_cycle_stalled reset after the restart, got %v (present %v)", value, ok)
+	}
+
+	manager.Apply(nil)
+	if _, ok := stalledValue(t, registry, "alpha"); ok {
+		t.Fatalf("expected product_scrape_cycle_stalled to be deleted with the target")
+	}
+}
+
+func TestCheckStalledPanicsWhenConfigured(t *testing.T) {
+	manager, fakeClock, registry := newStuckManager(t)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected checkStalled to panic")
+		}
+		if value, _ := stalledValue(t, registry, "alpha"); value != 1 {
+			t.Fatalf("expected product_scrape_cycle_stalled 1, got %v", value)
+		}
+	}()
+	manager.checkStalled(fakeClock.Now().Add(4*time.Minute), 3, "panic")
+}
+
+func TestApplyDoesNotWaitForStuckScraper(t *testing.T) {
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	var first sync.Once
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-a", Labels: map[string]string{"product": "alpha"}}},
+	)
+	// The first pod List hangs without honouring cancellation, so cancelling
+	// the scraper cannot make it return.
+	clientset.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		first.Do(func() {
+			close(entered)
+			<-release
+		})
+		return false, nil, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	manager := newScraperManager(ctx, clientset, productmetrics.TransportOptions{}, "", productmetrics.NewStore(), productmetrics.NewMetrics(), nil, nil, nil,
+		func() time.Duration { return 0 }, logger)
+	t.Cleanup(func() {
+		close(release)
+		cancel()
+	})
+
+	target := config.ProductMetricsTarget{
+		Name:              "alpha",
+		Interval:          time.Minute,
+		Port:              8080,
+		Path:              "/metrics",
+		Scheme:            "http",
+		NamespaceSelector: "product=alpha",
+		PodSelector:       "app=alpha",
+	}
+	manager.Apply([]config.ProductMetricsTarget{target})
+	select {
+	case <-entered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the first scrape")
+	}
+	stuck := manager.Scrapers()[0]
+
+	reloaded := make(chan struct{})
+	go func() {
+		defer close(reloaded)
+		target.Interval = 2 * time.Minute
+		manager.Apply([]config.ProductMetricsTarget{target})
+	}()
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Apply blocked on the stuck scraper")
+	}
+	scrapers := manager.Scrapers()
+	if len(scrapers) != 1 || scrapers[0] == stuck || scrapers[0].Interval() != 2*time.Minute {
+		t.Fatalf("expected the stuck scraper to be replaced by the reconfigured one")
+	}
+}
+
+// newStuckManager returns a manager on a fake clock running one target whose
+// first scrape hangs until the test ends, as a wedged pod connection would.
+func newStuckManager(t *testing.T) (*scraperManager, *clock.Fake, *prometheus.Registry) {
+	t.Helper()
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	var first sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first.Do(func() {
+			close(entered)
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+	}))
+	port, err := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+	if err != nil {
+		t.Fatalf("parse test server port: %v", err)
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-a", Labels: map[string]string{"product": "alpha"}}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-a", Name: "pod-1", Labels: map[string]string{"app": "alpha"}},
+			Status:     corev1.PodStatus{PodIP: "127.0.0.1"},
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	metrics := productmetrics.NewMetrics()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	manager := newScraperManager(ctx, clientset, productmetrics.TransportOptions{}, "", productmetrics.NewStore(), metrics, nil, nil, nil,
+		func() time.Duration { return 0 }, logger)
+	fakeClock := clock.NewFake(time.Unix(1000, 0))
+	manager.clock = fakeClock
+	t.Cleanup(func() {
+		close(release)
+		cancel()
+		server.Close()
+	})
+
+	manager.Apply([]config.ProductMetricsTarget{{
+		Name:              "alpha",
+		Interval:          time.Minute,
+		Port:              port,
+		Path:              "/metrics",
+		Scheme:            "http",
+		NamespaceSelector: "product=alpha",
+		PodSelector:       "app=alpha",
+	}})
+	select {
+	case <-entered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the first scrape")
+	}
+	return manager, fakeClock, registry
+}
+
+// stalledValue returns the product_scrape_cycle_stalled value of target and
+// whether the series exists.
+func stalledValue(t *testing.T, registry *prometheus.Registry, target string) (float64, bool) {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "product_scrape_cycle_stalled" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "target" && label.GetValue() == target {
+					return metric.GetGauge().GetValue(), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,49 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeTickerFiresOnAdvance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := NewFake(start)
+	ticker := fake.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	fake.Advance(30 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatalf("ticker fired before its period elapsed")
+	default:
+	}
+
+	fake.Advance(30 * time.Second)
+	select {
+	case got := <-ticker.C():
+		if want := start.Add(time.Minute); !got.Equal(want) {
+			t.Fatalf("expected tick at %s, got %s", want, got)
+		}
+	default:
+		t.Fatalf("expected ticker to fire after one period")
+	}
+}
+
+func TestFakeAfter(t *testing.T) {
+	fake := NewFake(time.Unix(0, 0))
+	ch := fake.After(5 * time.Second)
+
+	fake.Advance(4 * time.Second)
+	select {
+	case <-ch:
+		t.Fatalf("After fired early")
+	default:
+	}
+
+	fake.Advance(time.Second)
+	select {
+	case <-ch:
+	default:
+		t.Fatalf("expected After to fire once deadline reached")
+	}
+}
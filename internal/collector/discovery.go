@@ -0,0 +1,35 @@
+package collector
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+
+	v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+)
+
+// IstioAvailable reports whether the API server serves the Istio networking
+// resources the collector depends on (VirtualServices and Gateways).
+func IstioAvailable(client discovery.DiscoveryInterface) (bool, error) {
+	resources, err := client.ServerResourcesForGroupVersion(v1beta1.SchemeGroupVersion.String())
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("discover %s: %w", v1beta1.SchemeGroupVersion, err)
+	}
+
+	required := map[string]bool{"virtualservices": false, "gateways": false}
+	for _, resource := range resources.APIResources {
+		if _, ok := required[resource.Name]; ok {
+			required[resource.Name] = true
+		}
+	}
+	for _, found := range required {
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
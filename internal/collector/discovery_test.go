@@ -0,0 +1,36 @@
+package collector
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIstioAvailable(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset()
+	disc := clientset.Discovery().(*fakediscovery.FakeDiscovery)
+
+	ok, err := IstioAvailable(disc)
+	if err != nil {
+		t.Fatalf("IstioAvailable() error = %v", err)
+	}
+	if ok {
+		t.Fatalf("expected Istio to be unavailable without its API group")
+	}
+
+	disc.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "networking.istio.io/v1beta1",
+			APIResources: []metav1.APIResource{{Name: "virtualservices"}, {Name: "gateways"}},
+		},
+	}
+	ok, err = IstioAvailable(disc)
+	if err != nil {
+		t.Fatalf("IstioAvailable() error = %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected Istio to be available once its resources are served")
+	}
+}
@@ -2,75 +2,407 @@ package collector
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 
+	networking "istio.io/api/networking/v1beta1"
 	v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
 	istio "istio.io/client-go/pkg/clientset/versioned"
+	istioinformers "istio.io/client-go/pkg/informers/externalversions"
+	istiolisters "istio.io/client-go/pkg/listers/networking/v1beta1"
+
+	"vs_exporter/internal/clock"
 )
 
 const vsCollectorLogPrefix = "[VirtualServiceCollector]"
 
+// DefaultMetricPrefix is the name prefix of every metric the collector exports.
+const DefaultMetricPrefix = "istio"
+
+// legacyUpdateCountName is the refresh counter's name from before metricPrefix
+// existed. It is still exported for one release so dashboards can migrate.
+const legacyUpdateCountName = "platform_virtualservice_metrics_update"
+
+// defaultInformerSyncTimeout bounds UpdateOnce's wait for the informer caches,
+// which never sync while the Gateway or VirtualService List is refused.
+const defaultInformerSyncTimeout = time.Minute
+
+// NamespaceSelector selects the namespaces whose VirtualServices are exported.
+const NamespaceSelector = "product"
+
 // VirtualServiceCollector periodically refreshes metrics describing Istio VirtualServices.
 type VirtualServiceCollector struct {
-	kubeClient  kubernetes.Interface
-	istioClient istio.Interface
-	metric      *prometheus.GaugeVec
-	updateCount prometheus.Counter
-}
-
-// NewVirtualServiceCollector constructs a VirtualServiceCollector backed by typed Kubernetes and Istio clients.
-func NewVirtualServiceCollector(kubeClient kubernetes.Interface, istioClient istio.Interface) *VirtualServiceCollector {
-	return &VirtualServiceCollector{
-		kubeClient:  kubeClient,
-		istioClient: istioClient,
-		metric: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "istio_virtual_service_info",
-				Help: "Information about Istio VirtualService resources, labelled by namespace, virtual service, and referenced gateway.",
-			},
-			[]string{"namespace", "virtual_service", "gateway"},
-		),
-		updateCount: prometheus.NewCounter(
+	kubeClient   kubernetes.Interface
+	istioClient  istio.Interface
+	metric       *prometheus.GaugeVec
+	metricRaw    *prometheus.GaugeVec
+	meshOnly     *prometheus.GaugeVec
+	weightSum    *prometheus.GaugeVec
+	weightBad    *prometheus.GaugeVec
+	attached     *prometheus.GaugeVec
+	gatewayTLS   *prometheus.GaugeVec
+	portConflict *prometheus.GaugeVec
+	dangling     *prometheus.GaugeVec
+	missingSvc   *prometheus.GaugeVec
+	hostClash    *prometheus.GaugeVec
+	forbidden    *prometheus.GaugeVec
+	timestamp    prometheus.Gauge
+	gwCached     prometheus.Gauge
+	vsTotal      prometheus.Gauge
+	updateCount  prometheus.Counter
+	// legacyUpdates mirrors updateCount under its name from before
+	// metricPrefix; nil when the prefix reproduces that name.
+	legacyUpdates prometheus.Counter
+	panics        prometheus.Counter
+	clock         clock.Clock
+	startupDelay  time.Duration
+	metricPrefix  string
+	ready         atomic.Bool
+
+	// debounce is the number of consecutive refreshes a healthy info series
+	// must read 0 before it is published as 0; streaks tracks them per series.
+	debounce int
+	streaks  map[string]*healthStreak
+
+	// gatewayConcurrency bounds the parallel Gateway reads that prefetch the
+	// product namespaces at the start of a refresh; values below 2 read them
+	// one after another.
+	gatewayConcurrency int
+
+	// useInformers switches Gateway and VirtualService reads to the listers
+	// below, backed by a shared informer cache instead of per-namespace Lists.
+	useInformers   bool
+	informerResync time.Duration
+	// syncTimeout bounds UpdateOnce's wait for the informer caches; Run
+	// waits one interval per attempt instead.
+	syncTimeout   time.Duration
+	informers     istioinformers.SharedInformerFactory
+	gatewayLister istiolisters.GatewayLister
+	vsLister      istiolisters.VirtualServiceLister
+}
+
+// Option customises optional VirtualServiceCollector behaviour.
+type Option func(*VirtualServiceCollector)
+
+// WithClock overrides the clock driving the refresh loop. It defaults to the real clock.
+func WithClock(c clock.Clock) Option {
+	return func(col *VirtualServiceCollector) {
+		if c != nil {
+			col.clock = c
+		}
+	}
+}
+
+// WithStartupDelay postpones the first refresh by delay.
+func WithStartupDelay(delay time.Duration) Option {
+	return func(col *VirtualServiceCollector) {
+		col.startupDelay = delay
+	}
+}
+
+// WithInformers reads Gateways and VirtualServices from a cluster-wide shared
+// informer cache, resynced every resync, instead of listing them per namespace
+// on each refresh.
+func WithInformers(resync time.Duration) Option {
+	return func(col *VirtualServiceCollector) {
+		col.useInformers = true
+		col.informerResync = resync
+	}
+}
+
+// WithMetricPrefix replaces the "istio" prefix of the collector's metric
+// names, e.g. to avoid clashing with another Istio exporter. An empty prefix
+// keeps the default.
+func WithMetricPrefix(prefix string) Option {
+	return func(col *VirtualServiceCollector) {
+		if prefix != "" {
+			col.metricPrefix = prefix
+		}
+	}
+}
+
+// WithHealthDebounce holds a virtual_service_info series at 1 until it has read
+// 0 on cycles consecutive refreshes, to ride out brief Gateway list
+// inconsistencies. The undebounced value is exported as virtual_service_info_raw.
+// Values below 2 disable debouncing.
+func WithHealthDebounce(cycles int) Option {
+	return func(col *VirtualServiceCollector) {
+		col.debounce = cycles
+	}
+}
+
+// WithGatewayConcurrency lists the Gateways of up to n product namespaces in
+// parallel when a refresh starts, to speed up refreshes in many namespaces
+// without overwhelming the API server. It defaults to 1.
+func WithGatewayConcurrency(n int) Option {
+	return func(col *VirtualServiceCollector) {
+		col.gatewayConcurrency = n
+	}
+}
+
+// healthStreak is the debounce state of one virtual_service_info series.
+type healthStreak struct {
+	published float64
+	zeros     int
+	seen      bool
+}
+
+// NewVirtualServiceCollector constructs a VirtualServiceCollector backed by typed Kubernetes and Istio clients
+// and registers it with reg. A nil reg leaves the collector unregistered.
+func NewVirtualServiceCollector(kubeClient kubernetes.Interface, istioClient istio.Interface, reg prometheus.Registerer, opts ...Option) (*VirtualServiceCollector, error) {
+	c := &VirtualServiceCollector{
+		kubeClient:   kubeClient,
+		istioClient:  istioClient,
+		clock:        clock.Real(),
+		metricPrefix: DefaultMetricPrefix,
+		streaks:      make(map[string]*healthStreak),
+		syncTimeout:  defaultInformerSyncTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.metric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("virtual_service_info"),
+			Help: "Information about Istio VirtualService resources, labelled by namespace, virtual service, and referenced gateway.",
+		},
+		[]string{"namespace", "virtual_service", "gateway"},
+	)
+	c.metricRaw = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("virtual_service_info_raw"),
+			Help: "Undebounced value of virtual_service_info as observed in the last refresh; only exported when health debouncing is enabled.",
+		},
+		[]string{"namespace", "virtual_service", "gateway"},
+	)
+	c.meshOnly = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("virtual_service_mesh_only"),
+			Help: "Whether an Istio VirtualService is routed only inside the mesh (1) or is attached to at least one ingress gateway (0).",
+		},
+		[]string{"namespace", "virtual_service"},
+	)
+	c.weightSum = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("virtual_service_route_weight_sum"),
+			Help: "Sum of destination weights for each weighted HTTP route of an Istio VirtualService.",
+		},
+		[]string{"namespace", "virtual_service", "route_index"},
+	)
+	c.weightBad = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("virtual_service_route_weight_misconfigured"),
+			Help: "Whether a weighted HTTP route's destination weights do not sum to 100 (1) or do (0).",
+		},
+		[]string{"namespace", "virtual_service", "route_index"},
+	)
+	c.attached = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("gateway_attached_virtual_services"),
+			Help: "Number of Istio VirtualServices attached to each Gateway seen during the last refresh.",
+		},
+		[]string{"namespace", "gateway"},
+	)
+	c.gatewayTLS = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("virtual_service_gateway_tls"),
+			Help: "TLS modes of the gateway servers matching an Istio VirtualService's hosts; NONE marks plaintext servers.",
+		},
+		[]string{"namespace", "virtual_service", "gateway", "tls_mode"},
+	)
+	c.portConflict = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("gateway_port_conflict"),
+			Help: "Whether two Gateways in a namespace selecting the same workload bind the same port with overlapping hosts (1) or not (0).",
+		},
+		[]string{"namespace", "port"},
+	)
+	c.dangling = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("dangling_gateway_reference"),
+			Help: "Gateways referenced by at least one Istio VirtualService during the last refresh but not defined.",
+		},
+		[]string{"namespace", "gateway"},
+	)
+	c.missingSvc = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("virtual_service_missing_service"),
+			Help: "Route destination hosts of an Istio VirtualService that name a Kubernetes Service which does not exist.",
+		},
+		[]string{"namespace", "virtual_service", "host"},
+	)
+	c.hostClash = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("virtual_service_host_conflict"),
+			Help: "Number of Istio VirtualServices, from more than one namespace, that bind the same host to the same Gateway.",
+		},
+		[]string{"host", "gateway"},
+	)
+	c.forbidden = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: c.metricName("collector_namespace_forbidden"),
+			Help: "Namespaces skipped during the last VirtualService metric refresh because listing their Gateways or VirtualServices was forbidden.",
+		},
+		[]string{"namespace"},
+	)
+	c.timestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: c.metricName("collector_timestamp_seconds"),
+			Help: "Unix time at which the last VirtualService metric refresh finished, whether or not it succeeded.",
+		},
+	)
+	c.gwCached = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: c.metricName("collector_gateways_cached"),
+			Help: "Number of Istio Gateways read during the last successful VirtualService metric refresh.",
+		},
+	)
+	c.vsTotal = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: c.metricName("collector_virtual_services_total"),
+			Help: "Number of Istio VirtualServices processed during the last successful VirtualService metric refresh.",
+		},
+	)
+	c.updateCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: c.metricName("virtualservice_metrics_update"),
+			Help: "Total number of VirtualService metric refresh attempts.",
+		},
+	)
+	if name := c.metricName("virtualservice_metrics_update"); name != legacyUpdateCountName {
+		c.legacyUpdates = prometheus.NewCounter(
 			prometheus.CounterOpts{
-				Name: "platform_virtualservice_metrics_update",
-				Help: "Total number of VirtualService metric refresh attempts.",
+				Name: legacyUpdateCountName,
+				Help: "Deprecated: use " + name + ". Total number of VirtualService metric refresh attempts.",
 			},
-		),
+		)
+	}
+	c.panics = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: c.metricName("virtual_service_collect_panic_total"),
+			Help: "Total number of VirtualServices skipped during a refresh because collecting them panicked.",
+		},
+	)
+	if c.useInformers {
+		c.informers = istioinformers.NewSharedInformerFactory(istioClient, c.informerResync)
+		networking := c.informers.Networking().V1beta1()
+		c.gatewayLister = networking.Gateways().Lister()
+		c.vsLister = networking.VirtualServices().Lister()
+	}
+	if reg != nil {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
 	}
+	return c, nil
+}
+
+// metricName joins the configured prefix and suffix into a metric name.
+func (c *VirtualServiceCollector) metricName(suffix string) string {
+	return c.metricPrefix + "_" + suffix
 }
 
 // Describe implements prometheus.Collector.
 func (c *VirtualServiceCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.metric.Describe(ch)
+	c.metricRaw.Describe(ch)
+	c.meshOnly.Describe(ch)
+	c.weightSum.Describe(ch)
+	c.weightBad.Describe(ch)
+	c.attached.Describe(ch)
+	c.gatewayTLS.Describe(ch)
+	c.portConflict.Describe(ch)
+	c.dangling.Describe(ch)
+	c.missingSvc.Describe(ch)
+	c.hostClash.Describe(ch)
+	c.forbidden.Describe(ch)
+	c.timestamp.Describe(ch)
+	c.gwCached.Describe(ch)
+	c.vsTotal.Describe(ch)
 	c.updateCount.Describe(ch)
+	if c.legacyUpdates != nil {
+		c.legacyUpdates.Describe(ch)
+	}
+	c.panics.Describe(ch)
 }
 
 // Collect implements prometheus.Collector.
 func (c *VirtualServiceCollector) Collect(ch chan<- prometheus.Metric) {
 	c.metric.Collect(ch)
+	c.metricRaw.Collect(ch)
+	c.meshOnly.Collect(ch)
+	c.weightSum.Collect(ch)
+	c.weightBad.Collect(ch)
+	c.attached.Collect(ch)
+	c.gatewayTLS.Collect(ch)
+	c.portConflict.Collect(ch)
+	c.dangling.Collect(ch)
+	c.missingSvc.Collect(ch)
+	c.hostClash.Collect(ch)
+	c.forbidden.Collect(ch)
+	c.timestamp.Collect(ch)
+	c.gwCached.Collect(ch)
+	c.vsTotal.Collect(ch)
 	c.updateCount.Collect(ch)
+	if c.legacyUpdates != nil {
+		c.legacyUpdates.Collect(ch)
+	}
+	c.panics.Collect(ch)
 }
 
 // Run refreshes VirtualService metrics until the context is cancelled.
 func (c *VirtualServiceCollector) Run(ctx context.Context, interval time.Duration) {
+	if c.startupDelay > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(c.startupDelay):
+		}
+	}
+
+	// A failed sync is retried every interval; the collector stays unready
+	// meanwhile rather than silently stopping.
+	for {
+		err := c.syncInformers(ctx, interval)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.WithField("component", vsCollectorLogPrefix).Errorf("%v; retrying in %s", err, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(interval):
+		}
+	}
 	if err := c.update(ctx); err != nil && ctx.Err() == nil {
 		logrus.WithField("component", vsCollectorLogPrefix).Warnf("unable to update VirtualService metrics: %v", err)
 	}
+	c.ready.Store(true)
 
-	ticker := time.NewTicker(interval)
+	ticker := c.clock.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			if err := c.update(ctx); err != nil && ctx.Err() == nil {
 				logrus.WithField("component", vsCollectorLogPrefix).Warnf("unable to update VirtualService metrics: %v", err)
 			}
@@ -78,89 +410,498 @@ func (c *VirtualServiceCollector) Run(ctx context.Context, interval time.Duratio
 	}
 }
 
+// Ready reports whether the collector has completed its first refresh attempt.
+func (c *VirtualServiceCollector) Ready() bool {
+	return c.ready.Load()
+}
+
+// UpdateOnce performs a single refresh outside of Run, e.g. for one-shot dumps.
+func (c *VirtualServiceCollector) UpdateOnce(ctx context.Context) error {
+	if err := c.syncInformers(ctx, c.syncTimeout); err != nil {
+		return err
+	}
+	return c.update(ctx)
+}
+
+// syncInformers starts the informer cache, if enabled, and waits up to
+// timeout for its initial sync. The informers keep running on ctx after a
+// timeout, so a later call may still see them sync. Starting an already
+// running factory is a no-op.
+func (c *VirtualServiceCollector) syncInformers(ctx context.Context, timeout time.Duration) error {
+	if c.informers == nil {
+		return nil
+	}
+	c.informers.Start(ctx.Done())
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for _, synced := range c.informers.WaitForCacheSync(waitCtx.Done()) {
+		if !synced {
+			return fmt.Errorf("istio informer caches did not sync within %s", timeout)
+		}
+	}
+	return nil
+}
+
 func (c *VirtualServiceCollector) update(ctx context.Context) error {
 	c.updateCount.Inc()
+	if c.legacyUpdates != nil {
+		c.legacyUpdates.Inc()
+	}
+	defer func() {
+		c.timestamp.Set(float64(c.clock.Now().UnixNano()) / 1e9)
+	}()
 
 	namespaces, err := c.kubeClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
-		LabelSelector: "product",
+		LabelSelector: NamespaceSelector,
 	})
 	if err != nil {
 		return err
 	}
 
 	c.metric.Reset()
+	c.metricRaw.Reset()
+	c.meshOnly.Reset()
+	c.weightSum.Reset()
+	c.weightBad.Reset()
+	c.attached.Reset()
+	c.gatewayTLS.Reset()
+	c.portConflict.Reset()
+	c.dangling.Reset()
+	c.missingSvc.Reset()
+	c.hostClash.Reset()
+	c.forbidden.Reset()
 
 	gatewayCache := make(map[string]map[string]*v1beta1.Gateway)
+	serviceCache := make(map[string]map[string]bool)
+	// attached tracks, per "namespace/gateway", the distinct VirtualServices referencing it.
+	attached := make(map[string]map[string]struct{})
+	claims := make(hostClaims)
+	// forbidden holds the namespaces whose Gateways or VirtualServices may
+	// not be listed; they are skipped rather than failing the refresh.
+	forbidden := make(map[string]bool)
 
+	if err := c.prefetchGateways(ctx, namespaces.Items, gatewayCache, forbidden); err != nil {
+		return err
+	}
+
+	var processed int
 	for _, namespace := range namespaces.Items {
 		nsName := namespace.GetName()
-		if _, err := c.ensureGatewaysCached(ctx, nsName, gatewayCache); err != nil {
-			return err
+		if forbidden[nsName] {
+			continue
+		}
+		virtualServices, err := c.listVirtualServices(ctx, nsName)
+		if apierrors.IsForbidden(err) {
+			forbidden[nsName] = true
+			continue
 		}
-
-		vsList, err := c.istioClient.NetworkingV1beta1().VirtualServices(nsName).List(ctx, metav1.ListOptions{})
 		if err != nil {
 			return err
 		}
 
-		for _, vs := range vsList.Items {
+		for _, vs := range virtualServices {
 			if vs == nil {
 				continue
 			}
-
-			gateways := vs.Spec.Gateways
-			if len(gateways) == 0 {
-				gateways = []string{"mesh"}
+			processed++
+			if err := c.recordVirtualService(ctx, nsName, vs, gatewayCache, serviceCache, attached, claims, forbidden); err != nil {
+				return err
 			}
+		}
+	}
 
-			for _, gatewayRef := range gateways {
-				labelGateway := gatewayRef
-				value := 1.0
+	var cached int
+	for gwNamespace, gateways := range gatewayCache {
+		cached += len(gateways)
+		for gwName := range gateways {
+			count := len(attached[gwNamespace+"/"+gwName])
+			c.attached.WithLabelValues(gwNamespace, gwName).Set(float64(count))
+		}
+	}
+	c.gwCached.Set(float64(cached))
+	c.vsTotal.Set(float64(processed))
+	c.recordPortConflicts(gatewayCache)
+	c.recordHostConflicts(claims)
+	for nsName := range forbidden {
+		logrus.WithField("component", vsCollectorLogPrefix).Warnf("skipped namespace %s: listing its Gateways or VirtualServices is forbidden", nsName)
+		c.forbidden.WithLabelValues(nsName).Set(1)
+	}
+	c.pruneStreaks()
 
-				if gatewayRef == "" {
-					value = 0
-				} else if gatewayRef == "mesh" {
-					// mesh gateway is virtual; assume healthy
-					value = 1
-				} else {
-					gwNamespace := nsName
-					gwName := gatewayRef
-
-					if strings.Contains(gatewayRef, "/") {
-						parts := strings.SplitN(gatewayRef, "/", 2)
-						if len(parts) == 2 {
-							gwNamespace = parts[0]
-							gwName = parts[1]
-						}
-					}
+	return nil
+}
 
-					nsGateways, err := c.ensureGatewaysCached(ctx, gwNamespace, gatewayCache)
-					if err != nil {
-						return err
-					}
+// recordVirtualService publishes the metrics of a single VirtualService in
+// namespace, recording its resolved gateway references in attached and its
+// hosts on existing gateways in claims. A gateway in a namespace whose
+// Gateways may not be listed is reported as unresolved, and the namespace is
+// added to forbidden. A panic
+// while doing so, e.g. from an unexpectedly shaped object, is logged and
+// counted, and the VirtualService's partial series are removed so that the
+// refresh continues with the next one.
+func (c *VirtualServiceCollector) recordVirtualService(
+	ctx context.Context,
+	nsName string,
+	vs *v1beta1.VirtualService,
+	gatewayCache map[string]map[string]*v1beta1.Gateway,
+	serviceCache map[string]map[string]bool,
+	attached map[string]map[string]struct{},
+	claims hostClaims,
+	forbidden map[string]bool,
+) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.panics.Inc()
+			logrus.WithField("component", vsCollectorLogPrefix).Errorf("recovered from panic while collecting VirtualService %s/%s: %v", nsName, vs.GetName(), r)
+			c.deleteVirtualService(nsName, vs.GetName())
+			err = nil
+		}
+	}()
 
-					if len(nsGateways) == 0 {
-						value = 0
-					} else {
-						gateway, ok := nsGateways[gwName]
-						if !ok {
-							value = 0
-						} else if !hostsCompatible(vs.Spec.Hosts, gateway) {
-							value = 0
-						}
-					}
+	gateways := vs.Spec.Gateways
+	if len(gateways) == 0 {
+		gateways = []string{"mesh"}
+	}
+
+	meshOnly := 1.0
+	for _, gatewayRef := range gateways {
+		if gatewayRef != "mesh" {
+			meshOnly = 0
+			break
+		}
+	}
+	c.meshOnly.WithLabelValues(nsName, vs.GetName()).Set(meshOnly)
+	c.recordRouteWeights(nsName, vs)
+	c.recordMissingServices(ctx, nsName, vs, serviceCache)
+
+	// Host claims are only recorded once the VirtualService was collected in
+	// full, so that a panic leaves no trace of it.
+	var boundGateways []string
+	for _, gatewayRef := range gateways {
+		labelGateway := gatewayRef
+		value := 1.0
+
+		if gatewayRef == "" {
+			value = 0
+		} else if gatewayRef == "mesh" {
+			// mesh gateway is virtual; assume healthy
+			value = 1
+		} else {
+			gwNamespace := nsName
+			gwName := gatewayRef
+
+			if strings.Contains(gatewayRef, "/") {
+				parts := strings.SplitN(gatewayRef, "/", 2)
+				if len(parts) == 2 {
+					gwNamespace = parts[0]
+					gwName = parts[1]
 				}
+			}
 
-				c.metric.WithLabelValues(nsName, vs.GetName(), labelGateway).Set(value)
+			nsGateways, err := c.ensureGatewaysCached(ctx, gwNamespace, gatewayCache, forbidden)
+			if err != nil {
+				return err
+			}
+
+			gateway, ok := nsGateways[gwName]
+			if forbidden[gwNamespace] {
+				// The Gateway may exist, so it is not reported as dangling.
+				value = 0
+			} else if !ok {
+				value = 0
+				c.dangling.WithLabelValues(gwNamespace, gwName).Set(1)
+			} else {
+				gwKey := gwNamespace + "/" + gwName
+				if attached[gwKey] == nil {
+					attached[gwKey] = make(map[string]struct{})
+				}
+				attached[gwKey][nsName+"/"+vs.GetName()] = struct{}{}
+				boundGateways = append(boundGateways, gwKey)
+				if !hostsCompatible(vs.Spec.Hosts, gateway) {
+					value = 0
+				}
+				for _, server := range matchingServers(vs.Spec.Hosts, gateway) {
+					c.gatewayTLS.WithLabelValues(nsName, vs.GetName(), labelGateway, serverTLSMode(server)).Set(1)
+				}
 			}
 		}
+
+		c.setInfo(nsName, vs.GetName(), labelGateway, value)
 	}
 
+	for _, gwKey := range boundGateways {
+		for _, host := range vs.Spec.Hosts {
+			claims.add(gwKey, host, nsName, vs.GetName())
+		}
+	}
 	return nil
 }
 
-func (c *VirtualServiceCollector) ensureGatewaysCached(ctx context.Context, namespace string, cache map[string]map[string]*v1beta1.Gateway) (map[string]*v1beta1.Gateway, error) {
-	if namespace == "" {
+// hostClaims records, per "namespace/gateway" and host, the namespace of each
+// VirtualService binding the host to the gateway, keyed by "namespace/name".
+type hostClaims map[string]map[string]map[string]string
+
+func (h hostClaims) add(gateway, host, namespace, vs string) {
+	host = strings.ToLower(host)
+	if h[gateway] == nil {
+		h[gateway] = make(map[string]map[string]string)
+	}
+	if h[gateway][host] == nil {
+		h[gateway][host] = make(map[string]string)
+	}
+	h[gateway][host][namespace+"/"+vs] = namespace
+}
+
+// recordHostConflicts publishes every host that VirtualServices of more than
+// one namespace bind to the same gateway, which Istio routes
+// nondeterministically. Claims from a single namespace are left alone.
+func (c *VirtualServiceCollector) recordHostConflicts(claims hostClaims) {
+	for gateway, hosts := range claims {
+		for host, virtualServices := range hosts {
+			namespaces := make(map[string]struct{}, len(virtualServices))
+			for _, namespace := range virtualServices {
+				namespaces[namespace] = struct{}{}
+			}
+			if len(namespaces) > 1 {
+				c.hostClash.WithLabelValues(host, gateway).Set(float64(len(virtualServices)))
+			}
+		}
+	}
+}
+
+// deleteVirtualService removes every series labelled with the VirtualService.
+func (c *VirtualServiceCollector) deleteVirtualService(namespace, name string) {
+	match := prometheus.Labels{"namespace": namespace, "virtual_service": name}
+	for _, vec := range []*prometheus.GaugeVec{c.metric, c.metricRaw, c.meshOnly, c.weightSum, c.weightBad, c.gatewayTLS, c.missingSvc} {
+		vec.DeletePartialMatch(match)
+	}
+}
+
+// setInfo publishes the virtual_service_info value observed for a gateway
+// reference, debounced when enabled: a series published as 1 only drops to 0
+// after c.debounce consecutive 0 readings. New series start at their reading.
+func (c *VirtualServiceCollector) setInfo(namespace, vs, gateway string, value float64) {
+	if c.debounce < 2 {
+		c.metric.WithLabelValues(namespace, vs, gateway).Set(value)
+		return
+	}
+	c.metricRaw.WithLabelValues(namespace, vs, gateway).Set(value)
+
+	key := namespace + "/" + vs + "/" + gateway
+	streak, ok := c.streaks[key]
+	if !ok {
+		streak = &healthStreak{published: value}
+		c.streaks[key] = streak
+	}
+	streak.seen = true
+	if value != 0 {
+		streak.zeros = 0
+		streak.published = value
+	} else {
+		streak.zeros++
+		if streak.zeros >= c.debounce {
+			streak.published = 0
+		}
+	}
+	c.metric.WithLabelValues(namespace, vs, gateway).Set(streak.published)
+}
+
+// pruneStreaks forgets series that were not observed in the last refresh, so
+// a reappearing series starts afresh.
+func (c *VirtualServiceCollector) pruneStreaks() {
+	for key, streak := range c.streaks {
+		if !streak.seen {
+			delete(c.streaks, key)
+			continue
+		}
+		streak.seen = false
+	}
+}
+
+// recordRouteWeights publishes weight sums for HTTP routes that split traffic by
+// weight. Routes where no destination sets a weight are not weighted and skipped.
+func (c *VirtualServiceCollector) recordRouteWeights(namespace string, vs *v1beta1.VirtualService) {
+	for i, route := range vs.Spec.Http {
+		if route == nil {
+			continue
+		}
+
+		var sum int32
+		weighted := false
+		for _, destination := range route.Route {
+			if destination == nil {
+				continue
+			}
+			if destination.Weight != 0 {
+				weighted = true
+			}
+			sum += destination.Weight
+		}
+		if !weighted {
+			continue
+		}
+
+		index := strconv.Itoa(i)
+		c.weightSum.WithLabelValues(namespace, vs.GetName(), index).Set(float64(sum))
+		misconfigured := 0.0
+		if sum != 100 {
+			misconfigured = 1
+		}
+		c.weightBad.WithLabelValues(namespace, vs.GetName(), index).Set(misconfigured)
+	}
+}
+
+// recordMissingServices flags the route destination hosts of vs that refer to
+// a Kubernetes Service that does not exist. Hosts outside the cluster domain,
+// e.g. ServiceEntry hosts, are not checked. Namespaces whose Services cannot
+// be listed are skipped with a warning.
+func (c *VirtualServiceCollector) recordMissingServices(ctx context.Context, namespace string, vs *v1beta1.VirtualService, cache map[string]map[string]bool) {
+	for _, host := range destinationHosts(vs) {
+		name, svcNamespace, ok := serviceForHost(host, namespace)
+		if !ok {
+			continue
+		}
+		services, listed := cache[svcNamespace]
+		if !listed {
+			var err error
+			services, err = c.listServices(ctx, svcNamespace)
+			if err != nil {
+				logrus.WithField("component", vsCollectorLogPrefix).Warnf("unable to list Services in namespace %s, not checking destinations there: %v", svcNamespace, err)
+			}
+			cache[svcNamespace] = services
+		}
+		if services != nil && !services[name] {
+			c.missingSvc.WithLabelValues(namespace, vs.GetName(), host).Set(1)
+		}
+	}
+}
+
+// listServices returns the names of the Services in namespace.
+func (c *VirtualServiceCollector) listServices(ctx context.Context, namespace string) (map[string]bool, error) {
+	list, err := c.kubeClient.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(list.Items))
+	for _, service := range list.Items {
+		names[service.Name] = true
+	}
+	return names, nil
+}
+
+// destinationHosts returns the distinct destination hosts of every HTTP, TLS
+// and TCP route of vs.
+func destinationHosts(vs *v1beta1.VirtualService) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	add := func(destination *networking.Destination) {
+		if destination == nil || destination.Host == "" || seen[destination.Host] {
+			return
+		}
+		seen[destination.Host] = true
+		hosts = append(hosts, destination.Host)
+	}
+	for _, route := range vs.Spec.Http {
+		if route == nil {
+			continue
+		}
+		for _, destination := range route.Route {
+			if destination != nil {
+				add(destination.Destination)
+			}
+		}
+		if route.Mirror != nil {
+			add(route.Mirror)
+		}
+	}
+	for _, route := range vs.Spec.Tls {
+		if route == nil {
+			continue
+		}
+		for _, destination := range route.Route {
+			if destination != nil {
+				add(destination.Destination)
+			}
+		}
+	}
+	for _, route := range vs.Spec.Tcp {
+		if route == nil {
+			continue
+		}
+		for _, destination := range route.Route {
+			if destination != nil {
+				add(destination.Destination)
+			}
+		}
+	}
+	return hosts
+}
+
+// serviceForHost resolves a destination host to a Service name and namespace
+// the way Istio does: a short name without dots is relative to the
+// VirtualService's namespace, and "name.namespace.svc" may be followed by the
+// cluster domain, e.g. "reviews.shop.svc.cluster.local". Any other host is not
+// a Kubernetes Service and ok is false.
+func serviceForHost(host, namespace string) (name, svcNamespace string, ok bool) {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || strings.Contains(host, "*") {
+		return "", "", false
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) == 1 {
+		return parts[0], namespace, true
+	}
+	if len(parts) >= 3 && parts[2] == "svc" && parts[0] != "" && parts[1] != "" {
+		return parts[0], parts[1], true
+	}
+	return "", "", false
+}
+
+// recordPortConflicts flags, per namespace and port, whether servers of two
+// different cached Gateways selecting the same workload share the port with
+// overlapping hosts.
+func (c *VirtualServiceCollector) recordPortConflicts(cache map[string]map[string]*v1beta1.Gateway) {
+	type portServer struct {
+		gateway *v1beta1.Gateway
+		server  *networking.Server
+	}
+
+	for namespace, gateways := range cache {
+		byPort := make(map[uint32][]portServer)
+		for _, gateway := range gateways {
+			for _, server := range gateway.Spec.Servers {
+				if server == nil || server.Port == nil {
+					continue
+				}
+				byPort[server.Port.Number] = append(byPort[server.Port.Number], portServer{gateway, server})
+			}
+		}
+
+		for port, servers := range byPort {
+			conflict := 0.0
+			for i := 0; i < len(servers) && conflict == 0; i++ {
+				for j := i + 1; j < len(servers); j++ {
+					a, b := servers[i], servers[j]
+					if a.gateway == b.gateway || !reflect.DeepEqual(a.gateway.Spec.Selector, b.gateway.Spec.Selector) {
+						continue
+					}
+					if serverMatches(a.server.Hosts, b.server) {
+						conflict = 1
+						break
+					}
+				}
+			}
+			c.portConflict.WithLabelValues(namespace, strconv.FormatUint(uint64(port), 10)).Set(conflict)
+		}
+	}
+}
+
+// ensureGatewaysCached returns the Gateways of namespace, listing them on
+// first use. A namespace whose Gateways may not be listed is added to
+// forbidden and yields no Gateways rather than an error.
+func (c *VirtualServiceCollector) ensureGatewaysCached(ctx context.Context, namespace string, cache map[string]map[string]*v1beta1.Gateway, forbidden map[string]bool) (map[string]*v1beta1.Gateway, error) {
+	if namespace == "" || forbidden[namespace] {
 		return nil, nil
 	}
 
@@ -168,65 +909,212 @@ func (c *VirtualServiceCollector) ensureGatewaysCached(ctx context.Context, name
 		return gateways, nil
 	}
 
-	list, err := c.istioClient.NetworkingV1beta1().Gateways(namespace).List(ctx, metav1.ListOptions{})
+	gateways, err := c.listGateways(ctx, namespace)
+	if apierrors.IsForbidden(err) {
+		forbidden[namespace] = true
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	result := make(map[string]*v1beta1.Gateway, len(list.Items))
-	for _, gateway := range list.Items {
+	result := gatewaysByName(gateways)
+	cache[namespace] = result
+	return result, nil
+}
+
+// prefetchGateways caches the Gateways of every namespace, listing up to
+// gatewayConcurrency namespaces at once. cache is written by the list
+// goroutines and must not be read until it returns. Namespaces whose Gateways
+// may not be listed are added to forbidden instead. Any other error cancels
+// the lists still running and is returned.
+func (c *VirtualServiceCollector) prefetchGateways(ctx context.Context, namespaces []corev1.Namespace, cache map[string]map[string]*v1beta1.Gateway, forbidden map[string]bool) error {
+	limit := c.gatewayConcurrency
+	if limit < 1 {
+		limit = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	workers := make(chan struct{}, limit)
+	for _, namespace := range namespaces {
+		name := namespace.GetName()
+		if name == "" {
+			continue
+		}
+		workers <- struct{}{}
+		if ctx.Err() != nil {
+			<-workers
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			gateways, err := c.listGateways(ctx, name)
+			mu.Lock()
+			defer mu.Unlock()
+			if apierrors.IsForbidden(err) {
+				forbidden[name] = true
+				return
+			}
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			cache[name] = gatewaysByName(gateways)
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// gatewaysByName indexes gateways by name, skipping nil entries.
+func gatewaysByName(gateways []*v1beta1.Gateway) map[string]*v1beta1.Gateway {
+	result := make(map[string]*v1beta1.Gateway, len(gateways))
+	for _, gateway := range gateways {
 		if gateway == nil {
 			continue
 		}
 		result[gateway.GetName()] = gateway
 	}
+	return result
+}
 
-	cache[namespace] = result
-	return result, nil
+// listGateways returns the Gateways in namespace from the informer cache when
+// enabled, or from the API server otherwise. Cached objects must not be modified.
+func (c *VirtualServiceCollector) listGateways(ctx context.Context, namespace string) ([]*v1beta1.Gateway, error) {
+	if c.gatewayLister != nil {
+		return c.gatewayLister.Gateways(namespace).List(labels.Everything())
+	}
+	list, err := c.istioClient.NetworkingV1beta1().Gateways(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// listVirtualServices is the VirtualService counterpart of listGateways.
+func (c *VirtualServiceCollector) listVirtualServices(ctx context.Context, namespace string) ([]*v1beta1.VirtualService, error) {
+	if c.vsLister != nil {
+		return c.vsLister.VirtualServices(namespace).List(labels.Everything())
+	}
+	list, err := c.istioClient.NetworkingV1beta1().VirtualServices(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
 }
 
 func hostsCompatible(vsHosts []string, gateway *v1beta1.Gateway) bool {
 	if gateway == nil {
 		return false
 	}
-
 	if len(vsHosts) == 0 {
 		return true
 	}
+	return len(matchingServers(vsHosts, gateway)) > 0
+}
 
-	var gatewayHosts []string
+// matchingServers returns the gateway servers whose hosts match any of the
+// VirtualService hosts. A VirtualService without hosts matches every server
+// that declares hosts.
+func matchingServers(vsHosts []string, gateway *v1beta1.Gateway) []*networking.Server {
+	if gateway == nil {
+		return nil
+	}
+
+	var matched []*networking.Server
 	for _, server := range gateway.Spec.Servers {
-		if server == nil {
+		if server == nil || len(server.Hosts) == 0 {
 			continue
 		}
-		gatewayHosts = append(gatewayHosts, server.Hosts...)
-	}
-
-	if len(gatewayHosts) == 0 {
-		return false
+		if len(vsHosts) == 0 || serverMatches(vsHosts, server) {
+			matched = append(matched, server)
+		}
 	}
+	return matched
+}
 
+func serverMatches(vsHosts []string, server *networking.Server) bool {
 	for _, vsHost := range vsHosts {
-		for _, gwHost := range gatewayHosts {
+		for _, gwHost := range server.Hosts {
 			if hostMatches(gwHost, vsHost) || hostMatches(vsHost, gwHost) {
 				return true
 			}
 		}
 	}
-
 	return false
 }
 
+// serverTLSMode describes how a gateway server terminates TLS: NONE for
+// plaintext, HTTPS_REDIRECT for redirect-only servers, else the TLS mode.
+func serverTLSMode(server *networking.Server) string {
+	switch {
+	case server.Tls == nil:
+		return "NONE"
+	case server.Tls.HttpsRedirect:
+		return "HTTPS_REDIRECT"
+	default:
+		return server.Tls.Mode.String()
+	}
+}
+
+// hostMatches reports whether host is covered by pattern. A "*." wildcard
+// matches one or more whole, non-empty leading DNS labels, so "*.example.com"
+// covers "a.example.com" and "a.b.example.com" but neither "example.com" nor
+// "evil-example.com". Comparison is case-insensitive and ignores a trailing dot.
 func hostMatches(pattern, host string) bool {
-	if pattern == "" {
+	if pattern == "" || host == "" {
 		return false
 	}
-	if pattern == "*" || pattern == host {
+	if pattern == "*" {
 		return true
 	}
-	if strings.HasPrefix(pattern, "*.") {
-		suffix := strings.TrimPrefix(pattern, "*")
-		return strings.HasSuffix(host, suffix)
+	pattern = normalizeHost(pattern)
+	host = normalizeHost(host)
+	if pattern == host {
+		return true
 	}
-	return false
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+
+	suffix := strings.Split(strings.TrimPrefix(pattern, "*."), ".")
+	labels := strings.Split(host, ".")
+	if len(labels) <= len(suffix) {
+		return false
+	}
+	for _, label := range suffix {
+		if label == "" {
+			return false
+		}
+	}
+
+	leading := len(labels) - len(suffix)
+	for _, label := range labels[:leading] {
+		if label == "" {
+			return false
+		}
+	}
+	for i, label := range suffix {
+		if labels[leading+i] != label {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
 }
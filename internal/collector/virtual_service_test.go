@@ -0,0 +1,656 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	networking "istio.io/api/networking/v1beta1"
+	v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istiofake "istio.io/client-go/pkg/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"vs_exporter/internal/clock"
+)
+
+func TestUpdateResolvesGatewayHealth(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{
+			newNamespace("shop", map[string]string{"product": "shop"}),
+			newNamespace("unlabelled", nil),
+		},
+		[]runtime.Object{
+			newGateway("istio-system", "ingress", "*.example.com"),
+			newVirtualService("shop", "frontend", []string{"shop.example.com"}, "istio-system/ingress"),
+			newVirtualService("shop", "broken", []string{"shop.other.com"}, "istio-system/ingress"),
+			newVirtualService("shop", "missing", []string{"shop.example.com"}, "istio-system/absent"),
+			newVirtualService("shop", "internal", []string{"internal"}),
+			newVirtualService("unlabelled", "ignored", []string{"x.example.com"}, "istio-system/ingress"),
+		},
+	)
+
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+
+	cases := []struct {
+		vs, gateway string
+		want        float64
+	}{
+		{"frontend", "istio-system/ingress", 1},
+		{"broken", "istio-system/ingress", 0},
+		{"missing", "istio-system/absent", 0},
+		{"internal", "mesh", 1},
+	}
+	for _, tc := range cases {
+		got := testutil.ToFloat64(col.metric.WithLabelValues("shop", tc.vs, tc.gateway))
+		if got != tc.want {
+			t.Errorf("%s via %s: expected %v, got %v", tc.vs, tc.gateway, tc.want, got)
+		}
+	}
+
+	if count := testutil.CollectAndCount(col.metric); count != len(cases) {
+		t.Fatalf("expected %d series, got %d", len(cases), count)
+	}
+	if got := testutil.ToFloat64(col.meshOnly.WithLabelValues("shop", "internal")); got != 1 {
+		t.Fatalf("expected internal VirtualService to be mesh-only, got %v", got)
+	}
+	if got := testutil.ToFloat64(col.meshOnly.WithLabelValues("shop", "frontend")); got != 0 {
+		t.Fatalf("expected frontend VirtualService not to be mesh-only, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(col.attached.WithLabelValues("istio-system", "ingress")); got != 2 {
+		t.Fatalf("expected 2 VirtualServices attached to ingress, got %v", got)
+	}
+	if got := testutil.ToFloat64(col.dangling.WithLabelValues("istio-system", "absent")); got != 1 {
+		t.Fatalf("expected istio-system/absent to be reported as dangling, got %v", got)
+	}
+	if count := testutil.CollectAndCount(col.dangling); count != 1 {
+		t.Fatalf("expected only one dangling gateway reference, got %d", count)
+	}
+
+	if got := testutil.ToFloat64(col.updateCount); got != 1 {
+		t.Fatalf("expected update counter 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(col.gwCached); got != 1 {
+		t.Fatalf("expected 1 cached Gateway, got %v", got)
+	}
+	if got := testutil.ToFloat64(col.vsTotal); got != 4 {
+		t.Fatalf("expected 4 processed VirtualServices, got %v", got)
+	}
+}
+
+func TestUpdateSetsCollectorTimestamp(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{newNamespace("shop", map[string]string{"product": "shop"})},
+		nil,
+		WithClock(clock.NewFake(time.Unix(1700, 0))),
+	)
+
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+	if got := testutil.ToFloat64(col.timestamp); got != 1700 {
+		t.Fatalf("expected collector timestamp 1700, got %v", got)
+	}
+}
+
+func TestUpdatePrefetchesGatewaysConcurrently(t *testing.T) {
+	var namespaces, gateways []runtime.Object
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		namespaces = append(namespaces, newNamespace(name, map[string]string{"product": name}))
+		gateways = append(gateways, newGateway(name, "ingress", "*.example.com"))
+	}
+
+	for _, failing := range []string{"", "c"} {
+		col := newTestCollector(t, namespaces, gateways, WithGatewayConcurrency(3))
+		var mu sync.Mutex
+		lists := make(map[string]int)
+		col.istioClient.(*istiofake.Clientset).PrependReactor("list", "gateways", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			lists[action.GetNamespace()]++
+			if action.GetNamespace() == failing {
+				return true, nil, errors.New("apiserver unavailable")
+			}
+			return false, nil, nil
+		})
+
+		err := col.update(context.Background())
+		if failing != "" {
+			if err == nil {
+				t.Fatalf("expected the Gateway list error of namespace %s to fail the refresh", failing)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("update() error = %v", err)
+		}
+		if len(lists) != 5 {
+			t.Fatalf("expected every namespace to be listed, got %v", lists)
+		}
+		for namespace, count := range lists {
+			if count != 1 {
+				t.Fatalf("expected namespace %s to be listed once, got %d", namespace, count)
+			}
+		}
+		if count := testutil.CollectAndCount(col.attached); count != 5 {
+			t.Fatalf("expected an attachment series per prefetched Gateway, got %d", count)
+		}
+	}
+}
+
+func TestUpdateSkipsForbiddenNamespaces(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{
+			newNamespace("shop", map[string]string{"product": "shop"}),
+			newNamespace("locked", map[string]string{"product": "locked"}),
+			newNamespace("sealed", map[string]string{"product": "sealed"}),
+		},
+		[]runtime.Object{
+			newVirtualService("shop", "frontend", []string{"shop.example.com"}),
+			newVirtualService("locked", "hidden", []string{"locked.example.com"}),
+			newVirtualService("sealed", "hidden", []string{"sealed.example.com"}),
+		},
+	)
+	forbid := func(resource, namespace string) {
+		col.istioClient.(*istiofake.Clientset).PrependReactor("list", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetNamespace() != namespace {
+				return false, nil, nil
+			}
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "networking.istio.io", Resource: resource}, "", errors.New("denied"))
+		})
+	}
+	forbid("virtualservices", "locked")
+	forbid("gateways", "sealed")
+
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+	if got := testutil.ToFloat64(col.metric.WithLabelValues("shop", "frontend", "mesh")); got != 1 {
+		t.Fatalf("expected the readable namespace to be collected, got %v", got)
+	}
+	if count := testutil.CollectAndCount(col.metric); count != 1 {
+		t.Fatalf("expected only the readable namespace's series, got %d", count)
+	}
+	for _, namespace := range []string{"locked", "sealed"} {
+		if got := testutil.ToFloat64(col.forbidden.WithLabelValues(namespace)); got != 1 {
+			t.Fatalf("expected namespace %s to be reported as forbidden, got %v", namespace, got)
+		}
+	}
+}
+
+func TestUpdateReportsForbiddenCrossNamespaceGatewayAsUnresolved(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{newNamespace("shop", map[string]string{"product": "shop"})},
+		[]runtime.Object{
+			newVirtualService("shop", "frontend", []string{"shop.example.com"}, "istio-system/ingress"),
+			newVirtualService("shop", "backend", []string{"api.example.com"}, "mesh"),
+		},
+	)
+	col.istioClient.(*istiofake.Clientset).PrependReactor("list", "gateways", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() != "istio-system" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "networking.istio.io", Resource: "gateways"}, "", errors.New("denied"))
+	})
+
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+	if got := testutil.ToFloat64(col.metric.WithLabelValues("shop", "frontend", "istio-system/ingress")); got != 0 {
+		t.Fatalf("expected the forbidden Gateway to be unresolved, got %v", got)
+	}
+	if got := testutil.ToFloat64(col.metric.WithLabelValues("shop", "backend", "mesh")); got != 1 {
+		t.Fatalf("expected the rest of the namespace to be collected, got %v", got)
+	}
+	if count := testutil.CollectAndCount(col.dangling); count != 0 {
+		t.Fatalf("expected the forbidden Gateway not to be reported as dangling, got %d series", count)
+	}
+	if got := testutil.ToFloat64(col.forbidden.WithLabelValues("istio-system")); got != 1 {
+		t.Fatalf("expected istio-system to be reported as forbidden, got %v", got)
+	}
+}
+
+func TestUpdateRecoversFromPanickingVirtualService(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{newNamespace("shop", map[string]string{"product": "shop"})},
+		[]runtime.Object{
+			newGateway("shop", "ingress", "*.example.com"),
+			newVirtualService("shop", "a-bad", []string{"shop.example.com"}, "mesh", "cursed/ingress"),
+			newVirtualService("shop", "b-good", []string{"shop.example.com"}, "ingress"),
+		},
+	)
+	col.istioClient.(*istiofake.Clientset).PrependReactor("list", "gateways", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "cursed" {
+			panic("malformed gateway")
+		}
+		return false, nil, nil
+	})
+
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+
+	if got := testutil.ToFloat64(col.panics); got != 1 {
+		t.Fatalf("expected 1 recovered panic, got %v", got)
+	}
+	if got := testutil.ToFloat64(col.metric.WithLabelValues("shop", "b-good", "ingress")); got != 1 {
+		t.Fatalf("expected the next VirtualService to be collected, got %v", got)
+	}
+	if count := testutil.CollectAndCount(col.metric); count != 1 {
+		t.Fatalf("expected the panicking VirtualService's partial series to be removed, got %d series", count)
+	}
+	if count := testutil.CollectAndCount(col.meshOnly); count != 1 {
+		t.Fatalf("expected one mesh-only series, got %d", count)
+	}
+}
+
+func TestUpdateDebouncesGatewayHealth(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{newNamespace("shop", map[string]string{"product": "shop"})},
+		[]runtime.Object{
+			newGateway("shop", "ingress", "*.example.com"),
+			newVirtualService("shop", "frontend", []string{"shop.example.com"}, "ingress"),
+			newVirtualService("shop", "missing", []string{"shop.example.com"}, "absent"),
+		},
+		WithHealthDebounce(3),
+	)
+	ctx := context.Background()
+
+	if err := col.update(ctx); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+	if got := testutil.ToFloat64(col.metric.WithLabelValues("shop", "missing", "absent")); got != 0 {
+		t.Fatalf("expected a new series to start at its reading 0, got %v", got)
+	}
+
+	if err := col.istioClient.NetworkingV1beta1().Gateways("shop").Delete(ctx, "ingress", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete gateway: %v", err)
+	}
+	for cycle, want := range []float64{1, 1, 0} {
+		if err := col.update(ctx); err != nil {
+			t.Fatalf("update() error = %v", err)
+		}
+		if got := testutil.ToFloat64(col.metricRaw.WithLabelValues("shop", "frontend", "ingress")); got != 0 {
+			t.Fatalf("cycle %d: expected raw value 0, got %v", cycle, got)
+		}
+		if got := testutil.ToFloat64(col.metric.WithLabelValues("shop", "frontend", "ingress")); got != want {
+			t.Fatalf("cycle %d: expected debounced value %v, got %v", cycle, want, got)
+		}
+	}
+}
+
+func TestUpdateRecordsMissingServices(t *testing.T) {
+	vs := newVirtualService("shop", "frontend", []string{"shop.example.com"})
+	vs.Spec.Http = []*networking.HTTPRoute{{
+		Route: []*networking.HTTPRouteDestination{
+			{Destination: &networking.Destination{Host: "reviews"}},
+			{Destination: &networking.Destination{Host: "ratings.shop.svc.cluster.local"}},
+			{Destination: &networking.Destination{Host: "api.example.com"}},
+		},
+		Mirror: &networking.Destination{Host: "reviews.shop.svc"},
+	}}
+	vs.Spec.Tcp = []*networking.TCPRoute{{
+		Route: []*networking.RouteDestination{{Destination: &networking.Destination{Host: "db.data.svc.cluster.local"}}},
+	}}
+	col := newTestCollector(t,
+		[]runtime.Object{
+			newNamespace("shop", map[string]string{"product": "shop"}),
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "reviews"}},
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "data", Name: "cache"}},
+		},
+		[]runtime.Object{vs},
+	)
+
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+
+	for _, host := range []string{"ratings.shop.svc.cluster.local", "db.data.svc.cluster.local"} {
+		if got := testutil.ToFloat64(col.missingSvc.WithLabelValues("shop", "frontend", host)); got != 1 {
+			t.Errorf("expected %s to be reported missing, got %v", host, got)
+		}
+	}
+	if count := testutil.CollectAndCount(col.missingSvc); count != 2 {
+		t.Fatalf("expected 2 missing services, got %d", count)
+	}
+}
+
+func TestUpdateRecordsHostConflictsAcrossNamespaces(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{
+			newNamespace("shop", map[string]string{"product": "shop"}),
+			newNamespace("cart", map[string]string{"product": "cart"}),
+		},
+		[]runtime.Object{
+			newGateway("istio-system", "ingress", "*.example.com"),
+			newVirtualService("shop", "frontend", []string{"shop.example.com", "api.example.com"}, "istio-system/ingress"),
+			newVirtualService("shop", "frontend-v2", []string{"api.example.com"}, "istio-system/ingress"),
+			newVirtualService("cart", "checkout", []string{"Shop.example.com"}, "istio-system/ingress"),
+		},
+	)
+
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+	if got := testutil.ToFloat64(col.hostClash.WithLabelValues("shop.example.com", "istio-system/ingress")); got != 2 {
+		t.Fatalf("expected 2 VirtualServices claiming shop.example.com, got %v", got)
+	}
+	// api.example.com is only claimed within the shop namespace.
+	if count := testutil.CollectAndCount(col.hostClash); count != 1 {
+		t.Fatalf("expected only one conflicting host, got %d", count)
+	}
+}
+
+func TestServiceForHost(t *testing.T) {
+	cases := []struct {
+		host, name, namespace string
+		ok                    bool
+	}{
+		{"reviews", "reviews", "shop", true},
+		{"reviews.other.svc.cluster.local", "reviews", "other", true},
+		{"reviews.other.svc", "reviews", "other", true},
+		{"reviews.other", "", "", false},
+		{"api.example.com", "", "", false},
+		{"*.shop.svc.cluster.local", "", "", false},
+	}
+	for _, tc := range cases {
+		name, namespace, ok := serviceForHost(tc.host, "shop")
+		if name != tc.name || namespace != tc.namespace || ok != tc.ok {
+			t.Errorf("serviceForHost(%q) = %q, %q, %v; want %q, %q, %v", tc.host, name, namespace, ok, tc.name, tc.namespace, tc.ok)
+		}
+	}
+}
+
+func TestUpdateRecordsRouteWeights(t *testing.T) {
+	split := newVirtualService("shop", "split", []string{"shop"})
+	split.Spec.Http = []*networking.HTTPRoute{
+		{Route: []*networking.HTTPRouteDestination{
+			{Destination: &networking.Destination{Host: "v1"}, Weight: 90},
+			{Destination: &networking.Destination{Host: "v2"}, Weight: 10},
+		}},
+		{Route: []*networking.HTTPRouteDestination{
+			{Destination: &networking.Destination{Host: "v1"}, Weight: 50},
+			{Destination: &networking.Destination{Host: "v2"}, Weight: 30},
+		}},
+		{Route: []*networking.HTTPRouteDestination{
+			{Destination: &networking.Destination{Host: "v1"}},
+		}},
+	}
+
+	col := newTestCollector(t,
+		[]runtime.Object{newNamespace("shop", map[string]string{"product": "shop"})},
+		[]runtime.Object{split},
+	)
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+
+	if got := testutil.ToFloat64(col.weightSum.WithLabelValues("shop", "split", "1")); got != 80 {
+		t.Fatalf("expected weight sum 80 for route 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(col.weightBad.WithLabelValues("shop", "split", "0")); got != 0 {
+		t.Fatalf("expected route 0 to be well configured, got %v", got)
+	}
+	if got := testutil.ToFloat64(col.weightBad.WithLabelValues("shop", "split", "1")); got != 1 {
+		t.Fatalf("expected route 1 to be misconfigured, got %v", got)
+	}
+	if count := testutil.CollectAndCount(col.weightSum); count != 2 {
+		t.Fatalf("expected unweighted route to be skipped, got %d series", count)
+	}
+}
+
+func TestUpdateRecordsGatewayTLSModes(t *testing.T) {
+	gateway := newGateway("istio-system", "ingress", "*.example.com")
+	gateway.Spec.Servers = append(gateway.Spec.Servers,
+		&networking.Server{
+			Port:  &networking.Port{Number: 443, Name: "https", Protocol: "HTTPS"},
+			Hosts: []string{"secure.example.com"},
+			Tls:   &networking.ServerTLSSettings{Mode: networking.ServerTLSSettings_SIMPLE},
+		},
+		&networking.Server{
+			Port:  &networking.Port{Number: 443, Name: "https-other", Protocol: "HTTPS"},
+			Hosts: []string{"other.test"},
+			Tls:   &networking.ServerTLSSettings{Mode: networking.ServerTLSSettings_MUTUAL},
+		},
+	)
+
+	col := newTestCollector(t,
+		[]runtime.Object{newNamespace("shop", map[string]string{"product": "shop"})},
+		[]runtime.Object{
+			gateway,
+			newVirtualService("shop", "secure", []string{"secure.example.com"}, "istio-system/ingress"),
+		},
+	)
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+
+	for _, mode := range []string{"NONE", "SIMPLE"} {
+		if got := testutil.ToFloat64(col.gatewayTLS.WithLabelValues("shop", "secure", "istio-system/ingress", mode)); got != 1 {
+			t.Errorf("expected tls_mode %s to be reported, got %v", mode, got)
+		}
+	}
+	if count := testutil.CollectAndCount(col.gatewayTLS); count != 2 {
+		t.Fatalf("expected the non-matching MUTUAL server to be skipped, got %d series", count)
+	}
+}
+
+func TestUpdateRecordsGatewayPortConflicts(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{newNamespace("shop", map[string]string{"product": "shop"})},
+		[]runtime.Object{
+			newGateway("istio-system", "public", "*.example.com"),
+			newGateway("istio-system", "shop", "shop.example.com"),
+			newGateway("istio-system", "internal", "*.internal"),
+			newVirtualService("shop", "frontend", []string{"shop.example.com"}, "istio-system/public"),
+		},
+	)
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+
+	if got := testutil.ToFloat64(col.portConflict.WithLabelValues("istio-system", "80")); got != 1 {
+		t.Fatalf("expected a conflict on port 80, got %v", got)
+	}
+}
+
+func TestUpdateOnceWithInformers(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{newNamespace("shop", map[string]string{"product": "shop"})},
+		[]runtime.Object{
+			newGateway("istio-system", "ingress", "*.example.com"),
+			newVirtualService("shop", "frontend", []string{"shop.example.com"}, "istio-system/ingress"),
+		},
+		WithInformers(0),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := col.UpdateOnce(ctx); err != nil {
+		t.Fatalf("UpdateOnce() error = %v", err)
+	}
+
+	if got := testutil.ToFloat64(col.metric.WithLabelValues("shop", "frontend", "istio-system/ingress")); got != 1 {
+		t.Fatalf("expected frontend to resolve its gateway from the informer cache, got %v", got)
+	}
+	if got := testutil.ToFloat64(col.attached.WithLabelValues("istio-system", "ingress")); got != 1 {
+		t.Fatalf("expected 1 VirtualService attached to ingress, got %v", got)
+	}
+}
+
+func TestMetricPrefix(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{newNamespace("shop", map[string]string{"product": "shop"})},
+		[]runtime.Object{newVirtualService("shop", "frontend", []string{"shop.example.com"})},
+		WithMetricPrefix("acme"),
+	)
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(col)
+	if n, err := testutil.GatherAndCount(registry, "acme_virtual_service_info", "acme_virtualservice_metrics_update"); err != nil || n != 2 {
+		t.Fatalf("expected the info gauge and update counter under the acme prefix, got %d series (err %v)", n, err)
+	}
+	if n, err := testutil.GatherAndCount(registry, "istio_virtual_service_info"); err != nil || n != 0 {
+		t.Fatalf("expected no istio_ series, got %d (err %v)", n, err)
+	}
+	if got := testutil.ToFloat64(col.legacyUpdates); got != 1 {
+		t.Fatalf("deprecated %s = %v, want 1", legacyUpdateCountName, got)
+	}
+}
+
+func TestLegacyUpdateCounterIsNotDuplicated(t *testing.T) {
+	col := newTestCollector(t,
+		[]runtime.Object{newNamespace("shop", map[string]string{"product": "shop"})},
+		nil,
+		WithMetricPrefix("platform"),
+	)
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(col); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := col.update(context.Background()); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+	if n, err := testutil.GatherAndCount(registry, legacyUpdateCountName); err != nil || n != 1 {
+		t.Fatalf("expected one %s series, got %d (err %v)", legacyUpdateCountName, n, err)
+	}
+}
+
+func TestHostMatches(t *testing.T) {
+	cases := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"*", "anything.example.com", true},
+		{"shop.example.com", "shop.example.com", true},
+		{"*.example.com", "shop.example.com", true},
+		{"*.example.com", "example.org", false},
+		{"", "shop.example.com", false},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "Shop.Example.COM.", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", ".example.com", false},
+		{"*.example.com", "a..example.com", false},
+		{"*.example.com", "evil-example.com", false},
+		{"*.example.com", "fooexample.com", false},
+		{"*.example.com", "shop.example.com.attacker.com", false},
+		{"*.", "shop.example.com", false},
+		{"*..com", "shop.example.com", false},
+		{"shop.example.com", "", false},
+	}
+	for _, tc := range cases {
+		if got := hostMatches(tc.pattern, tc.host); got != tc.want {
+			t.Errorf("hostMatches(%q, %q) = %v, want %v", tc.pattern, tc.host, got, tc.want)
+		}
+	}
+}
+
+// newTestCollector wires a collector against fake Kubernetes and Istio clientsets.
+// Istio objects are created through the typed client because the fake tracker
+// guesses the plural of "Gateway" as "gatewaies" when seeded directly.
+func newTestCollector(t *testing.T, kubeObjects, istioObjects []runtime.Object, opts ...Option) *VirtualServiceCollector {
+	t.Helper()
+	istioClient := istiofake.NewSimpleClientset()
+	ctx := context.Background()
+	for _, obj := range istioObjects {
+		var err error
+		switch o := obj.(type) {
+		case *v1beta1.Gateway:
+			_, err = istioClient.NetworkingV1beta1().Gateways(o.Namespace).Create(ctx, o, metav1.CreateOptions{})
+		case *v1beta1.VirtualService:
+			_, err = istioClient.NetworkingV1beta1().VirtualServices(o.Namespace).Create(ctx, o, metav1.CreateOptions{})
+		default:
+			t.Fatalf("unsupported Istio object %T", obj)
+		}
+		if err != nil {
+			t.Fatalf("failed to seed Istio object: %v", err)
+		}
+	}
+
+	col, err := NewVirtualServiceCollector(kubefake.NewSimpleClientset(kubeObjects...), istioClient, prometheus.NewRegistry(), opts...)
+	if err != nil {
+		t.Fatalf("NewVirtualServiceCollector() error = %v", err)
+	}
+	return col
+}
+
+func TestInformerSyncFailsWhenListIsForbidden(t *testing.T) {
+	istioClient := istiofake.NewSimpleClientset()
+	for _, verb := range []string{"list", "watch"} {
+		istioClient.PrependReactor(verb, "virtualservices", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "networking.istio.io", Resource: "virtualservices"}, "", errors.New("rbac"))
+		})
+	}
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	col, err := NewVirtualServiceCollector(kubefake.NewSimpleClientset(), istioClient, prometheus.NewRegistry(), WithInformers(0), WithClock(fakeClock))
+	if err != nil {
+		t.Fatalf("NewVirtualServiceCollector() error = %v", err)
+	}
+	col.syncTimeout = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := col.UpdateOnce(ctx); err == nil {
+		t.Fatal("UpdateOnce() error = nil, want a sync timeout")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		col.Run(ctx, 100*time.Millisecond)
+	}()
+	// Run waits out the interval on the fake clock after a failed sync.
+	fakeClock.BlockUntil(1)
+	if col.Ready() {
+		t.Fatal("expected the collector to stay unready while its caches cannot sync")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+}
+
+func newNamespace(name string, labels map[string]string) runtime.Object {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func newGateway(namespace, name string, hosts ...string) *v1beta1.Gateway {
+	return &v1beta1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: networking.Gateway{
+			Servers: []*networking.Server{
+				{
+					Port:  &networking.Port{Number: 80, Name: "http", Protocol: "HTTP"},
+					Hosts: hosts,
+				},
+			},
+		},
+	}
+}
+
+func newVirtualService(namespace, name string, hosts []string, gateways ...string) *v1beta1.VirtualService {
+	return &v1beta1.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: networking.VirtualService{
+			Hosts:    hosts,
+			Gateways: gateways,
+		},
+	}
+}
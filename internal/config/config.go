@@ -1,8 +1,17 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"time"
 
 	"sigs.k8s.io/yaml"
@@ -15,6 +24,71 @@ type Config struct {
 	VirtualServiceInterval        time.Duration
 	EnableVirtualServiceScrapeJob bool
 	ProductMetrics                []ProductMetricsTarget
+	// StartupDelay 為首次抓取前的固定延遲，StartupJitter 則為每個抓取器額外的隨機延遲上限。
+	StartupDelay  time.Duration
+	StartupJitter time.Duration
+	// ScrapeSourceIP 若設定，抓取連線會綁定此本機位址作為來源 IP。
+	ScrapeSourceIP string
+	// ClusterName 若設定，會以 cluster label 附加於本服務自身的指標。
+	ClusterName string
+	// HTTPBearerToken 為呼叫 /-/reload 時須帶上的 Authorization: Bearer <token>。
+	HTTPBearerToken string
+	// EnableReloadEndpoint 開啟 POST /-/reload；必須同時設定 HTTPBearerToken。
+	EnableReloadEndpoint bool
+	// KubeCAFile 若設定，以此 CA 檔驗證 Kubernetes API server 憑證。
+	KubeCAFile string
+	// KubeQPS 與 KubeBurst 設定 Kubernetes client 的速率限制；0 表示沿用 client-go 預設值（5／10）。
+	KubeQPS   float32
+	KubeBurst int
+	// VirtualServiceInformers 以共用 informer 快取讀取 Gateway 與 VirtualService，取代每個 namespace 的 List。
+	VirtualServiceInformers bool
+	// GlobalMaxConcurrentScrapes 限制所有抓取目標同時進行的 HTTP 抓取總數；0 表示不限制。
+	GlobalMaxConcurrentScrapes int
+	// MaxActiveScrapeGoroutines 限制所有抓取目標同時正在抓取 pod 的 goroutine 總數，達上限時抓取排隊等待而不再產生新的 goroutine；
+	// 具 scrape-interval annotation 的 pod 各自常駐的排程 goroutine 在閒置時不計入。0 表示不限制。
+	MaxActiveScrapeGoroutines int
+	// WatchdogMultiplier 若大於 0，抓取器超過 interval 的此倍數仍未完成週期即視為停滯；
+	// WatchdogAction 為 restart（預設，重建抓取器）或 panic（交由 Kubernetes 重啟）。
+	WatchdogMultiplier int
+	WatchdogAction     string
+	// DedupEndpoints 讓重疊的 target 只由最先抓取者抓取同一個 pod endpoint。
+	DedupEndpoints bool
+	// IsolateTargets 以 job=<target> label 區分各 target 的指標，而非將同名指標族不分來源地合併。
+	IsolateTargets bool
+	// MetricPrefix 為 VirtualService collector 所有指標名稱的前綴，預設 istio。
+	MetricPrefix string
+	// VirtualServiceHealthDebounce 若大於 1，istio_virtual_service_info 需連續此次數讀到 0 才會由 1 轉為 0；
+	// 未經平滑的值另以 istio_virtual_service_info_raw 匯出。
+	VirtualServiceHealthDebounce int
+	// FailOnMissingRBAC 讓啟動時的 RBAC 自我檢查在缺少權限時直接結束程式，而非僅記錄錯誤。
+	FailOnMissingRBAC bool
+	// ScrapeDNSServer 為抓取時解析主機名稱所用的 DNS 伺服器（host:port），取代系統的 resolv.conf；以 pod IP 抓取不受影響。
+	ScrapeDNSServer string
+	// ServiceMonitorDiscovery 在啟動（及重新載入）時讀取符合 ServiceMonitorSelector 的 ServiceMonitor，轉換為額外的抓取目標。
+	ServiceMonitorDiscovery bool
+	ServiceMonitorSelector  string
+	// DebugRawSnapshots 保留各 target 合併前的每個 pod 指標，並於 /debug/targets/{target} 提供，用於除錯。
+	DebugRawSnapshots bool
+	// OnHelpConflict 決定不同 target 對同名指標給出不同 HELP 時的處理：first（預設，保留依 target 名稱排序的第一個）、
+	// longest（保留最長者）、drop（省略 HELP）或 warn（同 first，並累加 product_help_conflicts_total）。
+	OnHelpConflict string
+	// MeshCertDir 為 meshTLS target 所用 Istio 工作負載憑證（cert-chain.pem、key.pem、root-cert.pem）的目錄，
+	// 預設 /var/run/secrets/workload-spiffe-credentials；憑證輪替後會自動重新載入。
+	MeshCertDir string
+	// MetricsCacheTTL 若大於 0，/metrics 的輸出會快取此時間並供同時間的請求共用，產品指標更新時立即失效；0（預設）表示每次請求都重新產生。
+	MetricsCacheTTL time.Duration
+	// CollectorGatewayConcurrency 為 VirtualService collector 每次更新開始時同時列出 Gateway 的 namespace 數上限，預設 1（依序列出）。
+	CollectorGatewayConcurrency int
+	// MinTLSVersion 為 HTTPS 抓取（含 meshTLS）允許的最低 TLS 版本，設定檔以 "1.0" 至 "1.3" 表示，預設 1.2。
+	// TLSCipherSuites 若設定，限制 TLS 1.2 以下可用的 cipher suite（以 Go 的名稱表示，如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256）；TLS 1.3 不受影響。
+	MinTLSVersion   uint16
+	TLSCipherSuites []uint16
+	// DumpInterval 若大於 0，每隔此時間將合併後的指標寫到 DumpOutput（"-" 或未設定表示 stdout，否則為每次覆寫的檔案），
+	// 供無法由 Prometheus 抓取、但有日誌收集管線的環境使用；HTTP 端點照常提供。
+	DumpInterval time.Duration
+	DumpOutput   string
+	// NamespaceRollup 為每個產品指標額外輸出 namespace="__all__" 的序列，其值為各 namespace 同 label 序列的總和（summary 除外）。
+	NamespaceRollup bool
 }
 
 // ProductMetricsTarget 敘述單一產品指標抓取器的參數。
@@ -25,6 +99,90 @@ type ProductMetricsTarget struct {
 	Path              string
 	NamespaceSelector string
 	PodSelector       string
+	// PodSelectors 列出額外的 pod selector，與 PodSelector 以 OR 結合，符合任一者的 pod（依 UID 去重）皆會被抓取。
+	PodSelectors []string
+	// PortNamePattern 以 path.Match 樣式比對容器 port 名稱，符合者皆會被抓取。
+	PortNamePattern string
+	// SlowScrapeThreshold 與 LargeResponseThreshold 超過時會針對單一 pod 記錄警告；0 表示停用。
+	SlowScrapeThreshold    time.Duration
+	LargeResponseThreshold int64
+	// ProductLabelFrom 指定 pod label 名稱，其值會以 product label 注入該 pod 的指標。
+	ProductLabelFrom string
+	// HTTP2 以 h2c（明文 HTTP/2 prior knowledge）抓取指標。
+	HTTP2 bool
+	// AcceptStatusCodes 列出視為抓取成功的 HTTP 狀態碼，預設為 [200]。
+	AcceptStatusCodes []int
+	// KeepOnPartialFailure 在週期發生錯誤時，將成功的 pod 資料合併至既有資料而非整批取代。
+	KeepOnPartialFailure bool
+	// MaxLabelValueLength 限制 label 值的位元組長度，超過者截斷（LabelValueOverflow=drop 時則移除）。
+	MaxLabelValueLength int
+	LabelValueOverflow  string
+	// RedactLabels 列出要遮蔽其值的抓取 label 名稱（例如誤將 token 放進 label 的產品）；
+	// RedactMode 為 replace（預設，以 REDACTED 取代）或 hash（以值的 SHA-256 前綴取代，使不同值的序列仍可區分）。
+	RedactLabels []string
+	RedactMode   string
+	// CoerceUntypedTo 若為 gauge 或 counter，會將 untyped 指標改寫為該型別。
+	CoerceUntypedTo string
+	// DropRuntimeMetrics 丟棄 pod 匯出的 go_*、process_*、promhttp_* 指標。
+	DropRuntimeMetrics bool
+	// Scheme 為抓取使用的協定（http 或 https），預設 http。
+	Scheme string
+	// TLSServerName 覆寫 TLS 驗證所用的主機名稱，連線仍撥向 pod IP；TLSCAFile 為驗證用的 CA 檔。
+	TLSServerName string
+	TLSCAFile     string
+	// MeshTLS 以 MeshCertDir 中的 Istio 工作負載憑證進行 mTLS 抓取，用於 STRICT mTLS 的 pod；需搭配 scheme https。
+	MeshTLS bool
+	// MaxConcurrentScrapes 為同一 namespace 內可同時抓取的 pod 數，預設 1。
+	MaxConcurrentScrapes int
+	// MetricRelabelings 為抓取後依序套用的 relabel 規則（keep、drop、replace、labeldrop）。
+	MetricRelabelings []RelabelConfig
+	// MaxTimestampSkew 若大於 0，明確時間戳與現在相差超過此值的樣本會被計數；StripStaleTimestamps 則移除其時間戳。
+	MaxTimestampSkew     time.Duration
+	StripStaleTimestamps bool
+	// OnlyReadyPods 只抓取 ReadyConditionType（預設 Ready）條件為 True 的 pod；缺少該條件者視為未就緒。
+	OnlyReadyPods      bool
+	ReadyConditionType string
+	// MaxFamilies 與 MaxFamilyGrowth 分別為單一週期指標族數量上限與較前一週期的增量上限，超過時記錄警告；0 表示停用。
+	MaxFamilies     int
+	MaxFamilyGrowth int
+	// OwnerKind 與 OwnerName 只抓取由該工作負載（如 Deployment、StatefulSet）控制的 pod；ReplicaSet 會解析至其 Deployment。
+	OwnerKind string
+	OwnerName string
+	// Timeout 為單一 pod 抓取（含讀取內容）的逾時，預設 10s，設定時不得超過 interval；DialTimeout 為建立 TCP 連線的逾時，讓位於失效節點的 pod 快速失敗，0 表示沿用 30s。
+	Timeout     time.Duration
+	DialTimeout time.Duration
+	// CopyNamespaceLabels 列出要從 pod 所屬 namespace 複製到其指標上的 label 鍵（如 team、cost-center），不合法字元會轉為底線。
+	CopyNamespaceLabels []string
+	// PodStartTimestamps 為每個被抓取的 pod 加上 pod_start_timestamp_seconds，值取自 status.startTime。
+	PodStartTimestamps bool
+	// InjectInstanceLabel 在每個指標上加入 instance=<podIP>:<port>，以區分各副本。
+	InjectInstanceLabel bool
+	// ReparseRetries 為回應無法解析時（如 pod 正在更新指標）重新抓取的次數上限；連線錯誤與狀態碼不符不會重試。
+	ReparseRetries int
+	// Method 為抓取使用的 HTTP 方法（GET 或 POST），預設 GET；RequestBody 為隨 POST 送出的內容，供需要查詢內容才回傳指標的舊端點使用。
+	Method      string
+	RequestBody string
+	// MaxPodsPerNamespace 限制每個 namespace 最多抓取的 pod 數，超過時依 pod 名稱排序取前幾個，使每輪選到的 pod 固定；0 表示不限制。
+	MaxPodsPerNamespace int
+	// ScrapeTerminatingPods 讓抓取器繼續抓取已標記刪除（Terminating）的 pod；預設略過，以免部署期間產生連線被拒的錯誤。
+	ScrapeTerminatingPods bool
+	// ProxyURL 若設定，此 target 的抓取改經由此 HTTP proxy（http 或 https URL）送出，不影響其他 target；不可與 http2 併用。
+	ProxyURL string
+	// MinReadyPods 要求此 target 在單一輪抓取中至少成功抓到這麼多 pod 後，/readyz 才視其為就緒，避免啟動時以不完整的資料提供服務；0 表示只需完成第一輪。
+	MinReadyPods int
+	// Scope 為 namespace（預設）時先以 namespaceSelector 列出 namespace 再逐一列出 pod；
+	// 為 cluster 時以單一請求列出所有 namespace 中符合 pod selector 的 pod，適用於橫跨全叢集的 operator 或 DaemonSet，不需要也不可設定 namespaceSelector。
+	Scope string
+}
+
+// RelabelConfig 對應 Prometheus metric_relabel_configs 的子集。
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"sourceLabels"`
+	Separator    string   `yaml:"separator"`
+	Regex        string   `yaml:"regex"`
+	TargetLabel  string   `yaml:"targetLabel"`
+	Replacement  string   `yaml:"replacement"`
+	Action       string   `yaml:"action"`
 }
 
 type rawConfig struct {
@@ -33,27 +191,143 @@ type rawConfig struct {
 	VirtualServiceInterval        string             `yaml:"virtualServiceInterval"`
 	EnableVirtualServiceScrapeJob *bool              `yaml:"enableVirtualServiceScrapeJob"`
 	ProductMetrics                []rawProductTarget `yaml:"productMetrics"`
+	StartupDelay                  string             `yaml:"startupDelay"`
+	StartupJitter                 string             `yaml:"startupJitter"`
+	ScrapeSourceIP                string             `yaml:"scrapeSourceIP"`
+	ClusterName                   string             `yaml:"clusterName"`
+	HTTPBearerToken               string             `yaml:"httpBearerToken"`
+	EnableReloadEndpoint          bool               `yaml:"enableReloadEndpoint"`
+	KubeCAFile                    string             `yaml:"kubeCAFile"`
+	KubeQPS                       float32            `yaml:"kubeQPS"`
+	KubeBurst                     int                `yaml:"kubeBurst"`
+	VirtualServiceInformers       bool               `yaml:"virtualServiceInformers"`
+	GlobalMaxConcurrentScrapes    int                `yaml:"globalMaxConcurrentScrapes"`
+	MaxActiveScrapeGoroutines     int                `yaml:"maxActiveScrapeGoroutines"`
+	WatchdogMultiplier            int                `yaml:"watchdogMultiplier"`
+	WatchdogAction                string             `yaml:"watchdogAction"`
+	DedupEndpoints                bool               `yaml:"dedupEndpoints"`
+	IsolateTargets                bool               `yaml:"isolateTargets"`
+	MetricPrefix                  string             `yaml:"metricPrefix"`
+	VirtualServiceHealthDebounce  int                `yaml:"virtualServiceHealthDebounce"`
+	FailOnMissingRBAC             bool               `yaml:"failOnMissingRBAC"`
+	ScrapeDNSServer               string             `yaml:"scrapeDNSServer"`
+	ServiceMonitorDiscovery       bool               `yaml:"serviceMonitorDiscovery"`
+	ServiceMonitorSelector        string             `yaml:"serviceMonitorSelector"`
+	DebugRawSnapshots             bool               `yaml:"debugRawSnapshots"`
+	OnHelpConflict                string             `yaml:"onHelpConflict"`
+	MeshCertDir                   string             `yaml:"meshCertDir"`
+	MetricsCacheTTL               string             `yaml:"metricsCacheTTL"`
+	CollectorGatewayConcurrency   int                `yaml:"collectorGatewayConcurrency"`
+	MinTLSVersion                 string             `yaml:"minTLSVersion"`
+	TLSCipherSuites               []string           `yaml:"tlsCipherSuites"`
+	DumpInterval                  string             `yaml:"dumpInterval"`
+	DumpOutput                    string             `yaml:"dumpOutput"`
+	NamespaceRollup               bool               `yaml:"namespaceRollup"`
 }
 
 type rawProductTarget struct {
-	Name              string `yaml:"name"`
-	Interval          string `yaml:"interval"`
-	Port              int    `yaml:"port"`
-	Path              string `yaml:"path"`
-	NamespaceSelector string `yaml:"namespaceSelector"`
-	PodSelector       string `yaml:"podSelector"`
+	Name                   string          `yaml:"name"`
+	Interval               string          `yaml:"interval"`
+	Port                   int             `yaml:"port"`
+	Path                   string          `yaml:"path"`
+	NamespaceSelector      string          `yaml:"namespaceSelector"`
+	PodSelector            string          `yaml:"podSelector"`
+	PodSelectors           []string        `yaml:"podSelectors"`
+	PortNamePattern        string          `yaml:"portNamePattern"`
+	SlowScrapeThreshold    string          `yaml:"slowScrapeThreshold"`
+	LargeResponseThreshold int64           `yaml:"largeResponseThreshold"`
+	ProductLabelFrom       string          `yaml:"productLabelFrom"`
+	HTTP2                  bool            `yaml:"http2"`
+	AcceptStatusCodes      []int           `yaml:"acceptStatusCodes"`
+	KeepOnPartialFailure   bool            `yaml:"keepOnPartialFailure"`
+	MaxLabelValueLength    int             `yaml:"maxLabelValueLength"`
+	LabelValueOverflow     string          `yaml:"labelValueOverflow"`
+	RedactLabels           []string        `yaml:"redactLabels"`
+	RedactMode             string          `yaml:"redactMode"`
+	CoerceUntypedTo        string          `yaml:"coerceUntypedTo"`
+	DropRuntimeMetrics     bool            `yaml:"dropRuntimeMetrics"`
+	Scheme                 string          `yaml:"scheme"`
+	TLSServerName          string          `yaml:"tlsServerName"`
+	TLSCAFile              string          `yaml:"tlsCAFile"`
+	MeshTLS                bool            `yaml:"meshTLS"`
+	MaxConcurrentScrapes   int             `yaml:"maxConcurrentScrapes"`
+	MetricRelabelings      []RelabelConfig `yaml:"metricRelabelings"`
+	MaxTimestampSkew       string          `yaml:"maxTimestampSkew"`
+	StripStaleTimestamps   bool            `yaml:"stripStaleTimestamps"`
+	OnlyReadyPods          bool            `yaml:"onlyReadyPods"`
+	ReadyConditionType     string          `yaml:"readyConditionType"`
+	MaxFamilies            int             `yaml:"maxFamilies"`
+	MaxFamilyGrowth        int             `yaml:"maxFamilyGrowth"`
+	OwnerKind              string          `yaml:"ownerKind"`
+	OwnerName              string          `yaml:"ownerName"`
+	Timeout                string          `yaml:"timeout"`
+	DialTimeout            string          `yaml:"dialTimeout"`
+	CopyNamespaceLabels    []string        `yaml:"copyNamespaceLabels"`
+	PodStartTimestamps     bool            `yaml:"podStartTimestamps"`
+	InjectInstanceLabel    bool            `yaml:"injectInstanceLabel"`
+	ReparseRetries         int             `yaml:"reparseRetries"`
+	Method                 string          `yaml:"method"`
+	RequestBody            string          `yaml:"requestBody"`
+	MaxPodsPerNamespace    int             `yaml:"maxPodsPerNamespace"`
+	ScrapeTerminatingPods  bool            `yaml:"scrapeTerminatingPods"`
+	ProxyURL               string          `yaml:"proxyURL"`
+	MinReadyPods           int             `yaml:"minReadyPods"`
+	Scope                  string          `yaml:"scope"`
 }
 
-// Load 從指定路徑讀取設定。
+// Scope 的可用值。
+const (
+	ScopeNamespace = "namespace"
+	ScopeCluster   = "cluster"
+)
+
+// DefaultMeshCertDir 為掛載 workload-spiffe-credentials volume 的 pod 中，Istio 寫入工作負載憑證的目錄。
+const DefaultMeshCertDir = "/var/run/secrets/workload-spiffe-credentials"
+
+// SetDefaults 為未設定的欄位填入預設值，供設定檔以外來源（如 ServiceMonitor）建立的 target 共用。
+func (t *ProductMetricsTarget) SetDefaults() {
+	if t.ReadyConditionType == "" {
+		t.ReadyConditionType = "Ready"
+	}
+	if t.Scheme == "" {
+		t.Scheme = "http"
+	}
+	if t.Method == "" {
+		t.Method = http.MethodGet
+	}
+	if t.Scope == "" {
+		t.Scope = ScopeNamespace
+	}
+	if t.LabelValueOverflow == "" {
+		t.LabelValueOverflow = "truncate"
+	}
+	if t.RedactMode == "" {
+		t.RedactMode = "replace"
+	}
+	if len(t.AcceptStatusCodes) == 0 {
+		t.AcceptStatusCodes = []int{200}
+	}
+}
+
+// PrimaryFile 為目錄模式下提供頂層設定的檔案名稱。
+const PrimaryFile = "config.yaml"
+
+// Load 從指定路徑讀取設定。若 path 為目錄，會合併其中所有 *.yaml：
+// 頂層設定取自 PrimaryFile，其餘檔案只能提供 productMetrics，且 target 名稱不得重複。
 func Load(path string) (Config, error) {
-	data, err := os.ReadFile(path)
+	info, err := os.Stat(path)
 	if err != nil {
 		return Config{}, fmt.Errorf("read config: %w", err)
 	}
 
 	var raw rawConfig
-	if err := yaml.Unmarshal(data, &raw); err != nil {
-		return Config{}, fmt.Errorf("unmarshal config: %w", err)
+	if info.IsDir() {
+		raw, err = loadDir(path)
+	} else {
+		raw, err = loadFile(path)
+	}
+	if err != nil {
+		return Config{}, err
 	}
 
 	cfg, err := convertRaw(raw)
@@ -68,11 +342,107 @@ func Load(path string) (Config, error) {
 	return cfg, nil
 }
 
+func loadFile(path string) (rawConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rawConfig{}, fmt.Errorf("read config: %w", err)
+	}
+
+	var raw rawConfig
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return rawConfig{}, fmt.Errorf("unmarshal config %s: %w", path, err)
+	}
+	return raw, nil
+}
+
+// loadDir 合併目錄中的 *.yaml，依檔名排序串接 productMetrics。
+func loadDir(dir string) (rawConfig, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return rawConfig{}, fmt.Errorf("list config files: %w", err)
+	}
+
+	primaryPath := filepath.Join(dir, PrimaryFile)
+	merged, err := loadFile(primaryPath)
+	if err != nil {
+		return rawConfig{}, err
+	}
+
+	owners := make(map[string]string)
+	for _, target := range merged.ProductMetrics {
+		if previous, ok := owners[target.Name]; ok {
+			return rawConfig{}, fmt.Errorf("duplicate productMetrics target %q in %s and %s", target.Name, previous, PrimaryFile)
+		}
+		owners[target.Name] = PrimaryFile
+	}
+
+	for _, file := range files {
+		if file == primaryPath {
+			continue
+		}
+		raw, err := loadFile(file)
+		if err != nil {
+			return rawConfig{}, err
+		}
+		name := filepath.Base(file)
+		if !reflect.DeepEqual(raw, rawConfig{ProductMetrics: raw.ProductMetrics}) {
+			return rawConfig{}, fmt.Errorf("%s may only define productMetrics; top-level settings belong in %s", name, PrimaryFile)
+		}
+		for _, target := range raw.ProductMetrics {
+			if previous, ok := owners[target.Name]; ok {
+				return rawConfig{}, fmt.Errorf("duplicate productMetrics target %q in %s and %s", target.Name, previous, name)
+			}
+			owners[target.Name] = name
+		}
+		merged.ProductMetrics = append(merged.ProductMetrics, raw.ProductMetrics...)
+	}
+	return merged, nil
+}
+
 func convertRaw(raw rawConfig) (Config, error) {
 	cfg := Config{
 		ListenAddress:                 raw.ListenAddress,
 		InternalMetricsAddress:        raw.InternalMetricsAddress,
 		EnableVirtualServiceScrapeJob: true,
+		ScrapeSourceIP:                raw.ScrapeSourceIP,
+		ClusterName:                   raw.ClusterName,
+		HTTPBearerToken:               raw.HTTPBearerToken,
+		EnableReloadEndpoint:          raw.EnableReloadEndpoint,
+		KubeCAFile:                    raw.KubeCAFile,
+		KubeQPS:                       raw.KubeQPS,
+		KubeBurst:                     raw.KubeBurst,
+		VirtualServiceInformers:       raw.VirtualServiceInformers,
+		GlobalMaxConcurrentScrapes:    raw.GlobalMaxConcurrentScrapes,
+		MaxActiveScrapeGoroutines:     raw.MaxActiveScrapeGoroutines,
+		WatchdogMultiplier:            raw.WatchdogMultiplier,
+		WatchdogAction:                raw.WatchdogAction,
+		DedupEndpoints:                raw.DedupEndpoints,
+		IsolateTargets:                raw.IsolateTargets,
+		MetricPrefix:                  raw.MetricPrefix,
+		VirtualServiceHealthDebounce:  raw.VirtualServiceHealthDebounce,
+		FailOnMissingRBAC:             raw.FailOnMissingRBAC,
+		ScrapeDNSServer:               raw.ScrapeDNSServer,
+		ServiceMonitorDiscovery:       raw.ServiceMonitorDiscovery,
+		ServiceMonitorSelector:        raw.ServiceMonitorSelector,
+		DebugRawSnapshots:             raw.DebugRawSnapshots,
+		OnHelpConflict:                raw.OnHelpConflict,
+		MeshCertDir:                   raw.MeshCertDir,
+		CollectorGatewayConcurrency:   raw.CollectorGatewayConcurrency,
+		DumpOutput:                    raw.DumpOutput,
+		NamespaceRollup:               raw.NamespaceRollup,
+	}
+
+	if cfg.MetricPrefix == "" {
+		cfg.MetricPrefix = "istio"
+	}
+	if cfg.WatchdogAction == "" {
+		cfg.WatchdogAction = "restart"
+	}
+	if cfg.OnHelpConflict == "" {
+		cfg.OnHelpConflict = "first"
+	}
+	if cfg.MeshCertDir == "" {
+		cfg.MeshCertDir = DefaultMeshCertDir
 	}
 
 	if raw.VirtualServiceInterval == "" {
@@ -84,6 +454,41 @@ func convertRaw(raw rawConfig) (Config, error) {
 	}
 	cfg.VirtualServiceInterval = interval
 
+	if raw.StartupDelay != "" {
+		cfg.StartupDelay, err = time.ParseDuration(raw.StartupDelay)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse startupDelay: %w", err)
+		}
+	}
+	if raw.StartupJitter != "" {
+		cfg.StartupJitter, err = time.ParseDuration(raw.StartupJitter)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse startupJitter: %w", err)
+		}
+	}
+
+	if raw.DumpInterval != "" {
+		cfg.DumpInterval, err = time.ParseDuration(raw.DumpInterval)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse dumpInterval: %w", err)
+		}
+	}
+	if raw.MetricsCacheTTL != "" {
+		cfg.MetricsCacheTTL, err = time.ParseDuration(raw.MetricsCacheTTL)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse metricsCacheTTL: %w", err)
+		}
+	}
+
+	cfg.MinTLSVersion, err = parseTLSVersion(raw.MinTLSVersion)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.TLSCipherSuites, err = parseCipherSuites(raw.TLSCipherSuites)
+	if err != nil {
+		return Config{}, err
+	}
+
 	if raw.EnableVirtualServiceScrapeJob != nil {
 		cfg.EnableVirtualServiceScrapeJob = *raw.EnableVirtualServiceScrapeJob
 	}
@@ -97,19 +502,86 @@ func convertRaw(raw rawConfig) (Config, error) {
 		if err != nil {
 			return Config{}, fmt.Errorf("parse productMetrics[%d].interval: %w", i, err)
 		}
+		var slowThreshold time.Duration
+		if target.SlowScrapeThreshold != "" {
+			slowThreshold, err = time.ParseDuration(target.SlowScrapeThreshold)
+			if err != nil {
+				return Config{}, fmt.Errorf("parse productMetrics[%d].slowScrapeThreshold: %w", i, err)
+			}
+		}
 		cfg.ProductMetrics[i] = ProductMetricsTarget{
-			Name:              target.Name,
-			Interval:          duration,
-			Port:              target.Port,
-			Path:              target.Path,
-			NamespaceSelector: target.NamespaceSelector,
-			PodSelector:       target.PodSelector,
+			Name:                   target.Name,
+			Interval:               duration,
+			Port:                   target.Port,
+			Path:                   target.Path,
+			NamespaceSelector:      target.NamespaceSelector,
+			PodSelector:            target.PodSelector,
+			PodSelectors:           target.PodSelectors,
+			PortNamePattern:        target.PortNamePattern,
+			SlowScrapeThreshold:    slowThreshold,
+			LargeResponseThreshold: target.LargeResponseThreshold,
+			ProductLabelFrom:       target.ProductLabelFrom,
+			HTTP2:                  target.HTTP2,
+			AcceptStatusCodes:      target.AcceptStatusCodes,
+			KeepOnPartialFailure:   target.KeepOnPartialFailure,
+			MaxLabelValueLength:    target.MaxLabelValueLength,
+			LabelValueOverflow:     target.LabelValueOverflow,
+			RedactLabels:           target.RedactLabels,
+			RedactMode:             target.RedactMode,
+			CoerceUntypedTo:        target.CoerceUntypedTo,
+			DropRuntimeMetrics:     target.DropRuntimeMetrics,
+			Scheme:                 target.Scheme,
+			TLSServerName:          target.TLSServerName,
+			TLSCAFile:              target.TLSCAFile,
+			MeshTLS:                target.MeshTLS,
+			MaxConcurrentScrapes:   target.MaxConcurrentScrapes,
+			MetricRelabelings:      target.MetricRelabelings,
+			StripStaleTimestamps:   target.StripStaleTimestamps,
+			OnlyReadyPods:          target.OnlyReadyPods,
+			ReadyConditionType:     target.ReadyConditionType,
+			MaxFamilies:            target.MaxFamilies,
+			MaxFamilyGrowth:        target.MaxFamilyGrowth,
+			OwnerKind:              target.OwnerKind,
+			OwnerName:              target.OwnerName,
+			CopyNamespaceLabels:    target.CopyNamespaceLabels,
+			PodStartTimestamps:     target.PodStartTimestamps,
+			InjectInstanceLabel:    target.InjectInstanceLabel,
+			ReparseRetries:         target.ReparseRetries,
+			Method:                 strings.ToUpper(target.Method),
+			RequestBody:            target.RequestBody,
+			MaxPodsPerNamespace:    target.MaxPodsPerNamespace,
+			ScrapeTerminatingPods:  target.ScrapeTerminatingPods,
+			ProxyURL:               target.ProxyURL,
+			MinReadyPods:           target.MinReadyPods,
+			Scope:                  target.Scope,
+		}
+		if target.MaxTimestampSkew != "" {
+			cfg.ProductMetrics[i].MaxTimestampSkew, err = time.ParseDuration(target.MaxTimestampSkew)
+			if err != nil {
+				return Config{}, fmt.Errorf("parse productMetrics[%d].maxTimestampSkew: %w", i, err)
+			}
+		}
+		if target.Timeout != "" {
+			cfg.ProductMetrics[i].Timeout, err = time.ParseDuration(target.Timeout)
+			if err != nil {
+				return Config{}, fmt.Errorf("parse productMetrics[%d].timeout: %w", i, err)
+			}
 		}
+		if target.DialTimeout != "" {
+			cfg.ProductMetrics[i].DialTimeout, err = time.ParseDuration(target.DialTimeout)
+			if err != nil {
+				return Config{}, fmt.Errorf("parse productMetrics[%d].dialTimeout: %w", i, err)
+			}
+		}
+		cfg.ProductMetrics[i].SetDefaults()
 	}
 
 	return cfg, nil
 }
 
+// metricPrefixPattern 為合法的 Prometheus 指標名稱前綴。
+var metricPrefixPattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
 func (c Config) validate() error {
 	if c.ListenAddress == "" {
 		return fmt.Errorf("listenAddress is required")
@@ -120,26 +592,258 @@ func (c Config) validate() error {
 	if c.EnableVirtualServiceScrapeJob && c.VirtualServiceInterval <= 0 {
 		return fmt.Errorf("virtualServiceInterval must be positive when enableVirtualServiceScrapeJob is true")
 	}
+	if c.StartupDelay < 0 {
+		return fmt.Errorf("startupDelay must not be negative")
+	}
+	if c.StartupJitter < 0 {
+		return fmt.Errorf("startupJitter must not be negative")
+	}
+	if c.CollectorGatewayConcurrency < 0 {
+		return fmt.Errorf("collectorGatewayConcurrency must not be negative")
+	}
+	if c.DumpInterval < 0 {
+		return fmt.Errorf("dumpInterval must not be negative")
+	}
+	if c.MetricsCacheTTL < 0 {
+		return fmt.Errorf("metricsCacheTTL must not be negative")
+	}
+	if c.ScrapeSourceIP != "" && net.ParseIP(c.ScrapeSourceIP) == nil {
+		return fmt.Errorf("scrapeSourceIP %q is not a valid IP address", c.ScrapeSourceIP)
+	}
+	if c.ScrapeDNSServer != "" {
+		if _, _, err := net.SplitHostPort(c.ScrapeDNSServer); err != nil {
+			return fmt.Errorf("scrapeDNSServer %q must be host:port: %w", c.ScrapeDNSServer, err)
+		}
+	}
+	if c.WatchdogMultiplier < 0 {
+		return fmt.Errorf("watchdogMultiplier must not be negative")
+	}
+	if c.VirtualServiceHealthDebounce < 0 {
+		return fmt.Errorf("virtualServiceHealthDebounce must not be negative")
+	}
+	if !metricPrefixPattern.MatchString(c.MetricPrefix) {
+		return fmt.Errorf("metricPrefix %q is not a valid metric name prefix", c.MetricPrefix)
+	}
+	if c.WatchdogAction != "restart" && c.WatchdogAction != "panic" {
+		return fmt.Errorf("watchdogAction must be restart or panic")
+	}
+	switch c.OnHelpConflict {
+	case "first", "longest", "drop", "warn":
+	default:
+		return fmt.Errorf("onHelpConflict must be first, longest, drop or warn")
+	}
+	if c.GlobalMaxConcurrentScrapes < 0 {
+		return fmt.Errorf("globalMaxConcurrentScrapes must not be negative")
+	}
+	if c.MaxActiveScrapeGoroutines < 0 {
+		return fmt.Errorf("maxActiveScrapeGoroutines must not be negative")
+	}
+	if c.KubeQPS < 0 {
+		return fmt.Errorf("kubeQPS must not be negative")
+	}
+	if c.KubeBurst < 0 {
+		return fmt.Errorf("kubeBurst must not be negative")
+	}
+	if c.EnableReloadEndpoint && c.HTTPBearerToken == "" {
+		return fmt.Errorf("httpBearerToken is required when enableReloadEndpoint is true")
+	}
+	names := make(map[string]bool, len(c.ProductMetrics))
 	for i, target := range c.ProductMetrics {
 		if target.Name == "" {
 			return fmt.Errorf("productMetrics[%d].name is required", i)
 		}
-		if target.Interval <= 0 {
-			return fmt.Errorf("productMetrics[%d].interval must be positive", i)
+		if names[target.Name] {
+			return fmt.Errorf("productMetrics[%d].name %q is duplicated", i, target.Name)
+		}
+		names[target.Name] = true
+		if err := target.Validate(); err != nil {
+			return fmt.Errorf("productMetrics[%d] (%s): %w", i, target.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate 檢查單一 target 的設定，供設定檔以外來源（如 ServiceMonitor）建立的 target 共用；
+// 名稱的必填與重複檢查由 Config 負責。
+func (t ProductMetricsTarget) Validate() error {
+	if t.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if t.PortNamePattern != "" {
+		if _, err := path.Match(t.PortNamePattern, ""); err != nil {
+			return fmt.Errorf("portNamePattern: %w", err)
+		}
+		if t.Port < 0 {
+			return fmt.Errorf("port must not be negative")
+		}
+	} else if t.Port <= 0 {
+		return fmt.Errorf("port must be positive")
+	}
+	if t.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if t.SlowScrapeThreshold < 0 {
+		return fmt.Errorf("slowScrapeThreshold must not be negative")
+	}
+	if t.LargeResponseThreshold < 0 {
+		return fmt.Errorf("largeResponseThreshold must not be negative")
+	}
+	for j, rule := range t.MetricRelabelings {
+		switch rule.Action {
+		case "keep", "drop":
+		case "labeldrop":
+			// The default regex (.*) would drop every label.
+			if rule.Regex == "" {
+				return fmt.Errorf("metricRelabelings[%d]: labeldrop requires regex", j)
+			}
+		case "", "replace":
+			if rule.TargetLabel == "" || rule.TargetLabel == "__name__" {
+				return fmt.Errorf("metricRelabelings[%d]: replace requires a targetLabel other than __name__", j)
+			}
+		default:
+			return fmt.Errorf("metricRelabelings[%d]: unsupported action %q", j, rule.Action)
+		}
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return fmt.Errorf("metricRelabelings[%d]: invalid regex: %w", j, err)
+		}
+	}
+	if t.Method != http.MethodGet && t.Method != http.MethodPost {
+		return fmt.Errorf("method must be GET or POST")
+	}
+	if t.RequestBody != "" && t.Method != http.MethodPost {
+		return fmt.Errorf("requestBody requires method POST")
+	}
+	if t.ReparseRetries < 0 {
+		return fmt.Errorf("reparseRetries must not be negative")
+	}
+	if t.MaxTimestampSkew < 0 {
+		return fmt.Errorf("maxTimestampSkew must not be negative")
+	}
+	if t.Timeout < 0 || t.DialTimeout < 0 {
+		return fmt.Errorf("timeout and dialTimeout must not be negative")
+	}
+	if t.Timeout > t.Interval {
+		return fmt.Errorf("timeout %s exceeds interval %s, so every cycle could overrun", t.Timeout, t.Interval)
+	}
+	if t.Timeout > 0 && t.DialTimeout > t.Timeout {
+		return fmt.Errorf("dialTimeout must not exceed timeout")
+	}
+	if (t.OwnerKind == "") != (t.OwnerName == "") {
+		return fmt.Errorf("ownerKind and ownerName must be set together")
+	}
+	if t.MaxFamilies < 0 || t.MaxFamilyGrowth < 0 {
+		return fmt.Errorf("maxFamilies and maxFamilyGrowth must not be negative")
+	}
+	if t.MaxPodsPerNamespace < 0 {
+		return fmt.Errorf("maxPodsPerNamespace must not be negative")
+	}
+	if t.MinReadyPods < 0 {
+		return fmt.Errorf("minReadyPods must not be negative")
+	}
+	if t.MaxConcurrentScrapes < 0 {
+		return fmt.Errorf("maxConcurrentScrapes must not be negative")
+	}
+	if t.MaxLabelValueLength < 0 {
+		return fmt.Errorf("maxLabelValueLength must not be negative")
+	}
+	if t.LabelValueOverflow != "truncate" && t.LabelValueOverflow != "drop" {
+		return fmt.Errorf("labelValueOverflow must be truncate or drop")
+	}
+	if t.RedactMode != "replace" && t.RedactMode != "hash" {
+		return fmt.Errorf("redactMode must be replace or hash")
+	}
+	if t.Scheme != "http" && t.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if t.Scheme != "https" && (t.TLSServerName != "" || t.TLSCAFile != "") {
+		return fmt.Errorf("tlsServerName and tlsCAFile require scheme https")
+	}
+	if t.MeshTLS && (t.Scheme != "https" || t.TLSCAFile != "") {
+		return fmt.Errorf("meshTLS requires scheme https and no tlsCAFile")
+	}
+	if t.ProxyURL != "" {
+		proxy, err := url.Parse(t.ProxyURL)
+		if err != nil || (proxy.Scheme != "http" && proxy.Scheme != "https") || proxy.Host == "" {
+			return fmt.Errorf("proxyURL must be an http or https URL")
+		}
+		if t.HTTP2 {
+			return fmt.Errorf("proxyURL cannot be combined with http2")
 		}
-		if target.Port <= 0 {
-			return fmt.Errorf("productMetrics[%d].port must be positive", i)
+	}
+	if t.Scheme == "https" && t.HTTP2 {
+		return fmt.Errorf("http2 is cleartext only and cannot be combined with scheme https")
+	}
+	switch t.CoerceUntypedTo {
+	case "", "gauge", "counter":
+	default:
+		return fmt.Errorf("coerceUntypedTo must be gauge or counter")
+	}
+	for _, code := range t.AcceptStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("acceptStatusCodes contains invalid status code %d", code)
 		}
-		if target.Path == "" {
-			return fmt.Errorf("productMetrics[%d].path is required", i)
+	}
+	switch t.Scope {
+	case ScopeNamespace:
+		if t.NamespaceSelector == "" {
+			return fmt.Errorf("namespaceSelector is required")
 		}
-		if target.NamespaceSelector == "" {
-			return fmt.Errorf("productMetrics[%d].namespaceSelector is required", i)
+	case ScopeCluster:
+		if t.NamespaceSelector != "" || len(t.CopyNamespaceLabels) > 0 {
+			return fmt.Errorf("namespaceSelector and copyNamespaceLabels cannot be combined with scope cluster")
 		}
-		if target.PodSelector == "" {
-			return fmt.Errorf("productMetrics[%d].podSelector is required", i)
+	default:
+		return fmt.Errorf("scope must be namespace or cluster")
+	}
+	if t.PodSelector == "" && len(t.PodSelectors) == 0 {
+		return fmt.Errorf("podSelector or podSelectors is required")
+	}
+	for j, selector := range t.PodSelectors {
+		if selector == "" {
+			return fmt.Errorf("podSelectors[%d] must not be empty", j)
 		}
 	}
 
 	return nil
 }
+
+// tlsVersions 對應 minTLSVersion 可用的值。
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion 解析 minTLSVersion，未設定時為 TLS 1.2。
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	parsed, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("minTLSVersion must be one of 1.0, 1.1, 1.2, 1.3, got %q", version)
+	}
+	return parsed, nil
+}
+
+// parseCipherSuites 將 tlsCipherSuites 的名稱轉為 ID；僅接受 Go 視為安全的 cipher suite。
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("tlsCipherSuites contains unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
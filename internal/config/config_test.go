@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -81,3 +82,207 @@ productMetrics: []
 		t.Fatalf("expected error when loading invalid config, got nil")
 	}
 }
+
+func TestLoadRejectsReloadEndpointWithoutToken(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `
+listenAddress: ":8090"
+internalMetricsAddress: ":9000"
+virtualServiceInterval: "1m"
+enableReloadEndpoint: true
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected error when enabling reload endpoint without a bearer token")
+	}
+}
+
+func TestLoadRejectsUnsupportedRelabelAction(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `
+listenAddress: ":8090"
+internalMetricsAddress: ":9000"
+virtualServiceInterval: "1m"
+productMetrics:
+  - name: alpha
+    interval: "1m"
+    port: 8080
+    path: /metrics
+    metricRelabelings:
+      - sourceLabels: [__name__]
+        regex: "go_.*"
+        action: labelmap
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected error for an unsupported relabel action")
+	}
+}
+
+func TestLoadRejectsInvalidRelabelings(t *testing.T) {
+	for rule, want := range map[string]string{
+		"- action: labeldrop":                             "labeldrop requires regex",
+		"- {sourceLabels: [code], targetLabel: __name__}": "targetLabel other than __name__",
+	} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config.yaml")
+		content := `
+listenAddress: ":8090"
+internalMetricsAddress: ":9000"
+virtualServiceInterval: "1m"
+productMetrics:
+  - name: alpha
+    interval: "1m"
+    port: 8080
+    path: /metrics
+    namespaceSelector: "product=alpha"
+    podSelector: "app=alpha"
+    metricRelabelings:
+      ` + rule + "\n"
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error containing %q, got %v", rule, want, err)
+		}
+	}
+}
+
+func TestLoadRejectsTimeoutLongerThanInterval(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `
+listenAddress: ":8090"
+internalMetricsAddress: ":9000"
+virtualServiceInterval: "1m"
+productMetrics:
+  - name: slowpoke
+    interval: "10s"
+    timeout: "30s"
+    port: 8080
+    path: /metrics
+    namespaceSelector: product=a
+    podSelector: app=product-a
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "slowpoke") {
+		t.Fatalf("expected an error naming target slowpoke, got %v", err)
+	}
+
+	// Without an explicit timeout the target is accepted and keeps the
+	// scraper's default, even for an interval shorter than it.
+	content = strings.Replace(content, `    timeout: "30s"
+`, "", 1)
+	content = strings.Replace(content, `interval: "10s"`, `interval: "5s"`, 1)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.ProductMetrics[0].Timeout; got != 0 {
+		t.Fatalf("expected the timeout to stay unset, got %s", got)
+	}
+}
+
+func TestLoadClusterScope(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `
+listenAddress: ":8090"
+internalMetricsAddress: ":9000"
+virtualServiceInterval: "1m"
+productMetrics:
+  - name: node-agent
+    interval: "30s"
+    port: 8080
+    path: /metrics
+    scope: cluster
+    podSelector: app=node-agent
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.ProductMetrics[0].Scope; got != ScopeCluster {
+		t.Fatalf("expected scope cluster, got %q", got)
+	}
+
+	content = strings.Replace(content, "    scope: cluster\n", "    scope: cluster\n    namespaceSelector: product=a\n", 1)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "scope cluster") {
+		t.Fatalf("expected namespaceSelector to be rejected with scope cluster, got %v", err)
+	}
+}
+
+func TestLoadDirectoryMergesTargets(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		PrimaryFile: `
+listenAddress: ":8090"
+internalMetricsAddress: ":9000"
+virtualServiceInterval: "1m"
+productMetrics:
+  - name: alpha
+    interval: "1m"
+    port: 8080
+    path: /metrics
+    namespaceSelector: product=alpha
+    podSelector: app=alpha
+`,
+		"beta.yaml": `
+productMetrics:
+  - name: beta
+    interval: "2m"
+    port: 8080
+    path: /metrics
+    namespaceSelector: product=beta
+    podSelector: app=beta
+`,
+		"notes.txt": "ignored",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.ProductMetrics) != 2 || cfg.ProductMetrics[0].Name != "alpha" || cfg.ProductMetrics[1].Name != "beta" {
+		t.Fatalf("expected alpha and beta targets, got %+v", cfg.ProductMetrics)
+	}
+	if cfg.ListenAddress != ":8090" {
+		t.Fatalf("expected top-level settings from %s, got %q", PrimaryFile, cfg.ListenAddress)
+	}
+
+	duplicate := "productMetrics:\n  - name: alpha\n    interval: \"1m\"\n    port: 8080\n"
+	if err := os.WriteFile(filepath.Join(dir, "gamma.yaml"), []byte(duplicate), 0o644); err != nil {
+		t.Fatalf("failed to write gamma.yaml: %v", err)
+	}
+	if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Fatalf("expected a duplicate target error, got %v", err)
+	}
+}
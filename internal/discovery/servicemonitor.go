@@ -0,0 +1,174 @@
+// Package discovery derives product scrape targets from cluster resources.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+
+	"vs_exporter/internal/config"
+)
+
+// ServiceMonitorResource identifies the Prometheus Operator ServiceMonitor CRD.
+var ServiceMonitorResource = schema.GroupVersionResource{
+	Group:    "monitoring.coreos.com",
+	Version:  "v1",
+	Resource: "servicemonitors",
+}
+
+// defaultInterval is used for endpoints without an interval, matching
+// Prometheus's global default.
+const defaultInterval = time.Minute
+
+// namespaceNameLabel is set by Kubernetes on every namespace to its name.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// serviceMonitor holds the subset of the ServiceMonitor spec that maps onto a
+// product target.
+type serviceMonitor struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Selector          metav1.LabelSelector `json:"selector"`
+		NamespaceSelector struct {
+			Any        bool     `json:"any"`
+			MatchNames []string `json:"matchNames"`
+		} `json:"namespaceSelector"`
+		Endpoints []struct {
+			Port          string              `json:"port"`
+			TargetPort    *intstr.IntOrString `json:"targetPort"`
+			Path          string              `json:"path"`
+			Scheme        string              `json:"scheme"`
+			Interval      string              `json:"interval"`
+			ScrapeTimeout string              `json:"scrapeTimeout"`
+		} `json:"endpoints"`
+	} `json:"spec"`
+}
+
+// ServiceMonitorTargets lists the ServiceMonitors matching labelSelector in all
+// namespaces and translates each endpoint into a product target named
+// servicemonitor/<namespace>/<name>/<index>.
+//
+// The translation is approximate because the exporter scrapes pods directly
+// rather than Service endpoints: the Service selector is used as the pod
+// selector, and a named port is matched against container port names. Both
+// hold for the usual convention of Services and pods sharing labels and port
+// names. Endpoints that cannot be translated, or whose target fails
+// ProductMetricsTarget.Validate, are returned as errors alongside the targets
+// that could.
+func ServiceMonitorTargets(ctx context.Context, client dynamic.Interface, labelSelector string) ([]config.ProductMetricsTarget, []error) {
+	list, err := client.Resource(ServiceMonitorResource).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, []error{fmt.Errorf("list servicemonitors: %w", err)}
+	}
+
+	var (
+		targets []config.ProductMetricsTarget
+		errs    []error
+	)
+	for _, item := range list.Items {
+		var monitor serviceMonitor
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &monitor); err != nil {
+			errs = append(errs, fmt.Errorf("servicemonitor %s/%s: %w", item.GetNamespace(), item.GetName(), err))
+			continue
+		}
+		translated, err := translate(monitor)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("servicemonitor %s/%s: %w", monitor.Namespace, monitor.Name, err))
+			continue
+		}
+		// Translated targets bypass Config's validation, so the per-target
+		// checks run here and an invalid endpoint is skipped on its own.
+		for _, target := range translated {
+			if err := target.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("servicemonitor %s/%s: target %s: %w", monitor.Namespace, monitor.Name, target.Name, err))
+				continue
+			}
+			targets = append(targets, target)
+		}
+	}
+	return targets, errs
+}
+
+func translate(monitor serviceMonitor) ([]config.ProductMetricsTarget, error) {
+	podSelector, err := metav1.LabelSelectorAsSelector(&monitor.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("selector: %w", err)
+	}
+
+	var namespaceSelector string
+	switch {
+	case monitor.Spec.NamespaceSelector.Any:
+	case len(monitor.Spec.NamespaceSelector.MatchNames) > 0:
+		namespaceSelector = fmt.Sprintf("%s in (%s)", namespaceNameLabel, strings.Join(monitor.Spec.NamespaceSelector.MatchNames, ","))
+	default:
+		namespaceSelector = namespaceNameLabel + "=" + monitor.Namespace
+	}
+
+	targets := make([]config.ProductMetricsTarget, 0, len(monitor.Spec.Endpoints))
+	for i, endpoint := range monitor.Spec.Endpoints {
+		target := config.ProductMetricsTarget{
+			Name:              fmt.Sprintf("servicemonitor/%s/%s/%d", monitor.Namespace, monitor.Name, i),
+			Interval:          defaultInterval,
+			Path:              endpoint.Path,
+			NamespaceSelector: namespaceSelector,
+			PodSelector:       podSelector.String(),
+			Scheme:            endpoint.Scheme,
+		}
+		if target.Path == "" {
+			target.Path = "/metrics"
+		}
+		if target.Scheme != "" && target.Scheme != "http" && target.Scheme != "https" {
+			return nil, fmt.Errorf("endpoint %d: unsupported scheme %q", i, target.Scheme)
+		}
+
+		switch {
+		case endpoint.Port != "":
+			target.PortNamePattern = endpoint.Port
+		case endpoint.TargetPort != nil && endpoint.TargetPort.Type == intstr.Int:
+			target.Port = endpoint.TargetPort.IntValue()
+		case endpoint.TargetPort != nil:
+			target.PortNamePattern = endpoint.TargetPort.StrVal
+		default:
+			return nil, fmt.Errorf("endpoint %d: port or targetPort is required", i)
+		}
+
+		if endpoint.Interval != "" {
+			if target.Interval, err = parseDuration(endpoint.Interval); err != nil {
+				return nil, fmt.Errorf("endpoint %d: interval: %w", i, err)
+			}
+		}
+		if endpoint.ScrapeTimeout != "" {
+			if target.Timeout, err = parseDuration(endpoint.ScrapeTimeout); err != nil {
+				return nil, fmt.Errorf("endpoint %d: scrapeTimeout: %w", i, err)
+			}
+		}
+		target.SetDefaults()
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// parseDuration accepts the Prometheus duration subset used by the operator,
+// which adds a "d" unit to Go's syntax.
+func parseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,82 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestServiceMonitorTargets(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{ServiceMonitorResource: "ServiceMonitorList"},
+		newServiceMonitor("shop", "checkout", map[string]interface{}{
+			"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "checkout"}},
+			"endpoints": []interface{}{
+				map[string]interface{}{"port": "http-metrics", "interval": "30s"},
+				map[string]interface{}{"targetPort": int64(9102), "path": "/stats", "scheme": "https", "scrapeTimeout": "5s"},
+			},
+		}),
+		newServiceMonitor("shop", "shared", map[string]interface{}{
+			"selector":          map[string]interface{}{"matchLabels": map[string]interface{}{"tier": "web"}},
+			"namespaceSelector": map[string]interface{}{"matchNames": []interface{}{"a", "b"}},
+			"endpoints":         []interface{}{map[string]interface{}{"port": "metrics"}},
+		}),
+		newServiceMonitor("shop", "portless", map[string]interface{}{
+			"selector":  map[string]interface{}{},
+			"endpoints": []interface{}{map[string]interface{}{}},
+		}),
+		newServiceMonitor("shop", "slow", map[string]interface{}{
+			"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "slow"}},
+			"endpoints": []interface{}{
+				map[string]interface{}{"port": "metrics", "interval": "10s", "scrapeTimeout": "30s"},
+			},
+		}),
+	)
+
+	targets, errs := ServiceMonitorTargets(context.Background(), client, "")
+	if len(errs) != 2 {
+		t.Fatalf("expected the endpoint without a port and the invalid timeout to be reported, got %v", errs)
+	}
+	byName := make(map[string]int, len(targets))
+	for i, target := range targets {
+		byName[target.Name] = i
+	}
+	if len(targets) != 3 {
+		t.Fatalf("expected 3 targets, got %+v", targets)
+	}
+
+	named := targets[byName["servicemonitor/shop/checkout/0"]]
+	if named.PortNamePattern != "http-metrics" || named.Interval != 30*time.Second || named.Path != "/metrics" {
+		t.Fatalf("unexpected named-port target: %+v", named)
+	}
+	if named.NamespaceSelector != "kubernetes.io/metadata.name=shop" || named.PodSelector != "app=checkout" {
+		t.Fatalf("unexpected selectors for the named-port target: %+v", named)
+	}
+
+	numbered := targets[byName["servicemonitor/shop/checkout/1"]]
+	if numbered.Port != 9102 || numbered.Path != "/stats" || numbered.Scheme != "https" || numbered.Timeout != 5*time.Second || numbered.Interval != defaultInterval {
+		t.Fatalf("unexpected numbered-port target: %+v", numbered)
+	}
+	if len(numbered.AcceptStatusCodes) != 1 || numbered.ReadyConditionType != "Ready" {
+		t.Fatalf("expected target defaults to be applied, got %+v", numbered)
+	}
+
+	shared := targets[byName["servicemonitor/shop/shared/0"]]
+	if shared.NamespaceSelector != "kubernetes.io/metadata.name in (a,b)" {
+		t.Fatalf("unexpected namespace selector for matchNames: %q", shared.NamespaceSelector)
+	}
+}
+
+func newServiceMonitor(namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       "ServiceMonitor",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name},
+		"spec":       spec,
+	}}
+}
@@ -8,9 +8,39 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// Options tunes the REST configuration returned by BuildConfig. Zero values keep
+// the client-go defaults.
+type Options struct {
+	// CAFile replaces the CA bundle used to verify the API server.
+	CAFile string
+	// QPS and Burst configure client-side rate limiting.
+	QPS   float32
+	Burst int
+}
+
 // BuildConfig returns a Kubernetes REST configuration using in-cluster settings when available
 // and falling back to the user's kubeconfig file.
-func BuildConfig() (*rest.Config, error) {
+func BuildConfig(opts Options) (*rest.Config, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CAFile != "" {
+		cfg.TLSClientConfig.CAFile = opts.CAFile
+		// CAData takes precedence over CAFile, so drop any bundle embedded in the kubeconfig.
+		cfg.TLSClientConfig.CAData = nil
+	}
+	if opts.QPS > 0 {
+		cfg.QPS = opts.QPS
+	}
+	if opts.Burst > 0 {
+		cfg.Burst = opts.Burst
+	}
+	return cfg, nil
+}
+
+func loadConfig() (*rest.Config, error) {
 	if cfg, err := rest.InClusterConfig(); err == nil {
 		return cfg, nil
 	}
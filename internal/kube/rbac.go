@@ -0,0 +1,56 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is an API access the exporter needs, checked cluster-wide
+// unless Namespace is set.
+type Permission struct {
+	Group     string
+	Resource  string
+	Verb      string
+	Namespace string
+}
+
+func (p Permission) String() string {
+	s := p.Verb + " " + p.Resource
+	if p.Group != "" {
+		s += "." + p.Group
+	}
+	if p.Namespace != "" {
+		s += " in namespace " + p.Namespace
+	}
+	return s
+}
+
+// MissingPermissions asks the API server, via SelfSubjectAccessReview, which
+// of permissions the exporter's own identity lacks.
+func MissingPermissions(ctx context.Context, client kubernetes.Interface, permissions []Permission) ([]Permission, error) {
+	var missing []Permission
+	for _, permission := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:     permission.Group,
+					Resource:  permission.Resource,
+					Verb:      permission.Verb,
+					Namespace: permission.Namespace,
+				},
+			},
+		}
+		result, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("review %s: %w", permission, err)
+		}
+		if !result.Status.Allowed {
+			missing = append(missing, permission)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,36 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestMissingPermissions(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Resource != "virtualservices"
+		return true, review, nil
+	})
+
+	permissions := []Permission{
+		{Resource: "namespaces", Verb: "list"},
+		{Group: "networking.istio.io", Resource: "virtualservices", Verb: "list"},
+	}
+	missing, err := MissingPermissions(context.Background(), clientset, permissions)
+	if err != nil {
+		t.Fatalf("MissingPermissions() error = %v", err)
+	}
+	if !reflect.DeepEqual(missing, permissions[1:]) {
+		t.Fatalf("missing = %v, want %v", missing, permissions[1:])
+	}
+	if got := missing[0].String(); got != "list virtualservices.networking.istio.io" {
+		t.Fatalf("String() = %q", got)
+	}
+}
@@ -0,0 +1,84 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Throttle sources reported to the callback passed to Instrument.
+const (
+	// ThrottleClient marks a request delayed by the client-side rate limiter.
+	ThrottleClient = "client"
+	// ThrottleServer marks a 429 Too Many Requests response from the API server.
+	ThrottleServer = "server"
+)
+
+// Instrument returns a copy of cfg that calls onThrottle whenever a request has
+// to wait for the client-side rate limiter or is answered with 429 Too Many
+// Requests. Each call creates its own limiter from cfg.QPS and cfg.Burst, so
+// clients built from separate copies keep separate budgets as they would
+// without instrumentation.
+func Instrument(cfg *rest.Config, onThrottle func(source string)) *rest.Config {
+	instrumented := rest.CopyConfig(cfg)
+	if instrumented.RateLimiter == nil {
+		qps, burst := instrumented.QPS, instrumented.Burst
+		if qps == 0 {
+			qps = rest.DefaultQPS
+		}
+		if burst == 0 {
+			burst = rest.DefaultBurst
+		}
+		instrumented.RateLimiter = &notifyingLimiter{
+			RateLimiter: flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+			onThrottle:  onThrottle,
+		}
+	}
+	instrumented.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &throttleObserver{next: rt, onThrottle: onThrottle}
+	})
+	return instrumented
+}
+
+// notifyingLimiter reports every wait the wrapped limiter imposes.
+type notifyingLimiter struct {
+	flowcontrol.RateLimiter
+	onThrottle func(source string)
+}
+
+func (l *notifyingLimiter) Accept() {
+	if !l.RateLimiter.TryAccept() {
+		l.onThrottle(ThrottleClient)
+		l.RateLimiter.Accept()
+	}
+}
+
+func (l *notifyingLimiter) Wait(ctx context.Context) error {
+	if l.RateLimiter.TryAccept() {
+		return nil
+	}
+	l.onThrottle(ThrottleClient)
+	return l.RateLimiter.Wait(ctx)
+}
+
+// throttleObserver reports 429 responses, including those client-go retries
+// on its own and which therefore never surface as errors.
+type throttleObserver struct {
+	next       http.RoundTripper
+	onThrottle func(source string)
+}
+
+func (t *throttleObserver) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		t.onThrottle(ThrottleServer)
+	}
+	return resp, err
+}
+
+// WrappedRoundTripper lets client-go's debugging helpers unwrap the observer.
+func (t *throttleObserver) WrappedRoundTripper() http.RoundTripper {
+	return t.next
+}
@@ -0,0 +1,55 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestInstrumentReportsThrottling(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"NamespaceList","apiVersion":"v1","items":[]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	var mu sync.Mutex
+	sources := map[string]int{}
+	cfg := Instrument(&rest.Config{Host: server.URL, QPS: 100, Burst: 1}, func(source string) {
+		mu.Lock()
+		defer mu.Unlock()
+		sources[source]++
+	})
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewForConfig() error = %v", err)
+	}
+
+	// The first attempt takes the only burst token and is rejected; client-go
+	// retries it, which has to wait for the limiter.
+	if _, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{}); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if sources[ThrottleServer] != 1 {
+		t.Fatalf("expected one server-side throttle, got %v", sources)
+	}
+	if sources[ThrottleClient] == 0 {
+		t.Fatalf("expected a client-side throttle, got %v", sources)
+	}
+}
@@ -0,0 +1,53 @@
+package productmetrics
+
+import (
+	"sync"
+	"time"
+)
+
+// EndpointClaims lets scrapers with overlapping selectors agree on which target
+// scrapes a pod endpoint, so the same series are not exported twice. The first
+// target to claim an endpoint owns it until its claim lapses, i.e. it has not
+// been renewed for two of the owner's intervals.
+type EndpointClaims struct {
+	mu     sync.Mutex
+	claims map[string]endpointClaim
+	// prunedAt is when lapsed claims were last removed.
+	prunedAt time.Time
+}
+
+type endpointClaim struct {
+	target  string
+	expires time.Time
+}
+
+// NewEndpointClaims returns an empty claim registry.
+func NewEndpointClaims() *EndpointClaims {
+	return &EndpointClaims{claims: make(map[string]endpointClaim)}
+}
+
+// claim reports whether target may scrape endpoint, taking or renewing the
+// claim for ttl when it does. A nil registry allows every scrape.
+func (c *EndpointClaims) claim(endpoint, target string, now time.Time, ttl time.Duration) bool {
+	if c == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.claims[endpoint]; ok && existing.target != target && now.Before(existing.expires) {
+		return false
+	}
+	c.claims[endpoint] = endpointClaim{target: target, expires: now.Add(ttl)}
+	// Lapsed claims of endpoints that are gone are swept at most once per
+	// ttl rather than on every call, which scans the whole registry.
+	if now.Sub(c.prunedAt) >= ttl {
+		c.prunedAt = now
+		for key, existing := range c.claims {
+			if !now.Before(existing.expires) {
+				delete(c.claims, key)
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package productmetrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEndpointClaims(t *testing.T) {
+	claims := NewEndpointClaims()
+	now := time.Unix(1_700_000_000, 0)
+	endpoint := "shop/pod-1/8080/metrics"
+
+	if !claims.claim(endpoint, "alpha", now, time.Minute) {
+		t.Fatal("expected the first target to claim the endpoint")
+	}
+	if claims.claim(endpoint, "beta", now.Add(30*time.Second), time.Minute) {
+		t.Fatal("expected a second target to be refused while the claim is live")
+	}
+	if !claims.claim(endpoint, "alpha", now.Add(50*time.Second), time.Minute) {
+		t.Fatal("expected the owner to renew its claim")
+	}
+	if !claims.claim(endpoint, "beta", now.Add(2*time.Minute), time.Minute) {
+		t.Fatal("expected another target to take over a lapsed claim")
+	}
+
+	claims.claim("shop/pod-2/8080/metrics", "alpha", now.Add(2*time.Minute+time.Second), time.Minute)
+	claims.claim("shop/pod-3/8080/metrics", "alpha", now.Add(5*time.Minute), time.Minute)
+	if got := len(claims.claims); got != 1 {
+		t.Fatalf("expected lapsed claims to be pruned, %d remain", got)
+	}
+
+	var unlimited *EndpointClaims
+	if !unlimited.claim(endpoint, "beta", now, time.Minute) {
+		t.Fatal("expected a nil registry to allow every scrape")
+	}
+}
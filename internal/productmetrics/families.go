@@ -0,0 +1,114 @@
+package productmetrics
+
+import (
+	"path"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// RuntimeMetricPatterns match the Go runtime, process, and promhttp families
+// that client libraries export from every pod.
+var RuntimeMetricPatterns = []string{"go_*", "process_*", "promhttp_*"}
+
+// cloneFamily deep-copies the family stored under name. It logs and returns nil
+// when the copy is not a usable *dto.MetricFamily, e.g. because a nil family
+// slipped into a map, so that callers skip that family instead of panicking
+// in a scrape or render goroutine.
+func cloneFamily(name string, family *dto.MetricFamily) *dto.MetricFamily {
+	clone, ok := proto.Clone(family).(*dto.MetricFamily)
+	if !ok || clone == nil {
+		logrus.WithField("component", "product-metrics").Errorf("skipping metric family %q: cannot copy %T", name, family)
+		return nil
+	}
+	return clone
+}
+
+// matchesAny reports whether name matches one of the path.Match patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// coerceUntyped rewrites an untyped family to the given gauge or counter type,
+// moving each sample's value to the matching field. Other families are left alone.
+func coerceUntyped(family *dto.MetricFamily, to dto.MetricType) {
+	if family.GetType() != dto.MetricType_UNTYPED {
+		return
+	}
+
+	switch to {
+	case dto.MetricType_GAUGE:
+		for _, metric := range family.Metric {
+			metric.Gauge = &dto.Gauge{Value: proto.Float64(metric.GetUntyped().GetValue())}
+			metric.Untyped = nil
+		}
+	case dto.MetricType_COUNTER:
+		for _, metric := range family.Metric {
+			metric.Counter = &dto.Counter{Value: proto.Float64(metric.GetUntyped().GetValue())}
+			metric.Untyped = nil
+		}
+	default:
+		return
+	}
+	family.Type = to.Enum()
+}
+
+// staleTimestamps counts samples in family whose explicit timestamp is more than
+// maxSkew away from now, clearing those timestamps when strip is set.
+func staleTimestamps(family *dto.MetricFamily, now time.Time, maxSkew time.Duration, strip bool) int {
+	var stale int
+	for _, metric := range family.Metric {
+		if metric.TimestampMs == nil {
+			continue
+		}
+		skew := now.Sub(time.UnixMilli(metric.GetTimestampMs()))
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew <= maxSkew {
+			continue
+		}
+		stale++
+		if strip {
+			metric.TimestampMs = nil
+		}
+	}
+	return stale
+}
+
+// countFamilyNames returns the number of distinct family names across pods,
+// i.e. the size of mergePods(pods) without merging.
+func countFamilyNames(pods map[string]map[string]*dto.MetricFamily) int {
+	names := make(map[string]struct{})
+	for _, families := range pods {
+		for name, family := range families {
+			if family != nil {
+				names[name] = struct{}{}
+			}
+		}
+	}
+	return len(names)
+}
+
+// checkFamilyCount records the number of distinct families a cycle scraped and
+// warns when it exceeds the configured ceiling or jumps since the last cycle,
+// which usually means a product started exporting far more than intended.
+func (s *Scraper) checkFamilyCount(count int) {
+	s.metrics.setFamilies(s.targetName, count)
+	previous := s.lastFamilyCount
+	s.lastFamilyCount = count
+
+	if s.maxFamilies > 0 && count > s.maxFamilies {
+		s.logger.Warnf("target %s scraped %d metric families, above the limit of %d", s.targetName, count, s.maxFamilies)
+	}
+	if s.maxFamilyGrowth > 0 && previous > 0 && count-previous > s.maxFamilyGrowth {
+		s.logger.Warnf("target %s metric families grew from %d to %d in one cycle", s.targetName, previous, count)
+	}
+}
@@ -0,0 +1,140 @@
+package productmetrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestCoerceUntyped(t *testing.T) {
+	parser := expfmt.TextParser{}
+	parsed, err := parser.TextToMetricFamilies(strings.NewReader("# TYPE requests untyped\nrequests{code=\"200\"} 42\n# TYPE up gauge\nup 1\n"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	coerceUntyped(parsed["requests"], dto.MetricType_COUNTER)
+	coerceUntyped(parsed["up"], dto.MetricType_COUNTER)
+
+	requests := parsed["requests"]
+	if requests.GetType() != dto.MetricType_COUNTER {
+		t.Fatalf("expected requests to become a counter, got %s", requests.GetType())
+	}
+	if metric := requests.Metric[0]; metric.GetCounter().GetValue() != 42 || metric.Untyped != nil {
+		t.Fatalf("expected the untyped value to move to the counter field, got %v", metric)
+	}
+	if parsed["up"].GetType() != dto.MetricType_GAUGE {
+		t.Fatalf("expected typed families to be left alone, got %s", parsed["up"].GetType())
+	}
+}
+
+func TestNilFamiliesAreSkipped(t *testing.T) {
+	good := &dto.MetricFamily{
+		Name:   proto.String("sample_total"),
+		Type:   dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(1)}}},
+	}
+	families := map[string]*dto.MetricFamily{"sample_total": good, "broken": nil}
+
+	if clone := cloneAndLabelFamily(nil, nil, labelRules{}); clone != nil {
+		t.Fatalf("expected no copy of a nil family, got %v", clone)
+	}
+
+	merged := make(map[string]*dto.MetricFamily)
+	mergeInto(merged, families)
+	if _, ok := merged["broken"]; ok || merged["sample_total"] == nil {
+		t.Fatalf("expected only sample_total to be merged, got %v", merged)
+	}
+
+	store := NewStore()
+	store.Replace("alpha", families)
+	got := writeAndParse(t, store)
+	if _, ok := got["broken"]; ok || got["sample_total"] == nil {
+		t.Fatalf("expected only sample_total to be rendered, got %v", got)
+	}
+}
+
+func TestMatchesAnyRuntimePatterns(t *testing.T) {
+	cases := map[string]bool{
+		"go_goroutines":                        true,
+		"process_cpu_seconds_total":            true,
+		"promhttp_metric_handler_errors_total": true,
+		"orders_total":                         false,
+		"cargo_shipments":                      false,
+	}
+	for name, want := range cases {
+		if got := matchesAny(RuntimeMetricPatterns, name); got != want {
+			t.Errorf("matchesAny(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestStaleTimestamps(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	parser := expfmt.TextParser{}
+	parsed, err := parser.TextToMetricFamilies(strings.NewReader(
+		"up{i=\"fresh\"} 1 1699999990000\nup{i=\"stale\"} 1 1699990000000\nup{i=\"future\"} 1 1700010000000\nup{i=\"none\"} 1\n"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	family := parsed["up"]
+
+	if got := staleTimestamps(family, now, time.Minute, true); got != 2 {
+		t.Fatalf("expected 2 stale samples, got %d", got)
+	}
+	for _, metric := range family.Metric {
+		fresh := metric.Label[0].GetValue() == "fresh"
+		if fresh != (metric.TimestampMs != nil) {
+			t.Errorf("sample %s: unexpected timestamp %v", metric.Label[0].GetValue(), metric.TimestampMs)
+		}
+	}
+}
+
+func TestCheckFamilyCountWarns(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	scraper := NewScraper("alpha", nil, nil, NewStore(), time.Second, 9100, "/metrics", "", "", logger,
+		WithFamilyCountWarning(100, 10))
+
+	scraper.checkFamilyCount(20)
+	if len(hook.AllEntries()) != 0 {
+		t.Fatalf("expected no warning for the first cycle, got %v", hook.AllEntries())
+	}
+	scraper.checkFamilyCount(25)
+	if len(hook.AllEntries()) != 0 {
+		t.Fatalf("expected no warning for growth within the delta, got %v", hook.AllEntries())
+	}
+
+	scraper.checkFamilyCount(40)
+	if entry := hook.LastEntry(); entry == nil || !strings.Contains(entry.Message, "grew from 25 to 40") {
+		t.Fatalf("expected a growth warning, got %v", entry)
+	}
+	hook.Reset()
+
+	scraper.checkFamilyCount(101)
+	var exceeded bool
+	for _, entry := range hook.AllEntries() {
+		exceeded = exceeded || strings.Contains(entry.Message, "above the limit of 100")
+	}
+	if !exceeded {
+		t.Fatalf("expected a limit warning, got %v", hook.AllEntries())
+	}
+	if got := testutil.ToFloat64(scraper.metrics.families.WithLabelValues("alpha")); got != 101 {
+		t.Fatalf("product_scrape_families = %v, want 101", got)
+	}
+}
+
+func TestCountFamilyNames(t *testing.T) {
+	pods := map[string]map[string]*dto.MetricFamily{
+		"ns-a/pod-1": {"a": {Name: proto.String("a")}, "b": {Name: proto.String("b")}},
+		"ns-a/pod-2": {"b": {Name: proto.String("b")}, "c": nil},
+	}
+	if got, want := countFamilyNames(pods), len(mergePods(pods)); got != want || got != 2 {
+		t.Fatalf("countFamilyNames() = %d, want %d like mergePods", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package productmetrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// truncationMarker is appended to label values shortened by labelRules.
+const truncationMarker = "..."
+
+// redactedValue replaces the values of redacted labels unless they are hashed.
+const redactedValue = "REDACTED"
+
+// labelRules are transformations applied to scraped label values before the
+// exporter's own labels are injected.
+type labelRules struct {
+	// maxValueLength caps label values at this many bytes; zero disables it.
+	maxValueLength int
+	// dropOversized removes oversized labels instead of truncating them.
+	dropOversized bool
+	// redact names the labels whose values are hidden, before any truncation.
+	redact map[string]bool
+	// hashRedacted replaces redacted values with a hash of the value rather
+	// than redactedValue, so that series stay distinct.
+	hashRedacted bool
+}
+
+// apply rewrites metric's labels in place according to the rules.
+func (r labelRules) apply(metric *dto.Metric) {
+	if len(r.redact) > 0 {
+		for _, label := range metric.Label {
+			if r.redact[label.GetName()] {
+				label.Value = proto.String(r.redactValue(label.GetValue()))
+			}
+		}
+	}
+	if r.maxValueLength <= 0 {
+		return
+	}
+
+	kept := metric.Label[:0]
+	for _, label := range metric.Label {
+		value := label.GetValue()
+		if len(value) > r.maxValueLength {
+			if r.dropOversized {
+				continue
+			}
+			label.Value = proto.String(truncateValue(value, r.maxValueLength))
+		}
+		kept = append(kept, label)
+	}
+	metric.Label = kept
+}
+
+// redactValue returns the replacement for the value of a redacted label: the
+// first 8 bytes of its SHA-256 in hex when hashing, or redactedValue.
+func (r labelRules) redactValue(value string) string {
+	if !r.hashRedacted {
+		return redactedValue
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// truncateValue shortens value to at most max bytes including the marker,
+// cutting on a rune boundary so the result stays valid UTF-8.
+func truncateValue(value string, max int) string {
+	marker := truncationMarker
+	if max <= len(marker) {
+		marker = ""
+	}
+	cut := max - len(marker)
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	return value[:cut] + marker
+}
+
+// sanitizeLabelName maps a Kubernetes label key such as "cost-center" or
+// "example.com/team" to a valid Prometheus label name by replacing every
+// invalid character with an underscore.
+func sanitizeLabelName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
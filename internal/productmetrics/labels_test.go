@@ -0,0 +1,67 @@
+package productmetrics
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestTruncateValueIsUTF8Safe(t *testing.T) {
+	value := "héllo wörld"
+	for max := 1; max < len(value); max++ {
+		got := truncateValue(value, max)
+		if len(got) > max {
+			t.Fatalf("max=%d: %q exceeds limit", max, got)
+		}
+		if !utf8.ValidString(got) {
+			t.Fatalf("max=%d: %q is not valid UTF-8", max, got)
+		}
+	}
+	if got := truncateValue(value, 8); got != "héll"+truncationMarker {
+		t.Fatalf("unexpected truncation %q", got)
+	}
+}
+
+func TestLabelRulesDropOversized(t *testing.T) {
+	metric := &dto.Metric{Label: []*dto.LabelPair{
+		{Name: proto.String("short"), Value: proto.String("ok")},
+		{Name: proto.String("trace"), Value: proto.String("a very long stack trace")},
+	}}
+
+	labelRules{maxValueLength: 5, dropOversized: true}.apply(metric)
+
+	if len(metric.Label) != 1 || metric.Label[0].GetName() != "short" {
+		t.Fatalf("expected only the short label to remain, got %v", metric.Label)
+	}
+}
+
+func TestLabelRulesRedact(t *testing.T) {
+	newMetric := func(token string) *dto.Metric {
+		return &dto.Metric{Label: []*dto.LabelPair{
+			{Name: proto.String("auth_token"), Value: proto.String(token)},
+			{Name: proto.String("path"), Value: proto.String("/login")},
+		}}
+	}
+	rules := labelRules{redact: map[string]bool{"auth_token": true}}
+
+	metric := newMetric("s3cr3t")
+	rules.apply(metric)
+	if got := metric.Label[0].GetValue(); got != redactedValue {
+		t.Fatalf("expected auth_token to be redacted, got %q", got)
+	}
+	if got := metric.Label[1].GetValue(); got != "/login" {
+		t.Fatalf("expected path to be untouched, got %q", got)
+	}
+
+	rules.hashRedacted = true
+	first, second := newMetric("s3cr3t"), newMetric("0ther")
+	rules.apply(first)
+	rules.apply(second)
+	hashed := first.Label[0].GetValue()
+	if hashed == "s3cr3t" || !strings.HasPrefix(hashed, "sha256:") || hashed == second.Label[0].GetValue() {
+		t.Fatalf("expected distinct hashed values, got %q and %q", hashed, second.Label[0].GetValue())
+	}
+}
@@ -0,0 +1,97 @@
+package productmetrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ScrapeLimiter bounds the number of simultaneous pod scrapes. A single limiter
+// may be shared by several scrapers to cap the total across targets. A nil
+// *ScrapeLimiter imposes no limit.
+type ScrapeLimiter struct {
+	tokens chan struct{}
+}
+
+// NewScrapeLimiter returns a limiter allowing n concurrent scrapes, or nil
+// (unlimited) when n is not positive.
+func NewScrapeLimiter(n int) *ScrapeLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &ScrapeLimiter{tokens: make(chan struct{}, n)}
+}
+
+// acquire blocks until a token is available or ctx is done.
+func (l *ScrapeLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.tokens <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *ScrapeLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.tokens
+}
+
+// GoroutineLimiter caps the goroutines actively scraping pods across every
+// scraper that shares it, and counts them on a gauge. A scraper waits for a
+// free slot before starting one, so cycles queue instead of spawning past the
+// cap. The loop of a pod on its own interval holds a slot only while it
+// scrapes, so idle loops are neither capped nor counted. A nil
+// *GoroutineLimiter neither limits nor counts.
+type GoroutineLimiter struct {
+	// tokens is nil when the number of goroutines is not capped.
+	tokens chan struct{}
+	active prometheus.Gauge
+}
+
+// NewGoroutineLimiter returns a limiter allowing n scrape goroutines, or
+// counting them without a cap when n is not positive. active, if not nil,
+// receives the number of goroutines running.
+func NewGoroutineLimiter(n int, active prometheus.Gauge) *GoroutineLimiter {
+	l := &GoroutineLimiter{active: active}
+	if n > 0 {
+		l.tokens = make(chan struct{}, n)
+	}
+	return l
+}
+
+// start blocks until a goroutine may be started or ctx is done.
+func (l *GoroutineLimiter) start(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	if l.tokens != nil {
+		select {
+		case l.tokens <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if l.active != nil {
+		l.active.Inc()
+	}
+	return nil
+}
+
+// done marks a goroutine admitted by start as finished.
+func (l *GoroutineLimiter) done() {
+	if l == nil {
+		return
+	}
+	if l.active != nil {
+		l.active.Dec()
+	}
+	if l.tokens != nil {
+		<-l.tokens
+	}
+}
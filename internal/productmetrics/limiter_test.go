@@ -0,0 +1,90 @@
+package productmetrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScrapeLimiterBlocksAtCapacity(t *testing.T) {
+	limiter := NewScrapeLimiter(1)
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("first acquire error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected acquire to block until the deadline, got %v", err)
+	}
+
+	limiter.release()
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire after release error = %v", err)
+	}
+}
+
+func TestNilScrapeLimiterIsUnlimited(t *testing.T) {
+	limiter := NewScrapeLimiter(0)
+	for i := 0; i < 3; i++ {
+		if err := limiter.acquire(context.Background()); err != nil {
+			t.Fatalf("acquire error = %v", err)
+		}
+	}
+	limiter.release()
+}
+
+func TestGoroutineLimiterCapsAndCounts(t *testing.T) {
+	active := prometheus.NewGauge(prometheus.GaugeOpts{Name: "active"})
+	limiter := NewGoroutineLimiter(2, active)
+	for i := 0; i < 2; i++ {
+		if err := limiter.start(context.Background()); err != nil {
+			t.Fatalf("start %d error = %v", i, err)
+		}
+	}
+	if got := testutil.ToFloat64(active); got != 2 {
+		t.Fatalf("expected 2 active goroutines, got %v", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.start(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected start to wait at the cap, got %v", err)
+	}
+
+	limiter.done()
+	if got := testutil.ToFloat64(active); got != 1 {
+		t.Fatalf("expected 1 active goroutine after done, got %v", got)
+	}
+	if err := limiter.start(context.Background()); err != nil {
+		t.Fatalf("start after done error = %v", err)
+	}
+}
+
+func TestUncappedGoroutineLimiterCounts(t *testing.T) {
+	active := prometheus.NewGauge(prometheus.GaugeOpts{Name: "active"})
+	limiter := NewGoroutineLimiter(0, active)
+	for i := 0; i < 3; i++ {
+		if err := limiter.start(context.Background()); err != nil {
+			t.Fatalf("start error = %v", err)
+		}
+	}
+	if got := testutil.ToFloat64(active); got != 3 {
+		t.Fatalf("expected 3 active goroutines, got %v", got)
+	}
+}
+
+func TestGoroutineLimiterWithoutGauge(t *testing.T) {
+	limiter := NewGoroutineLimiter(1, nil)
+	if err := limiter.start(context.Background()); err != nil {
+		t.Fatalf("start error = %v", err)
+	}
+	limiter.done()
+	if err := limiter.start(context.Background()); err != nil {
+		t.Fatalf("start after done error = %v", err)
+	}
+}
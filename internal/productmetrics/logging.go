@@ -0,0 +1,26 @@
+package productmetrics
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type loggerKey struct{}
+
+// withNamespaceLogger returns a context carrying the scraper's logger with
+// target and namespace fields, for helpers shared across targets.
+func (s *Scraper) withNamespaceLogger(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, loggerKey{}, s.logger.WithFields(logrus.Fields{
+		"target":    s.targetName,
+		"namespace": namespace,
+	}))
+}
+
+// loggerFrom returns the logger carried by ctx, falling back to the scraper's own.
+func (s *Scraper) loggerFrom(ctx context.Context) logrus.FieldLogger {
+	if logger, ok := ctx.Value(loggerKey{}).(logrus.FieldLogger); ok {
+		return logger
+	}
+	return s.logger
+}
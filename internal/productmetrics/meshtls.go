@@ -0,0 +1,122 @@
+package productmetrics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File names of the workload certificates within a mesh certificate directory.
+const (
+	MeshCertChainFile = "cert-chain.pem"
+	MeshKeyFile       = "key.pem"
+	MeshRootCertFile  = "root-cert.pem"
+)
+
+// NewMeshTLSConfig returns a client TLS configuration presenting the workload
+// certificate found in dir and trusting the mesh root certificate next to it,
+// for scraping pods whose sidecars enforce STRICT mTLS. The files are re-read
+// whenever they change, so rotated certificates are picked up by the next
+// handshake; if a rotation is caught half-written the previous credentials are
+// kept until the files are consistent again.
+//
+// Mesh certificates identify workloads by SPIFFE URI rather than by the pod IP
+// that is dialled, so the peer's chain is verified against the mesh roots
+// without a hostname check.
+func NewMeshTLSConfig(dir string) (*tls.Config, error) {
+	creds := &meshCredentials{dir: dir}
+	if err := creds.refresh(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		InsecureSkipVerify:   true,
+		GetClientCertificate: creds.clientCertificate,
+		VerifyConnection:     creds.verifyConnection,
+	}, nil
+}
+
+// meshCredentials caches the certificates of a mesh certificate directory.
+type meshCredentials struct {
+	dir string
+
+	mu      sync.Mutex
+	modTime time.Time
+	cert    *tls.Certificate
+	roots   *x509.CertPool
+}
+
+// refresh reloads the certificates when any file is newer than the cached
+// copy. On failure the cached credentials, if any, stay in use.
+func (m *meshCredentials) refresh() error {
+	var latest time.Time
+	for _, name := range []string{MeshCertChainFile, MeshKeyFile, MeshRootCertFile} {
+		info, err := os.Stat(filepath.Join(m.dir, name))
+		if err != nil {
+			return fmt.Errorf("stat mesh certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert != nil && !latest.After(m.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(m.dir, MeshCertChainFile), filepath.Join(m.dir, MeshKeyFile))
+	if err != nil {
+		return fmt.Errorf("load mesh certificate: %w", err)
+	}
+	pem, err := os.ReadFile(filepath.Join(m.dir, MeshRootCertFile))
+	if err != nil {
+		return fmt.Errorf("read mesh root certificate: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("%s contains no PEM certificates", MeshRootCertFile)
+	}
+
+	m.modTime = latest
+	m.cert = &cert
+	m.roots = roots
+	return nil
+}
+
+// current returns the cached credentials after trying to refresh them.
+func (m *meshCredentials) current() (*tls.Certificate, *x509.CertPool) {
+	// A failed refresh keeps the previous credentials, which NewMeshTLSConfig
+	// guarantees exist.
+	_ = m.refresh()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cert, m.roots
+}
+
+func (m *meshCredentials) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, _ := m.current()
+	return cert, nil
+}
+
+func (m *meshCredentials) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("peer presented no certificate")
+	}
+	_, roots := m.current()
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
@@ -0,0 +1,161 @@
+package productmetrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewMeshTLSConfigPresentsAndRotatesWorkloadCert(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestMeshCA(t)
+	first.writeWorkloadCerts(t, dir, time.Now().Add(-time.Hour))
+
+	tlsConfig, err := NewMeshTLSConfig(dir)
+	if err != nil {
+		t.Fatalf("NewMeshTLSConfig() error = %v", err)
+	}
+	client := NewHTTPClient(TransportOptions{Timeout: 5 * time.Second, TLS: tlsConfig})
+
+	if err := getFromMeshServer(t, client, first); err != nil {
+		t.Fatalf("mTLS scrape error = %v", err)
+	}
+
+	// After rotation the workload's sidecar trusts only the new root.
+	second := newTestMeshCA(t)
+	if err := getFromMeshServer(t, client, second); err == nil {
+		t.Fatal("expected the old certificate to be rejected by a server of the new mesh")
+	}
+	second.writeWorkloadCerts(t, dir, time.Now())
+	if err := getFromMeshServer(t, client, second); err != nil {
+		t.Fatalf("mTLS scrape after rotation error = %v", err)
+	}
+}
+
+func TestNewMeshTLSConfigRequiresCertificates(t *testing.T) {
+	if _, err := NewMeshTLSConfig(t.TempDir()); err == nil {
+		t.Fatal("expected an error for a directory without mesh certificates")
+	}
+}
+
+// getFromMeshServer requests a server whose certificate is issued by ca and
+// that requires a client certificate issued by ca.
+func getFromMeshServer(t *testing.T, client *http.Client, ca *testMeshCA) error {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool(),
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	// Connections are not reused across servers.
+	client.CloseIdleConnections()
+	serverURL, _ := url.Parse(server.URL)
+	resp, err := client.Get("https://" + serverURL.Host)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type testMeshCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestMeshCA(t *testing.T) *testMeshCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"cluster.local"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA certificate: %v", err)
+	}
+	return &testMeshCA{cert: cert, key: key}
+}
+
+func (ca *testMeshCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue returns a workload certificate identified only by a SPIFFE URI, as
+// Istio issues them.
+func (ca *testMeshCA) issue(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/monitoring/sa/vs-exporter")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{spiffe},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create workload certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeWorkloadCerts writes a fresh workload certificate, its key and the root
+// to dir, stamping the files with modTime.
+func (ca *testMeshCA) writeWorkloadCerts(t *testing.T, dir string, modTime time.Time) {
+	t.Helper()
+	cert := ca.issue(t)
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	files := map[string][]byte{
+		MeshCertChainFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}),
+		MeshKeyFile:       pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		MeshRootCertFile:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("chtimes %s: %v", name, err)
+		}
+	}
+}
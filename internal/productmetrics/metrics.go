@@ -0,0 +1,227 @@
+package productmetrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the exporter's own instrumentation for product scraping.
+type Metrics struct {
+	targets       prometheus.Gauge
+	podDuration   *prometheus.HistogramVec
+	nsDuration    *prometheus.HistogramVec
+	parseDuration *prometheus.HistogramVec
+	skippedNoIP   *prometheus.GaugeVec
+	responseBytes *prometheus.GaugeVec
+	staleStamps   *prometheus.CounterVec
+	stalled       *prometheus.GaugeVec
+	families      *prometheus.GaugeVec
+	overrun       *prometheus.GaugeVec
+	timestamp     *prometheus.GaugeVec
+	invalidBody   *prometheus.CounterVec
+	interval      *prometheus.GaugeVec
+}
+
+// NewMetrics returns an unregistered Metrics instance.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		targets: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "product_scrape_targets",
+				Help: "Number of product scrape targets the exporter is running.",
+			},
+		),
+		podDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "product_scrape_pod_duration_seconds",
+				Help:    "Duration of individual pod scrapes, including failed attempts.",
+				Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			},
+			[]string{"target"},
+		),
+		nsDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "product_scrape_namespace_duration_seconds",
+				Help:    "Duration of scraping all shared-cycle pods in a namespace.",
+				Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+			},
+			[]string{"target", "namespace"},
+		),
+		parseDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "product_scrape_parse_duration_seconds",
+				Help:    "Time spent parsing a pod's response body, excluding time spent waiting for it to arrive.",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+			},
+			[]string{"target"},
+		),
+		skippedNoIP: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "product_scrape_pods_skipped_no_ip",
+				Help: "Number of pods discovered in the last cycle that were skipped because they had no pod IP yet.",
+			},
+			[]string{"target"},
+		),
+		responseBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "product_scrape_response_bytes",
+				Help: "Total response body bytes read from all pods in the target's last scrape cycle.",
+			},
+			[]string{"target"},
+		),
+		staleStamps: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "product_scrape_stale_timestamp_total",
+				Help: "Scraped samples whose explicit timestamp was further from the exporter's clock than the target's maxTimestampSkew.",
+			},
+			[]string{"target"},
+		),
+		stalled: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "product_scrape_cycle_stalled",
+				Help: "Whether the target's scrape loop has not completed a cycle within the watchdog limit (1) or is healthy (0).",
+			},
+			[]string{"target"},
+		),
+		families: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "product_scrape_families",
+				Help: "Number of distinct metric family names scraped for the target in its last shared cycle.",
+			},
+			[]string{"target"},
+		),
+		overrun: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "product_scrape_interval_overrun_seconds",
+				Help: "How long the target's last shared cycle finished after its next scheduled tick; 0 when it finished within the interval.",
+			},
+			[]string{"target"},
+		),
+		timestamp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "product_scrape_timestamp_seconds",
+				Help: "Unix time at which the target's last shared cycle finished, whether or not it succeeded.",
+			},
+			[]string{"target"},
+		),
+		invalidBody: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "product_scrape_invalid_body_total",
+				Help: "Pod scrapes whose response was not a text exposition: NUL bytes or invalid UTF-8 at the start of the body.",
+			},
+			[]string{"target"},
+		),
+		interval: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "product_scrape_interval_seconds",
+				Help: "Configured shared scrape interval of the target.",
+			},
+			[]string{"target"},
+		),
+	}
+}
+
+// SetTargets records the number of running scrape targets.
+func (m *Metrics) SetTargets(count int) {
+	m.targets.Set(float64(count))
+}
+
+// SetCycleStalled records the watchdog verdict for target.
+func (m *Metrics) SetCycleStalled(target string, stalled bool) {
+	value := 0.0
+	if stalled {
+		value = 1
+	}
+	m.stalled.WithLabelValues(target).Set(value)
+}
+
+// Forget deletes every series of target, whose scraper was removed, so that
+// its last values are not exported forever.
+func (m *Metrics) Forget(target string) {
+	labels := prometheus.Labels{"target": target}
+	for _, vec := range []interface{ DeletePartialMatch(prometheus.Labels) int }{
+		m.podDuration, m.nsDuration, m.parseDuration, m.skippedNoIP, m.responseBytes, m.staleStamps,
+		m.stalled, m.families, m.overrun, m.timestamp, m.invalidBody, m.interval,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+func (m *Metrics) observePodDuration(target string, seconds float64) {
+	m.podDuration.WithLabelValues(target).Observe(seconds)
+}
+
+func (m *Metrics) observeNamespaceDuration(target, namespace string, seconds float64) {
+	m.nsDuration.WithLabelValues(target, namespace).Observe(seconds)
+}
+
+func (m *Metrics) observeParseDuration(target string, seconds float64) {
+	m.parseDuration.WithLabelValues(target).Observe(seconds)
+}
+
+func (m *Metrics) setSkippedNoIP(target string, count int) {
+	m.skippedNoIP.WithLabelValues(target).Set(float64(count))
+}
+
+func (m *Metrics) setResponseBytes(target string, size int64) {
+	m.responseBytes.WithLabelValues(target).Set(float64(size))
+}
+
+func (m *Metrics) addStaleTimestamps(target string, count int) {
+	m.staleStamps.WithLabelValues(target).Add(float64(count))
+}
+
+func (m *Metrics) setFamilies(target string, count int) {
+	m.families.WithLabelValues(target).Set(float64(count))
+}
+
+func (m *Metrics) setIntervalOverrun(target string, overrun time.Duration) {
+	m.overrun.WithLabelValues(target).Set(overrun.Seconds())
+}
+
+func (m *Metrics) setScrapeTimestamp(target string, at time.Time) {
+	m.timestamp.WithLabelValues(target).Set(float64(at.UnixNano()) / 1e9)
+}
+
+func (m *Metrics) setInterval(target string, interval time.Duration) {
+	m.interval.WithLabelValues(target).Set(interval.Seconds())
+}
+
+func (m *Metrics) addInvalidBody(target string) {
+	m.invalidBody.WithLabelValues(target).Inc()
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.targets.Describe(ch)
+	m.podDuration.Describe(ch)
+	m.nsDuration.Describe(ch)
+	m.parseDuration.Describe(ch)
+	m.skippedNoIP.Describe(ch)
+	m.responseBytes.Describe(ch)
+	m.staleStamps.Describe(ch)
+	m.stalled.Describe(ch)
+	m.families.Describe(ch)
+	m.overrun.Describe(ch)
+	m.timestamp.Describe(ch)
+	m.invalidBody.Describe(ch)
+	m.interval.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.targets.Collect(ch)
+	m.podDuration.Collect(ch)
+	m.nsDuration.Collect(ch)
+	m.parseDuration.Collect(ch)
+	m.skippedNoIP.Collect(ch)
+	m.responseBytes.Collect(ch)
+	m.staleStamps.Collect(ch)
+	m.stalled.Collect(ch)
+	m.families.Collect(ch)
+	m.overrun.Collect(ch)
+	m.timestamp.Collect(ch)
+	m.invalidBody.Collect(ch)
+	m.interval.Collect(ch)
+}
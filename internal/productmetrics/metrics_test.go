@@ -0,0 +1,53 @@
+package productmetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestForgetDeletesEverySeriesOfTarget(t *testing.T) {
+	metrics := NewMetrics()
+	for _, target := range []string{"removed", "kept"} {
+		metrics.observePodDuration(target, 0.1)
+		metrics.observeNamespaceDuration(target, "shop", 0.2)
+		metrics.observeNamespaceDuration(target, "cart", 0.2)
+		metrics.observeParseDuration(target, 0.01)
+		metrics.setSkippedNoIP(target, 1)
+		metrics.setResponseBytes(target, 512)
+		metrics.addStaleTimestamps(target, 1)
+		metrics.SetCycleStalled(target, false)
+		metrics.setFamilies(target, 3)
+		metrics.setIntervalOverrun(target, time.Second)
+		metrics.setScrapeTimestamp(target, time.Unix(1700000000, 0))
+		metrics.setInterval(target, time.Minute)
+		metrics.addInvalidBody(target)
+	}
+
+	metrics.Forget("removed")
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	kept := 0
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() != "target" {
+					continue
+				}
+				if label.GetValue() == "removed" {
+					t.Errorf("%s still has a series for the removed target", family.GetName())
+				}
+				kept++
+			}
+		}
+	}
+	if kept != 13 {
+		t.Fatalf("kept target has %d series, want 13", kept)
+	}
+}
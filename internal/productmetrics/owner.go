@@ -0,0 +1,38 @@
+package productmetrics
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// workloadOwner resolves the workload controlling pod as kind and name,
+// following a ReplicaSet to the Deployment that owns it. Pods without a
+// controller return empty strings. replicaSets caches the resolved owner of
+// every ReplicaSet looked up during one cycle, keyed by namespace/name.
+func (s *Scraper) workloadOwner(ctx context.Context, pod *corev1.Pod, replicaSets map[string]metav1.OwnerReference) (string, string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", "", nil
+	}
+	if owner.Kind != "ReplicaSet" {
+		return owner.Kind, owner.Name, nil
+	}
+
+	key := pod.Namespace + "/" + owner.Name
+	resolved, ok := replicaSets[key]
+	if !ok {
+		rs, err := s.clientset.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", "", fmt.Errorf("get replicaset %s: %w", key, err)
+		}
+		resolved = *owner
+		if parent := metav1.GetControllerOf(rs); parent != nil && parent.Kind == "Deployment" {
+			resolved = *parent
+		}
+		replicaSets[key] = resolved
+	}
+	return resolved.Kind, resolved.Name, nil
+}
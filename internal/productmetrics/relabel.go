@@ -0,0 +1,244 @@
+package productmetrics
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Supported relabel actions, a subset of Prometheus's metric_relabel_configs.
+const (
+	RelabelKeep    = "keep"
+	RelabelDrop    = "drop"
+	RelabelReplace = "replace"
+	// RelabelLabelDrop removes every label whose name matches the regex, then
+	// merges the series that became identical (see mergeSeries).
+	RelabelLabelDrop = "labeldrop"
+)
+
+// metricNameLabel exposes the family name to relabel rules, as in Prometheus.
+const metricNameLabel = "__name__"
+
+// RelabelConfig is the uncompiled form of a metric relabel rule. Empty fields
+// take Prometheus's defaults: separator ";", regex "(.*)", replacement "$1",
+// and action "replace".
+type RelabelConfig struct {
+	SourceLabels []string
+	Separator    string
+	Regex        string
+	TargetLabel  string
+	Replacement  string
+	Action       string
+}
+
+// RelabelRule is a compiled RelabelConfig.
+type RelabelRule struct {
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+// CompileRelabelings validates configs and compiles their anchored regexes.
+func CompileRelabelings(configs []RelabelConfig) ([]RelabelRule, error) {
+	rules := make([]RelabelRule, 0, len(configs))
+	for i, cfg := range configs {
+		rule := RelabelRule{
+			sourceLabels: cfg.SourceLabels,
+			separator:    cfg.Separator,
+			targetLabel:  cfg.TargetLabel,
+			replacement:  cfg.Replacement,
+			action:       cfg.Action,
+		}
+		if rule.separator == "" {
+			rule.separator = ";"
+		}
+		if rule.replacement == "" {
+			rule.replacement = "$1"
+		}
+		if rule.action == "" {
+			rule.action = RelabelReplace
+		}
+		expr := cfg.Regex
+		if expr == "" {
+			// The default would make labeldrop remove every label.
+			if rule.action == RelabelLabelDrop {
+				return nil, fmt.Errorf("relabeling %d: labeldrop requires a regex", i)
+			}
+			expr = "(.*)"
+		}
+
+		regex, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("relabeling %d: invalid regex: %w", i, err)
+		}
+		rule.regex = regex
+
+		switch rule.action {
+		case RelabelKeep, RelabelDrop, RelabelLabelDrop:
+		case RelabelReplace:
+			if rule.targetLabel == "" || rule.targetLabel == metricNameLabel {
+				return nil, fmt.Errorf("relabeling %d: replace requires a targetLabel other than %s", i, metricNameLabel)
+			}
+		default:
+			return nil, fmt.Errorf("relabeling %d: unsupported action %q", i, rule.action)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// relabelFamily applies rules to every metric of family in place, removing
+// dropped metrics. It reports whether any metric is left.
+func relabelFamily(family *dto.MetricFamily, rules []RelabelRule) bool {
+	if len(rules) == 0 {
+		return true
+	}
+
+	kept := family.Metric[:0]
+	for _, metric := range family.Metric {
+		if relabelMetric(family.GetName(), metric, rules) {
+			kept = append(kept, metric)
+		}
+	}
+	family.Metric = kept
+	for _, rule := range rules {
+		if rule.action == RelabelLabelDrop {
+			mergeSeries(family)
+			break
+		}
+	}
+	return len(family.Metric) > 0
+}
+
+// mergeSeries combines metrics of family that share a label set, as left by
+// labeldrop. Counters and histograms (with identical bucket bounds) are summed;
+// any other type keeps the last series. Merged series keep the position of
+// their first occurrence.
+func mergeSeries(family *dto.MetricFamily) {
+	index := make(map[string]int, len(family.Metric))
+	merged := family.Metric[:0]
+	for _, metric := range family.Metric {
+		key := labelKey(metric)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, metric)
+			continue
+		}
+		if !addSeries(family.GetType(), merged[i], metric) {
+			merged[i] = metric
+		}
+	}
+	family.Metric = merged
+}
+
+// addSeries adds the values of from into into and reports whether the type
+// could be summed.
+func addSeries(typ dto.MetricType, into, from *dto.Metric) bool {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		if into.Counter == nil || from.Counter == nil {
+			return false
+		}
+		into.Counter.Value = proto.Float64(into.Counter.GetValue() + from.Counter.GetValue())
+		return true
+	case dto.MetricType_HISTOGRAM:
+		a, b := into.Histogram, from.Histogram
+		if a == nil || b == nil || len(a.Bucket) != len(b.Bucket) {
+			return false
+		}
+		for i := range a.Bucket {
+			if a.Bucket[i].GetUpperBound() != b.Bucket[i].GetUpperBound() {
+				return false
+			}
+		}
+		for i := range a.Bucket {
+			a.Bucket[i].CumulativeCount = proto.Uint64(a.Bucket[i].GetCumulativeCount() + b.Bucket[i].GetCumulativeCount())
+		}
+		a.SampleCount = proto.Uint64(a.GetSampleCount() + b.GetSampleCount())
+		a.SampleSum = proto.Float64(a.GetSampleSum() + b.GetSampleSum())
+		return true
+	}
+	return false
+}
+
+// relabelMetric applies rules in order and reports whether metric is kept.
+func relabelMetric(name string, metric *dto.Metric, rules []RelabelRule) bool {
+	for _, rule := range rules {
+		values := make([]string, len(rule.sourceLabels))
+		for i, source := range rule.sourceLabels {
+			values[i] = sampleLabelValue(name, metric, source)
+		}
+		value := strings.Join(values, rule.separator)
+
+		switch rule.action {
+		case RelabelKeep:
+			if !rule.regex.MatchString(value) {
+				return false
+			}
+		case RelabelDrop:
+			if rule.regex.MatchString(value) {
+				return false
+			}
+		case RelabelLabelDrop:
+			labels := metric.Label[:0]
+			for _, pair := range metric.Label {
+				if !rule.regex.MatchString(pair.GetName()) {
+					labels = append(labels, pair)
+				}
+			}
+			metric.Label = labels
+		case RelabelReplace:
+			match := rule.regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			result := rule.regex.ExpandString(nil, rule.replacement, value, match)
+			setLabel(metric, rule.targetLabel, string(result))
+		}
+	}
+	return true
+}
+
+func sampleLabelValue(name string, metric *dto.Metric, label string) string {
+	if label == metricNameLabel {
+		return name
+	}
+	for _, pair := range metric.Label {
+		if pair.GetName() == label {
+			return pair.GetValue()
+		}
+	}
+	return ""
+}
+
+// setLabel sets label on metric, removing it when value is empty as
+// Prometheus does. A new label is inserted in name order.
+func setLabel(metric *dto.Metric, label, value string) {
+	for i, pair := range metric.Label {
+		if pair.GetName() != label {
+			continue
+		}
+		if value == "" {
+			metric.Label = append(metric.Label[:i], metric.Label[i+1:]...)
+		} else {
+			pair.Value = proto.String(value)
+		}
+		return
+	}
+	if value == "" {
+		return
+	}
+	// Labels are kept sorted by name, as the parser returns them.
+	i := sort.Search(len(metric.Label), func(i int) bool { return metric.Label[i].GetName() > label })
+	metric.Label = append(metric.Label, nil)
+	copy(metric.Label[i+1:], metric.Label[i:])
+	metric.Label[i] = &dto.LabelPair{Name: proto.String(label), Value: proto.String(value)}
+}
@@ -0,0 +1,146 @@
+package productmetrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRelabelFamily(t *testing.T) {
+	rules, err := CompileRelabelings([]RelabelConfig{
+		{SourceLabels: []string{"path"}, Regex: "/healthz", Action: RelabelDrop},
+		{SourceLabels: []string{"code"}, Regex: "(\\d)\\d\\d", TargetLabel: "code_class", Replacement: "${1}xx"},
+		{SourceLabels: []string{"__name__", "namespace"}, Regex: "http_requests_total;shop", Action: RelabelKeep},
+	})
+	if err != nil {
+		t.Fatalf("CompileRelabelings() error = %v", err)
+	}
+
+	family := &dto.MetricFamily{
+		Name: proto.String("http_requests_total"),
+		Type: dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{
+			newRelabelMetric("namespace", "shop", "path", "/orders", "code", "200"),
+			newRelabelMetric("namespace", "shop", "path", "/healthz", "code", "200"),
+			newRelabelMetric("namespace", "other", "path", "/orders", "code", "500"),
+		},
+	}
+
+	if !relabelFamily(family, rules) {
+		t.Fatal("expected a metric to be kept")
+	}
+	if len(family.Metric) != 1 {
+		t.Fatalf("expected 1 metric after relabeling, got %d", len(family.Metric))
+	}
+	if got := sampleLabelValue(family.GetName(), family.Metric[0], "code_class"); got != "2xx" {
+		t.Fatalf("expected code_class=2xx, got %q", got)
+	}
+}
+
+func TestRelabelFamilyLabelDropMergesSeries(t *testing.T) {
+	rules, err := CompileRelabelings([]RelabelConfig{
+		{Regex: "request_id|trace_.*", Action: RelabelLabelDrop},
+	})
+	if err != nil {
+		t.Fatalf("CompileRelabelings() error = %v", err)
+	}
+
+	counter := &dto.MetricFamily{
+		Name: proto.String("requests_total"),
+		Type: dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{
+			newRelabelMetric("code", "200", "request_id", "a"),
+			newRelabelMetric("code", "500", "request_id", "b"),
+			newRelabelMetric("request_id", "c", "code", "200", "trace_id", "x"),
+		},
+	}
+	if !relabelFamily(counter, rules) {
+		t.Fatal("expected metrics to be kept")
+	}
+	if len(counter.Metric) != 2 {
+		t.Fatalf("expected 2 merged series, got %v", counter.Metric)
+	}
+	got := map[string]float64{}
+	for _, metric := range counter.Metric {
+		if len(metric.Label) != 1 {
+			t.Fatalf("expected only the code label, got %v", metric.Label)
+		}
+		got[sampleLabelValue(counter.GetName(), metric, "code")] = metric.GetCounter().GetValue()
+	}
+	if got["200"] != 2 || got["500"] != 1 {
+		t.Fatalf("unexpected merged counter values: %v", got)
+	}
+
+	gauge := &dto.MetricFamily{
+		Name: proto.String("queue_depth"),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{
+			{Label: newRelabelMetric("request_id", "a").Label, Gauge: &dto.Gauge{Value: proto.Float64(3)}},
+			{Label: newRelabelMetric("request_id", "b").Label, Gauge: &dto.Gauge{Value: proto.Float64(7)}},
+		},
+	}
+	relabelFamily(gauge, rules)
+	if len(gauge.Metric) != 1 || gauge.Metric[0].GetGauge().GetValue() != 7 {
+		t.Fatalf("expected the last gauge value 7, got %v", gauge.Metric)
+	}
+
+	histogram := &dto.MetricFamily{
+		Name: proto.String("latency_seconds"),
+		Type: dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{
+			{Label: newRelabelMetric("request_id", "a").Label, Histogram: newRelabelHistogram(2, 0.5, 1, 2)},
+			{Label: newRelabelMetric("request_id", "b").Label, Histogram: newRelabelHistogram(3, 1.5, 2, 3)},
+		},
+	}
+	relabelFamily(histogram, rules)
+	h := histogram.Metric[0].GetHistogram()
+	if len(histogram.Metric) != 1 || h.GetSampleCount() != 5 || h.GetSampleSum() != 2 || h.Bucket[0].GetCumulativeCount() != 3 || h.Bucket[1].GetCumulativeCount() != 5 {
+		t.Fatalf("unexpected merged histogram: %v", histogram.Metric)
+	}
+}
+
+func TestSetLabelKeepsLabelsSorted(t *testing.T) {
+	metric := newRelabelMetric("code", "200", "path", "/orders")
+	setLabel(metric, "method", "GET")
+	setLabel(metric, "zone", "a")
+	setLabel(metric, "app", "shop")
+
+	var names []string
+	for _, label := range metric.GetLabel() {
+		names = append(names, label.GetName())
+	}
+	if got, want := strings.Join(names, ","), "app,code,method,path,zone"; got != want {
+		t.Fatalf("labels = %s, want %s", got, want)
+	}
+}
+
+func TestCompileRelabelingsRejectsUnsupported(t *testing.T) {
+	for _, cfg := range []RelabelConfig{
+		{Action: "labelmap"},
+		{Action: RelabelReplace},
+		{Action: RelabelKeep, Regex: "("},
+		{Action: RelabelLabelDrop},
+	} {
+		if _, err := CompileRelabelings([]RelabelConfig{cfg}); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func newRelabelMetric(pairs ...string) *dto.Metric {
+	metric := &dto.Metric{Counter: &dto.Counter{Value: proto.Float64(1)}}
+	for i := 0; i < len(pairs); i += 2 {
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(pairs[i]), Value: proto.String(pairs[i+1])})
+	}
+	return metric
+}
+
+func newRelabelHistogram(count uint64, sum float64, buckets ...uint64) *dto.Histogram {
+	histogram := &dto.Histogram{SampleCount: proto.Uint64(count), SampleSum: proto.Float64(sum)}
+	for i, cumulative := range buckets {
+		histogram.Bucket = append(histogram.Bucket, &dto.Bucket{UpperBound: proto.Float64(float64(i + 1)), CumulativeCount: proto.Uint64(cumulative)})
+	}
+	return histogram
+}
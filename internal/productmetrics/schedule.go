@@ -0,0 +1,178 @@
+package productmetrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ScrapeIntervalAnnotation lets a pod request its own scrape cadence instead of
+// the target's shared cycle.
+const ScrapeIntervalAnnotation = "vsexporter.io/scrape-interval"
+
+// podSchedule tracks a pod scraped on its own cadence.
+type podSchedule struct {
+	uid      string
+	podIP    string
+	interval time.Duration
+	cancel   context.CancelFunc
+	families map[string]*dto.MetricFamily
+}
+
+// podInterval returns the pod's annotated scrape interval, or zero when the pod
+// should follow the shared cycle.
+func (s *Scraper) podInterval(pod *corev1.Pod) time.Duration {
+	raw, ok := pod.Annotations[ScrapeIntervalAnnotation]
+	if !ok {
+		return 0
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval <= 0 {
+		s.logger.Warnf("ignoring invalid %s=%q on pod %s/%s", ScrapeIntervalAnnotation, raw, pod.Namespace, pod.Name)
+		return 0
+	}
+	return interval
+}
+
+// schedulePods starts, restarts, or stops per-pod loops so they match the
+// annotated pods discovered in the current cycle.
+func (s *Scraper) schedulePods(pods map[string]*corev1.Pod) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, schedule := range s.schedules {
+		pod, ok := pods[key]
+		if ok && string(pod.UID) == schedule.uid && pod.Status.PodIP == schedule.podIP && s.podInterval(pod) == schedule.interval {
+			continue
+		}
+		schedule.cancel()
+		delete(s.schedules, key)
+	}
+
+	for key, pod := range pods {
+		if _, ok := s.schedules[key]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(s.runCtx)
+		schedule := &podSchedule{
+			uid:      string(pod.UID),
+			podIP:    pod.Status.PodIP,
+			interval: s.podInterval(pod),
+			cancel:   cancel,
+		}
+		s.schedules[key] = schedule
+		go s.runPod(ctx, key, pod.DeepCopy(), schedule)
+	}
+}
+
+// runPod scrapes a single pod on its annotated interval until ctx is cancelled.
+func (s *Scraper) runPod(ctx context.Context, key string, pod *corev1.Pod, schedule *podSchedule) {
+	ticker := s.clock.NewTicker(schedule.interval)
+	defer ticker.Stop()
+	ctx = s.withNamespaceLogger(ctx, pod.Namespace)
+	logger := s.loggerFrom(ctx)
+	logger.Debugf("pod %s scheduled every %s", key, schedule.interval)
+
+	for {
+		result := newScrapeResult()
+		// The loop's goroutine only takes a slot while it scrapes.
+		if err := s.goroutines.start(ctx); err != nil {
+			return
+		}
+		s.scrapePodPorts(ctx, pod, result)
+		s.goroutines.done()
+		if err := errors.Join(result.errs...); err != nil && ctx.Err() == nil {
+			logger.Warnf("scheduled scrape of pod %s failed: %v", key, err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.schedules[key] == schedule {
+			schedule.families = result.families(result.pods)
+		}
+		s.mu.Unlock()
+		s.publish()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// stopSchedules cancels every per-pod loop.
+func (s *Scraper) stopSchedules() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, schedule := range s.schedules {
+		schedule.cancel()
+		delete(s.schedules, key)
+	}
+}
+
+// publish replaces the target's stored families with the latest shared-cycle
+// results merged with every per-pod schedule's latest results.
+func (s *Scraper) publish() {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+	if s.retired {
+		return
+	}
+
+	retainRaw := s.store.retainsRaw()
+	var raw map[string]map[string]*dto.MetricFamily
+
+	s.mu.Lock()
+	sources := make([]map[string]*dto.MetricFamily, 0, len(s.schedules)+1)
+	sources = append(sources, s.cycleFamilies)
+	if retainRaw {
+		raw = make(map[string]map[string]*dto.MetricFamily, len(s.lastPods)+len(s.schedules))
+		for key, families := range s.lastPods {
+			raw[key] = families
+		}
+	}
+	for key, schedule := range s.schedules {
+		sources = append(sources, schedule.families)
+		if retainRaw && schedule.families != nil {
+			raw[key] = schedule.families
+		}
+	}
+	s.mu.Unlock()
+
+	merged := make(map[string]*dto.MetricFamily)
+	for _, families := range sources {
+		mergeInto(merged, families)
+	}
+
+	if retainRaw {
+		s.store.ReplaceRaw(s.targetName, raw)
+	}
+	s.store.Replace(s.targetName, merged)
+}
+
+// mergeInto appends clones of families into dst, combining families by name.
+// Families that cannot be copied are skipped.
+func mergeInto(dst, families map[string]*dto.MetricFamily) {
+	for name, family := range families {
+		clone := cloneFamily(name, family)
+		if clone == nil {
+			continue
+		}
+		if existing, ok := dst[name]; ok {
+			existing.Metric = append(existing.Metric, clone.Metric...)
+		} else {
+			dst[name] = clone
+		}
+	}
+}
+
+func podKey(pod *corev1.Pod) string {
+	return fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+}
@@ -1,13 +1,25 @@
 package productmetrics
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang/protobuf/proto"
 	dto "github.com/prometheus/client_model/go"
@@ -15,12 +27,29 @@ import (
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+
+	"vs_exporter/internal/clock"
 )
 
+// DefaultScrapeTimeout bounds a pod scrape unless WithRequestTimeout or a
+// client timeout says otherwise. Targets without a timeout use it too.
+const DefaultScrapeTimeout = 10 * time.Second
+
 const (
 	namespaceLabelKey = "namespace"
-	requestTimeout    = 10 * time.Second
+	productLabelKey   = "product"
+	instanceLabelKey  = "instance"
+	// upMetricName is the synthesized per-pod scrape outcome series.
+	upMetricName = "product_up"
+	// podStartMetricName is the synthesized per-pod start time series.
+	podStartMetricName = "pod_start_timestamp_seconds"
+	// bodySniffLen is how much of a response body is checked for being text
+	// before it is parsed.
+	bodySniffLen = 512
+	// bodyPreviewLen is how much of an invalid body is logged, hex-encoded.
+	bodyPreviewLen = 32
 )
 
 // Scraper periodically gathers metrics from product pods and updates the provided store.
@@ -34,7 +63,416 @@ type Scraper struct {
 	metricsPath       string
 	namespaceSelector string
 	podSelector       string
+	podSelectors      []string
 	logger            logrus.FieldLogger
+	clock             clock.Clock
+	urlBuilder        URLBuilder
+	scheme            string
+	portNamePattern   string
+	metrics           *Metrics
+	slowThreshold     time.Duration
+	largeThreshold    int64
+	productLabelFrom  string
+	acceptStatusCodes map[int]bool
+	keepOnPartial     bool
+	startupDelay      time.Duration
+	labelRules        labelRules
+	coerceUntypedTo   dto.MetricType
+	dropFamilies      []string
+	relabelRules      []RelabelRule
+	maxTimestampSkew  time.Duration
+	readyCondition    corev1.PodConditionType
+	stripStaleStamps  bool
+	maxConcurrent     int
+	globalLimiter     *ScrapeLimiter
+	goroutines        *GoroutineLimiter
+	claims            *EndpointClaims
+	maxFamilies       int
+	maxFamilyGrowth   int
+	ownerKind         string
+	ownerName         string
+	requestTimeout    time.Duration
+	copyNsLabels      []string
+	podStartTimes     bool
+	injectInstance    bool
+	reparseRetries    int
+	method            string
+	requestBody       string
+	maxPodsPerNs      int
+	scrapeTerminating bool
+	minReadyPods      int
+	clusterScope      bool
+	ready             atomic.Bool
+	// minPodsMet is set once a shared cycle scraped at least minReadyPods pods.
+	minPodsMet atomic.Bool
+	// lastCycle holds the UnixNano time the last cycle completed, or Run's
+	// start once the startup delay elapsed; zero before that.
+	lastCycle atomic.Int64
+	// lastFamilyCount is the distinct family count of the previous shared
+	// cycle; only ScrapeOnce touches it.
+	lastFamilyCount int
+
+	// mu guards the per-pod schedules and the latest shared-cycle results,
+	// which are merged before every store update.
+	mu            sync.Mutex
+	runCtx        context.Context
+	schedules     map[string]*podSchedule
+	cycleFamilies map[string]*dto.MetricFamily
+	// namespaces holds the namespaces listed by the last cycle, by name, when
+	// namespace labels are copied onto metrics.
+	namespaces map[string]*corev1.Namespace
+	// lastPods holds the per-pod families published by the last shared cycle.
+	lastPods map[string]map[string]*dto.MetricFamily
+	// cappedPods holds, per namespace, the pods maxPodsPerNamespace skipped
+	// in the last shared cycle.
+	cappedPods map[string]int
+	// status describes the last shared cycle; see Status.
+	status ScrapeStatus
+
+	// publishMu serializes publishes, from snapshotting the results to the
+	// store write, so an older merge never overwrites a newer one. It also
+	// orders them with Retire, after which retired suppresses them. It is
+	// taken before mu.
+	publishMu sync.Mutex
+	retired   bool
+}
+
+// URLBuilder returns the URL used to scrape metrics from a pod.
+type URLBuilder func(pod *corev1.Pod, port int, path string) string
+
+// ScraperOption customises optional Scraper behaviour.
+type ScraperOption func(*Scraper)
+
+// WithClock overrides the clock driving the scrape loop. It defaults to the real clock.
+func WithClock(c clock.Clock) ScraperOption {
+	return func(s *Scraper) {
+		if c != nil {
+			s.clock = c
+		}
+	}
+}
+
+// WithURLBuilder overrides how pod scrape URLs are constructed. It defaults to
+// <scheme>://<podIP>:<port><path>; see WithScheme.
+func WithURLBuilder(builder URLBuilder) ScraperOption {
+	return func(s *Scraper) {
+		if builder != nil {
+			s.urlBuilder = builder
+		}
+	}
+}
+
+// WithScheme sets the scheme of the default scrape URLs, e.g. "https". A
+// custom URL builder is left in place. An empty scheme keeps "http".
+func WithScheme(scheme string) ScraperOption {
+	return func(s *Scraper) {
+		if scheme != "" {
+			s.scheme = scheme
+		}
+	}
+}
+
+// WithPortNamePattern makes the scraper target every container port whose name
+// matches the given path.Match pattern (e.g. "*-metrics") instead of only the
+// configured port. Pods without a matching port fall back to the configured port.
+func WithPortNamePattern(pattern string) ScraperOption {
+	return func(s *Scraper) {
+		s.portNamePattern = pattern
+	}
+}
+
+// WithMetrics records scrape instrumentation on m, which is typically shared by
+// every scraper and registered once.
+func WithMetrics(m *Metrics) ScraperOption {
+	return func(s *Scraper) {
+		if m != nil {
+			s.metrics = m
+		}
+	}
+}
+
+// WithSlowPodThresholds logs a warning whenever a single pod scrape takes longer
+// than duration or returns more than size bytes. Zero disables a threshold.
+func WithSlowPodThresholds(duration time.Duration, size int64) ScraperOption {
+	return func(s *Scraper) {
+		s.slowThreshold = duration
+		s.largeThreshold = size
+	}
+}
+
+// WithProductLabelFrom injects a "product" label on each pod's metrics whose
+// value is taken from the named pod label. Pods without that label are left
+// untouched.
+func WithProductLabelFrom(podLabel string) ScraperOption {
+	return func(s *Scraper) {
+		s.productLabelFrom = podLabel
+	}
+}
+
+// WithRequest makes the scraper issue method requests carrying body, for
+// endpoints that only return metrics for e.g. a POSTed query. It defaults to a
+// GET without a body.
+func WithRequest(method, body string) ScraperOption {
+	return func(s *Scraper) {
+		if method != "" {
+			s.method = method
+		}
+		s.requestBody = body
+	}
+}
+
+// WithAcceptStatusCodes sets the HTTP status codes treated as a successful
+// scrape. It defaults to 200 only.
+func WithAcceptStatusCodes(codes []int) ScraperOption {
+	return func(s *Scraper) {
+		if len(codes) == 0 {
+			return
+		}
+		s.acceptStatusCodes = make(map[int]bool, len(codes))
+		for _, code := range codes {
+			s.acceptStatusCodes[code] = true
+		}
+	}
+}
+
+// WithKeepOnPartialFailure makes cycles that hit errors merge their successful
+// pod results over the previously published data instead of replacing it, so a
+// partial outage does not drop series for pods that could not be reached.
+func WithKeepOnPartialFailure(keep bool) ScraperOption {
+	return func(s *Scraper) {
+		s.keepOnPartial = keep
+	}
+}
+
+// WithStartupDelay postpones the first scrape cycle by delay, so that many
+// targets starting together do not hit the API server at once.
+func WithStartupDelay(delay time.Duration) ScraperOption {
+	return func(s *Scraper) {
+		s.startupDelay = delay
+	}
+}
+
+// WithMaxLabelValueLength truncates scraped label values longer than max bytes
+// (UTF-8 safe, with a "..." marker), or drops those labels when drop is true.
+func WithMaxLabelValueLength(max int, drop bool) ScraperOption {
+	return func(s *Scraper) {
+		s.labelRules.maxValueLength = max
+		s.labelRules.dropOversized = drop
+	}
+}
+
+// WithRedactLabels replaces the values of the named scraped labels, e.g. ones
+// a product mistakenly fills with credentials, with "REDACTED", or with a
+// short SHA-256 of the value when hash is true so that series stay distinct.
+// The exporter's own labels are not affected.
+func WithRedactLabels(names []string, hash bool) ScraperOption {
+	return func(s *Scraper) {
+		s.labelRules.redact = nil
+		if len(names) > 0 {
+			s.labelRules.redact = make(map[string]bool, len(names))
+			for _, name := range names {
+				s.labelRules.redact[name] = true
+			}
+		}
+		s.labelRules.hashRedacted = hash
+	}
+}
+
+// WithCoerceUntypedTo rewrites untyped families to the given type, which must be
+// dto.MetricType_GAUGE or dto.MetricType_COUNTER; other values disable coercion.
+func WithCoerceUntypedTo(to dto.MetricType) ScraperOption {
+	return func(s *Scraper) {
+		s.coerceUntypedTo = to
+	}
+}
+
+// WithDropFamilies discards scraped families whose name matches any of the
+// path.Match patterns, e.g. RuntimeMetricPatterns.
+func WithDropFamilies(patterns []string) ScraperOption {
+	return func(s *Scraper) {
+		s.dropFamilies = patterns
+	}
+}
+
+// WithMaxConcurrentScrapes lets up to n pods of a namespace be scraped in
+// parallel. It defaults to 1, i.e. pods are scraped one after another.
+func WithMaxConcurrentScrapes(n int) ScraperOption {
+	return func(s *Scraper) {
+		if n > 0 {
+			s.maxConcurrent = n
+		}
+	}
+}
+
+// WithGlobalLimiter makes every HTTP scrape acquire a token from limiter,
+// which is typically shared by all scrapers.
+func WithGlobalLimiter(limiter *ScrapeLimiter) ScraperOption {
+	return func(s *Scraper) {
+		s.globalLimiter = limiter
+	}
+}
+
+// WithGoroutineLimiter makes the scraper take a slot from limiter for every
+// goroutine that scrapes pods, waiting while none is free. The limiter is
+// typically shared by all scrapers.
+func WithGoroutineLimiter(limiter *GoroutineLimiter) ScraperOption {
+	return func(s *Scraper) {
+		s.goroutines = limiter
+	}
+}
+
+// WithMetricRelabelings applies rules to every scraped metric after the
+// exporter's labels are injected; see CompileRelabelings.
+func WithMetricRelabelings(rules []RelabelRule) ScraperOption {
+	return func(s *Scraper) {
+		s.relabelRules = rules
+	}
+}
+
+// WithMaxTimestampSkew counts scraped samples whose explicit timestamp is more
+// than skew away from now, and removes those timestamps when strip is true.
+func WithMaxTimestampSkew(skew time.Duration, strip bool) ScraperOption {
+	return func(s *Scraper) {
+		s.maxTimestampSkew = skew
+		s.stripStaleStamps = strip
+	}
+}
+
+// WithOnlyReadyPods skips pods whose condition of the given type is not True,
+// including pods that lack it. An empty conditionType means corev1.PodReady.
+func WithOnlyReadyPods(conditionType string) ScraperOption {
+	return func(s *Scraper) {
+		s.readyCondition = corev1.PodReady
+		if conditionType != "" {
+			s.readyCondition = corev1.PodConditionType(conditionType)
+		}
+	}
+}
+
+// WithEndpointClaims skips pod endpoints already claimed by another target
+// sharing claims, de-duplicating scrapes across overlapping selectors.
+func WithEndpointClaims(claims *EndpointClaims) ScraperOption {
+	return func(s *Scraper) {
+		s.claims = claims
+	}
+}
+
+// WithFamilyCountWarning logs a warning when a cycle scrapes more than max
+// distinct family names, or when the count grows by more than growth since the
+// previous cycle. Zero disables either check.
+func WithFamilyCountWarning(max, growth int) ScraperOption {
+	return func(s *Scraper) {
+		s.maxFamilies = max
+		s.maxFamilyGrowth = growth
+	}
+}
+
+// WithOwner scrapes only pods controlled by the workload of the given kind and
+// name, e.g. "Deployment" and "checkout". Pods owned by a ReplicaSet are
+// attributed to its Deployment.
+func WithOwner(kind, name string) ScraperOption {
+	return func(s *Scraper) {
+		s.ownerKind = kind
+		s.ownerName = name
+	}
+}
+
+// WithRequestTimeout bounds each pod scrape, including reading the body. It
+// defaults to 10s; the HTTP client's own timeout still applies.
+func WithRequestTimeout(timeout time.Duration) ScraperOption {
+	return func(s *Scraper) {
+		if timeout > 0 {
+			s.requestTimeout = timeout
+		}
+	}
+}
+
+// WithPodSelectors additionally scrapes the pods matching any of selectors,
+// OR-ing them with the pod selector passed to NewScraper, which is ignored
+// when empty. Each selector costs one List call per namespace.
+func WithPodSelectors(selectors []string) ScraperOption {
+	return func(s *Scraper) {
+		if len(selectors) == 0 {
+			return
+		}
+		s.podSelectors = nil
+		if s.podSelector != "" {
+			s.podSelectors = append(s.podSelectors, s.podSelector)
+		}
+		s.podSelectors = append(s.podSelectors, selectors...)
+	}
+}
+
+// WithReparseRetries fetches a pod's metrics again, up to retries times, when
+// the response cannot be parsed, e.g. because the pod was serving it mid-update.
+// Request failures and rejected status codes are not retried.
+func WithReparseRetries(retries int) ScraperOption {
+	return func(s *Scraper) {
+		s.reparseRetries = retries
+	}
+}
+
+// WithMaxPodsPerNamespace scrapes at most max eligible pods per namespace,
+// taking them in pod name order so that the same pods are chosen every cycle
+// while the set is stable. Zero means no limit.
+func WithMaxPodsPerNamespace(max int) ScraperOption {
+	return func(s *Scraper) {
+		s.maxPodsPerNs = max
+	}
+}
+
+// WithScrapeTerminatingPods keeps scraping pods that are being deleted. By
+// default they are skipped, as they still have an IP during shutdown but
+// mostly refuse connections.
+func WithScrapeTerminatingPods(enabled bool) ScraperOption {
+	return func(s *Scraper) {
+		s.scrapeTerminating = enabled
+	}
+}
+
+// WithMinReadyPods keeps Ready false until a single shared cycle has scraped
+// at least n pods successfully, so that readiness does not report partial
+// data at startup. Pods on their own scrape interval are not counted. Zero, the
+// default, only waits for the first cycle.
+func WithMinReadyPods(n int) ScraperOption {
+	return func(s *Scraper) {
+		s.minReadyPods = n
+	}
+}
+
+// WithClusterScope lists the selected pods of every namespace in a single call
+// instead of listing the namespaces matching the namespace selector first,
+// which is then ignored. Namespace labels cannot be copied in this mode.
+func WithClusterScope(enabled bool) ScraperOption {
+	return func(s *Scraper) {
+		s.clusterScope = enabled
+	}
+}
+
+// WithCopyNamespaceLabels copies the given labels of each pod's namespace onto
+// its metrics, e.g. "team" for chargeback. Keys are sanitized into valid label
+// names, so "cost-center" becomes cost_center; absent labels are skipped.
+func WithCopyNamespaceLabels(keys []string) ScraperOption {
+	return func(s *Scraper) {
+		s.copyNsLabels = keys
+	}
+}
+
+// WithPodStartTimestamps adds a pod_start_timestamp_seconds{namespace,pod,target}
+// series with each scraped pod's status.startTime, to correlate anomalies with
+// restarts.
+func WithPodStartTimestamps(enabled bool) ScraperOption {
+	return func(s *Scraper) {
+		s.podStartTimes = enabled
+	}
+}
+
+// WithInstanceLabel sets instance=<podIP>:<port> on every scraped metric, as
+// Prometheus does, so replicas can be told apart.
+func WithInstanceLabel(enabled bool) ScraperOption {
+	return func(s *Scraper) {
+		s.injectInstance = enabled
+	}
 }
 
 // NewScraper constructs a Scraper responsible for discovering labelled pods and
@@ -50,11 +488,12 @@ func NewScraper(
 	namespaceSelector string,
 	podSelector string,
 	logger logrus.FieldLogger,
+	opts ...ScraperOption,
 ) *Scraper {
 	if logger == nil {
 		logger = logrus.WithField("component", "product-scraper")
 	}
-	return &Scraper{
+	s := &Scraper{
 		targetName:        targetName,
 		clientset:         clientset,
 		httpClient:        httpClient,
@@ -65,12 +504,46 @@ func NewScraper(
 		namespaceSelector: namespaceSelector,
 		podSelector:       podSelector,
 		logger:            logger,
+		clock:             clock.Real(),
+		scheme:            "http",
+		metrics:           NewMetrics(),
+		schedules:         make(map[string]*podSchedule),
+		acceptStatusCodes: map[int]bool{http.StatusOK: true},
+		method:            http.MethodGet,
+		coerceUntypedTo:   dto.MetricType_UNTYPED,
+		maxConcurrent:     1,
+		requestTimeout:    DefaultScrapeTimeout,
+	}
+	s.urlBuilder = s.podURL
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-// Run executes the scrape loop until the context is cancelled.
+// Run executes the scrape loop until the context is cancelled. Pods annotated
+// with ScrapeIntervalAnnotation are scraped on their own cadence while Run is active.
 func (s *Scraper) Run(ctx context.Context) {
-	ticker := time.NewTicker(s.interval)
+	s.mu.Lock()
+	s.runCtx = ctx
+	s.mu.Unlock()
+	defer s.stopSchedules()
+	s.metrics.setInterval(s.targetName, s.interval)
+
+	if s.startupDelay > 0 {
+		s.logger.Infof("delaying first scrape by %s", s.startupDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(s.startupDelay):
+		}
+	}
+
+	// slot is when the current cycle was scheduled to start: the first cycle
+	// runs immediately and later ones at the ticker's tick times.
+	slot := s.clock.Now()
+	s.lastCycle.Store(slot.UnixNano())
+	ticker := s.clock.NewTicker(s.interval)
 	defer ticker.Stop()
 	s.logger.Infof("scraper started: interval=%s port=%d path=%s namespaceSelector=%q podSelector=%q", s.interval, s.port, s.metricsPath, s.namespaceSelector, s.podSelector)
 
@@ -78,123 +551,794 @@ func (s *Scraper) Run(ctx context.Context) {
 		if err := s.ScrapeOnce(ctx); err != nil {
 			s.logger.Errorf("scrape failed: %v", err)
 		}
+		s.ready.Store(true)
+		end := s.clock.Now()
+		s.lastCycle.Store(end.UnixNano())
+		s.metrics.setIntervalOverrun(s.targetName, intervalOverrun(slot, end, s.interval))
 
 		select {
 		case <-ctx.Done():
 			s.logger.Infof("scraper stopping")
 			return
-		case <-ticker.C:
+		case slot = <-ticker.C():
 		}
 	}
 }
 
+// intervalOverrun returns how far a cycle scheduled at slot and finished at end
+// ran past its next scheduled tick, or zero if it finished in time.
+func intervalOverrun(slot, end time.Time, interval time.Duration) time.Duration {
+	if overrun := end.Sub(slot.Add(interval)); overrun > 0 {
+		return overrun
+	}
+	return 0
+}
+
+// Ready reports whether the scraper has completed at least one scrape cycle
+// and, with WithMinReadyPods, once scraped enough pods in one cycle. It does
+// not become false again afterwards.
+func (s *Scraper) Ready() bool {
+	return s.ready.Load() && (s.minReadyPods <= 0 || s.minPodsMet.Load())
+}
+
+// LastCycle returns when Run last completed a cycle, or when it started
+// scraping if no cycle finished yet. It is zero during the startup delay.
+func (s *Scraper) LastCycle() time.Time {
+	nanos := s.lastCycle.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// Retire stops the scraper from writing to the store, for a scraper whose Run
+// was cancelled but cannot be waited for, e.g. because it is stuck. A write in
+// progress finishes before Retire returns; none starts afterwards, so a
+// replacement scraper for the same target owns the store entry.
+func (s *Scraper) Retire() {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+	s.retired = true
+}
+
+// Interval returns the target's shared scrape interval.
+func (s *Scraper) Interval() time.Duration {
+	return s.interval
+}
+
+// Name returns the scrape target name.
+func (s *Scraper) Name() string {
+	return s.targetName
+}
+
 // ScrapeOnce discovers labelled pods and refreshes the stored metrics.
 func (s *Scraper) ScrapeOnce(ctx context.Context) error {
 	s.logger.Debugf("scrape cycle start")
-	nsList, err := s.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: s.namespaceSelector})
-	if err != nil {
-		return fmt.Errorf("list namespaces: %w", err)
+	start := s.clock.Now()
+	var nsList *corev1.NamespaceList
+	var clusterPods map[string][]corev1.Pod
+	var err error
+	if s.clusterScope {
+		nsList, clusterPods, err = s.listClusterPods(ctx)
+		if err != nil {
+			err = fmt.Errorf("list pods: %w", err)
+			s.recordStatus(start, nil, err)
+			return err
+		}
+	} else {
+		nsList, err = s.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: s.namespaceSelector})
+		if err != nil {
+			err = fmt.Errorf("list namespaces: %w", err)
+			s.recordStatus(start, nil, err)
+			return err
+		}
 	}
 
-	newFamilies := make(map[string]*dto.MetricFamily)
-	var errs []error
+	if len(s.copyNsLabels) > 0 {
+		namespaces := make(map[string]*corev1.Namespace, len(nsList.Items))
+		for i := range nsList.Items {
+			namespaces[nsList.Items[i].Name] = &nsList.Items[i]
+		}
+		s.mu.Lock()
+		s.namespaces = namespaces
+		s.mu.Unlock()
+	}
+
+	result := newScrapeResult()
+	scheduled := make(map[string]*corev1.Pod)
+	replicaSets := make(map[string]metav1.OwnerReference)
+	var skippedNoIP int
+
+	s.mu.Lock()
+	perPodScheduling := s.runCtx != nil
+	previousCapped := s.cappedPods
+	s.mu.Unlock()
+	cappedPods := make(map[string]int)
 
 	for _, ns := range nsList.Items {
-		pods, err := s.clientset.CoreV1().Pods(ns.Name).List(ctx, metav1.ListOptions{LabelSelector: s.podSelector})
-		if err != nil {
-			errs = append(errs, fmt.Errorf("list pods in namespace %s: %w", ns.Name, err))
-			continue
+		pods, listed := clusterPods[ns.Name]
+		if !listed {
+			pods, err = s.listPods(ctx, ns.Name)
+			if err != nil {
+				result.addErr(fmt.Errorf("list pods in namespace %s: %w", ns.Name, err))
+				continue
+			}
+		}
+
+		if s.maxPodsPerNs > 0 {
+			sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
 		}
 
-		for i := range pods.Items {
-			pod := &pods.Items[i]
+		nsCtx := s.withNamespaceLogger(ctx, ns.Name)
+		nsStart := s.clock.Now()
+		var selected, capped int
+		var wg sync.WaitGroup
+		workers := make(chan struct{}, s.maxConcurrent)
+		for i := range pods {
+			pod := &pods[i]
 			if pod.Status.PodIP == "" {
+				skippedNoIP++
+				continue
+			}
+			if pod.Namespace != ns.Name {
+				// Labels and namespace lookups follow the pod's own namespace.
+				s.loggerFrom(nsCtx).Warnf("pod %s/%s was listed under namespace %s; labelling it with its own namespace", pod.Namespace, pod.Name, ns.Name)
+			}
+			if pod.DeletionTimestamp != nil && !s.scrapeTerminating {
+				s.loggerFrom(nsCtx).Debugf("skipping pod %s/%s: terminating", pod.Namespace, pod.Name)
+				continue
+			}
+			if s.readyCondition != "" && !podConditionTrue(pod, s.readyCondition) {
+				s.loggerFrom(nsCtx).Debugf("skipping pod %s/%s: condition %s is not True", pod.Namespace, pod.Name, s.readyCondition)
+				continue
+			}
+			if s.ownerKind != "" {
+				kind, name, err := s.workloadOwner(ctx, pod, replicaSets)
+				if err != nil {
+					result.addErr(fmt.Errorf("resolve owner of pod %s/%s: %w", pod.Namespace, pod.Name, err))
+					continue
+				}
+				if kind != s.ownerKind || name != s.ownerName {
+					s.loggerFrom(nsCtx).Debugf("skipping pod %s/%s: owned by %s %q", pod.Namespace, pod.Name, kind, name)
+					continue
+				}
+			}
+			if s.maxPodsPerNs > 0 && selected >= s.maxPodsPerNs {
+				capped++
 				continue
 			}
-			s.logger.Debugf("scraping pod %s/%s via %s:%d%s", ns.Name, pod.Name, pod.Status.PodIP, s.port, s.metricsPath)
-			if err := s.scrapePod(ctx, pod, ns.Name, newFamilies); err != nil {
-				errs = append(errs, fmt.Errorf("scrape pod %s/%s: %w", ns.Name, pod.Name, err))
+			selected++
+			if perPodScheduling && s.podInterval(pod) > 0 {
+				scheduled[podKey(pod)] = pod
+				continue
 			}
+			workers <- struct{}{}
+			if err := s.goroutines.start(ctx); err != nil {
+				<-workers
+				result.addErr(fmt.Errorf("scrape pod %s/%s: %w", pod.Namespace, pod.Name, err))
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					s.goroutines.done()
+					<-workers
+					wg.Done()
+				}()
+				s.scrapePodPorts(nsCtx, pod, result)
+			}()
+		}
+		wg.Wait()
+		if capped > 0 {
+			// Only a change is logged at Info, so a steady cap does not
+			// repeat every cycle.
+			logf := s.loggerFrom(nsCtx).Debugf
+			if capped != previousCapped[ns.Name] {
+				logf = s.loggerFrom(nsCtx).Infof
+			}
+			logf("limiting namespace %s to %d pods, skipping %d more", ns.Name, s.maxPodsPerNs, capped)
+			cappedPods[ns.Name] = capped
 		}
+		s.metrics.observeNamespaceDuration(s.targetName, ns.Name, s.clock.Now().Sub(nsStart).Seconds())
 	}
 
-	s.store.Replace(s.targetName, newFamilies)
+	s.metrics.setSkippedNoIP(s.targetName, skippedNoIP)
+	s.metrics.setResponseBytes(s.targetName, result.responseBytes)
 
-	if len(errs) == 0 {
+	pods := result.pods
+	s.mu.Lock()
+	if s.keepOnPartial && len(result.failed) > 0 {
+		// Only pods listed and attempted this cycle are carried forward, so
+		// that the series of deleted pods still disappear.
+		pods = make(map[string]map[string]*dto.MetricFamily, len(result.pods)+len(result.failed))
+		for key := range result.failed {
+			if families, ok := s.lastPods[key]; ok {
+				pods[key] = families
+			}
+		}
+		for key, families := range result.pods {
+			pods[key] = families
+		}
+		s.logger.Infof("keeping previous data for %d unreachable pods", len(result.failed))
+	}
+	s.lastPods = pods
+	s.cappedPods = cappedPods
+	s.cycleFamilies = result.families(pods)
+	s.mu.Unlock()
+	s.checkFamilyCount(countFamilyNames(pods))
+	if perPodScheduling {
+		s.schedulePods(scheduled)
+	}
+	s.publish()
+
+	if len(result.errs) == 0 {
 		s.logger.Infof("scrape cycle succeeded for target=%s namespaces=%d", s.targetName, len(nsList.Items))
 	} else {
-		s.logger.Warnf("scrape cycle completed with %d errors for target=%s", len(errs), s.targetName)
+		s.logger.Warnf("scrape cycle completed with %d errors for target=%s", len(result.errs), s.targetName)
+	}
+
+	if up := result.podsUp(); s.minReadyPods > 0 && up >= s.minReadyPods && !s.minPodsMet.Load() {
+		s.logger.Infof("scraped %d pods, reaching minReadyPods=%d", up, s.minReadyPods)
+		s.minPodsMet.Store(true)
+	}
+
+	err = errors.Join(result.errs...)
+	s.recordStatus(start, result, err)
+	return err
+}
+
+// listPods lists the pods in namespace matching the pod selector or, with
+// WithPodSelectors, any of the selectors, de-duplicated by UID in the order
+// they were first listed.
+func (s *Scraper) listPods(ctx context.Context, namespace string) ([]corev1.Pod, error) {
+	if len(s.podSelectors) == 0 {
+		pods, err := s.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: s.podSelector})
+		if err != nil {
+			return nil, err
+		}
+		return pods.Items, nil
 	}
 
-	return errors.Join(errs...)
+	var result []corev1.Pod
+	seen := make(map[types.UID]bool)
+	for _, selector := range s.podSelectors {
+		pods, err := s.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, fmt.Errorf("selector %q: %w", selector, err)
+		}
+		for _, pod := range pods.Items {
+			if seen[pod.UID] {
+				continue
+			}
+			seen[pod.UID] = true
+			result = append(result, pod)
+		}
+	}
+	return result, nil
+}
+
+// listClusterPods lists the selected pods of all namespaces and groups them
+// by namespace. The returned list holds a name-only entry per namespace with
+// pods, in name order, standing in for the namespace listing cluster scope
+// skips.
+func (s *Scraper) listClusterPods(ctx context.Context) (*corev1.NamespaceList, map[string][]corev1.Pod, error) {
+	pods, err := s.listPods(ctx, metav1.NamespaceAll)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byNamespace := make(map[string][]corev1.Pod)
+	for _, pod := range pods {
+		byNamespace[pod.Namespace] = append(byNamespace[pod.Namespace], pod)
+	}
+	list := &corev1.NamespaceList{Items: make([]corev1.Namespace, 0, len(byNamespace))}
+	for name := range byNamespace {
+		list.Items = append(list.Items, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+	return list, byNamespace, nil
+}
+
+// scrapeResult accumulates one cycle's pod results. Its methods are safe for
+// concurrent use; the fields may be read directly once all scrapes finished.
+type scrapeResult struct {
+	mu            sync.Mutex
+	pods          map[string]map[string]*dto.MetricFamily
+	responseBytes int64
+	errs          []error
+	// up holds one product_up sample per attempted pod. It is kept apart from
+	// pods so keepOnPartialFailure can reuse a failed pod's previous data
+	// while still reporting the failure.
+	up []*dto.Metric
+	// started holds the pod_start_timestamp_seconds samples, kept apart for
+	// the same reason.
+	started []*dto.Metric
+	// failed holds the keys of the attempted pods whose scrape failed.
+	failed map[string]bool
+}
+
+func newScrapeResult() *scrapeResult {
+	return &scrapeResult{
+		pods:   make(map[string]map[string]*dto.MetricFamily),
+		failed: make(map[string]bool),
+	}
+}
+
+// recordUp adds pod's product_up sample.
+func (r *scrapeResult) recordUp(target string, pod *corev1.Pod, ok bool) {
+	value := 0.0
+	if ok {
+		value = 1
+	}
+	metric := &dto.Metric{
+		Label: []*dto.LabelPair{
+			{Name: proto.String(namespaceLabelKey), Value: proto.String(pod.Namespace)},
+			{Name: proto.String("pod"), Value: proto.String(pod.Name)},
+			{Name: proto.String("target"), Value: proto.String(target)},
+		},
+		Gauge: &dto.Gauge{Value: proto.Float64(value)},
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.up = append(r.up, metric)
+	if !ok {
+		r.failed[podKey(pod)] = true
+	}
+}
+
+// podsUp returns the number of pods whose scrape succeeded.
+func (r *scrapeResult) podsUp() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var up int
+	for _, metric := range r.up {
+		if metric.GetGauge().GetValue() == 1 {
+			up++
+		}
+	}
+	return up
+}
+
+// recordStart adds pod's pod_start_timestamp_seconds sample, if it started.
+func (r *scrapeResult) recordStart(target string, pod *corev1.Pod) {
+	if pod.Status.StartTime == nil {
+		return
+	}
+	metric := &dto.Metric{
+		Label: []*dto.LabelPair{
+			{Name: proto.String(namespaceLabelKey), Value: proto.String(pod.Namespace)},
+			{Name: proto.String("pod"), Value: proto.String(pod.Name)},
+			{Name: proto.String("target"), Value: proto.String(target)},
+		},
+		Gauge: &dto.Gauge{Value: proto.Float64(float64(pod.Status.StartTime.Unix()))},
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = append(r.started, metric)
+}
+
+// families merges pods and appends the synthesized product_up and
+// pod_start_timestamp_seconds families.
+func (r *scrapeResult) families(pods map[string]map[string]*dto.MetricFamily) map[string]*dto.MetricFamily {
+	merged := mergePods(pods)
+	if len(r.up) > 0 {
+		mergeInto(merged, map[string]*dto.MetricFamily{upMetricName: {
+			Name:   proto.String(upMetricName),
+			Help:   proto.String("Whether the last scrape of the pod succeeded (1) or failed (0)."),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: r.up,
+		}})
+	}
+	if len(r.started) > 0 {
+		mergeInto(merged, map[string]*dto.MetricFamily{podStartMetricName: {
+			Name:   proto.String(podStartMetricName),
+			Help:   proto.String("Unix time at which the pod was acknowledged by the kubelet."),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: r.started,
+		}})
+	}
+	return merged
+}
+
+// add merges families scraped from the pod identified by key.
+func (r *scrapeResult) add(key string, families map[string]*dto.MetricFamily) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	existing, ok := r.pods[key]
+	if !ok {
+		r.pods[key] = families
+		return
+	}
+	for name, family := range families {
+		if current, ok := existing[name]; ok {
+			current.Metric = append(current.Metric, family.Metric...)
+		} else {
+			existing[name] = family
+		}
+	}
+}
+
+func (r *scrapeResult) addErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *scrapeResult) addBytes(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responseBytes += n
+}
+
+// mergePods combines per-pod families into a single family set.
+func mergePods(pods map[string]map[string]*dto.MetricFamily) map[string]*dto.MetricFamily {
+	merged := make(map[string]*dto.MetricFamily)
+	for _, families := range pods {
+		mergeInto(merged, families)
+	}
+	return merged
+}
+
+// scrapePodPorts scrapes every port selected for pod into result, recording
+// product_up as 1 only when every port succeeded.
+func (s *Scraper) scrapePodPorts(ctx context.Context, pod *corev1.Pod, result *scrapeResult) {
+	logger := s.loggerFrom(ctx)
+	ports := s.podPorts(pod)
+	if len(ports) == 0 {
+		logger.Debugf("skipping pod %s/%s: no port matches %q", pod.Namespace, pod.Name, s.portNamePattern)
+		return
+	}
+
+	up, attempted := true, false
+	defer func() {
+		if attempted {
+			result.recordUp(s.targetName, pod, up)
+			if s.podStartTimes {
+				result.recordStart(s.targetName, pod)
+			}
+		}
+	}()
+
+	for _, port := range ports {
+		endpoint := fmt.Sprintf("%s/%s/%d%s", pod.Namespace, pod.Name, port, s.metricsPath)
+		if !s.claims.claim(endpoint, s.targetName, s.clock.Now(), 2*s.interval) {
+			logger.Debugf("skipping pod %s/%s port %d: scraped by another target", pod.Namespace, pod.Name, port)
+			continue
+		}
+		attempted = true
+		logger.Debugf("scraping pod %s/%s via %s:%d%s", pod.Namespace, pod.Name, pod.Status.PodIP, port, s.metricsPath)
+		if err := s.globalLimiter.acquire(ctx); err != nil {
+			result.addErr(fmt.Errorf("scrape pod %s/%s port %d: %w", pod.Namespace, pod.Name, port, err))
+			up = false
+			return
+		}
+		err := s.scrapePod(ctx, pod, port, result)
+		s.globalLimiter.release()
+		if err != nil {
+			result.addErr(fmt.Errorf("scrape pod %s/%s port %d: %w", pod.Namespace, pod.Name, port, err))
+			up = false
+		}
+	}
 }
 
 func (s *Scraper) scrapePod(
 	ctx context.Context,
 	pod *corev1.Pod,
-	namespace string,
-	accumulator map[string]*dto.MetricFamily,
+	port int,
+	result *scrapeResult,
 ) error {
-	url := fmt.Sprintf("http://%s:%d%s", pod.Status.PodIP, s.port, s.metricsPath)
+	url := s.urlBuilder(pod, port, s.metricsPath)
+	logger := s.loggerFrom(ctx)
+
+	start := s.clock.Now()
+	// size is the response size of the last attempt, so that reparse retries
+	// do not add up to a large response.
+	var size int64
+	defer func() {
+		elapsed := s.clock.Now().Sub(start)
+		s.metrics.observePodDuration(s.targetName, elapsed.Seconds())
+		if s.slowThreshold > 0 && elapsed > s.slowThreshold {
+			logger.Warnf("slow scrape of pod %s/%s: took %s (threshold %s)", pod.Namespace, pod.Name, elapsed, s.slowThreshold)
+		}
+		if s.largeThreshold > 0 && size > s.largeThreshold {
+			logger.Warnf("large scrape response from pod %s/%s: %d bytes (threshold %d)", pod.Namespace, pod.Name, size, s.largeThreshold)
+		}
+	}()
+
+	var parsed map[string]*dto.MetricFamily
+	for attempt := 0; ; attempt++ {
+		var n int64
+		var err error
+		parsed, n, err = s.fetchFamilies(ctx, url)
+		size = n
+		result.addBytes(n)
+		if err == nil {
+			break
+		}
+		var invalid *invalidBodyError
+		if errors.As(err, &invalid) {
+			s.metrics.addInvalidBody(s.targetName)
+			logger.Warnf("pod %s/%s served a body that is not a text exposition (%s); it starts with %s", pod.Namespace, pod.Name, invalid.reason, hex.EncodeToString(invalid.preview))
+			return err
+		}
+		// Only a malformed body, e.g. one served mid-update, is fetched again;
+		// request and status failures are returned as they are.
+		var parseErr expfmt.ParseError
+		if !errors.As(err, &parseErr) || attempt >= s.reparseRetries {
+			return err
+		}
+		logger.Debugf("re-fetching pod %s/%s after a parse error (attempt %d of %d): %v", pod.Namespace, pod.Name, attempt+1, s.reparseRetries, err)
+	}
+
+	if s.maxTimestampSkew > 0 {
+		now := s.clock.Now()
+		var stale int
+		for _, family := range parsed {
+			stale += staleTimestamps(family, now, s.maxTimestampSkew, s.stripStaleStamps)
+		}
+		if stale > 0 {
+			s.metrics.addStaleTimestamps(s.targetName, stale)
+			logger.Warnf("pod %s/%s exported %d samples with timestamps more than %s from now", pod.Namespace, pod.Name, stale, s.maxTimestampSkew)
+		}
+	}
+
+	s.mu.Lock()
+	namespace := s.namespaces[pod.Namespace]
+	s.mu.Unlock()
+	injected := s.injectedLabels(pod, namespace)
+	if s.injectInstance {
+		injected = append(injected, labelPair{name: instanceLabelKey, value: net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port))})
+	}
+	labelled := make(map[string]*dto.MetricFamily, len(parsed))
+	for name, family := range parsed {
+		if matchesAny(s.dropFamilies, name) {
+			continue
+		}
+		coerceUntyped(family, s.coerceUntypedTo)
+		clone := cloneAndLabelFamily(family, injected, s.labelRules)
+		if clone == nil || !relabelFamily(clone, s.relabelRules) {
+			continue
+		}
+		labelled[name] = clone
+	}
+	result.add(podKey(pod), labelled)
 
-	reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	return nil
+}
+
+// fetchFamilies requests url and parses the response, returning the families
+// and the number of body bytes read. An accepted 204 yields no families.
+func (s *Scraper) fetchFamilies(ctx context.Context, url string) (map[string]*dto.MetricFamily, int64, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, s.requestTimeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, url, nil)
+	var reqBody io.Reader
+	if s.requestBody != "" {
+		reqBody = strings.NewReader(s.requestBody)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, s.method, url, reqBody)
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		return nil, 0, fmt.Errorf("create request: %w", err)
 	}
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("execute request: %w", err)
+		return nil, 0, fmt.Errorf("execute request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if !s.acceptStatusCodes[resp.StatusCode] {
 		io.Copy(io.Discard, resp.Body)
-		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		return nil, 0, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	// An accepted 204 is an idle product with nothing to report. It has no
+	// body to parse, even when the pod sets a Content-Encoding.
+	if resp.StatusCode == http.StatusNoContent {
+		return map[string]*dto.MetricFamily{}, 0, nil
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("read response: %w", err)
+	// The transport only decompresses transparently when it negotiated gzip
+	// itself, so bodies a pod compresses unasked are unwrapped here.
+	var reader io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, 0, fmt.Errorf("decompress response: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	// Binary bodies, e.g. from a misrouted port, are rejected with a clear
+	// error instead of the parser's. Only the start of the body is checked.
+	sniffer := bufio.NewReaderSize(reader, bodySniffLen)
+	prefix, err := sniffer.Peek(bodySniffLen)
+	if err != nil && err != io.EOF {
+		// A short prefix from a failed read is not the whole body.
+		return nil, int64(len(prefix)), fmt.Errorf("read response: %w", err)
+	}
+	if err := checkTextBody(prefix, len(prefix) == bodySniffLen); err != nil {
+		return nil, int64(len(prefix)), err
+	}
+	// Some products serve valid text as application/octet-stream, so an
+	// unexpected Content-Type is only noted; the body decides.
+	if contentType := resp.Header.Get("Content-Type"); !isTextContentType(contentType) {
+		s.loggerFrom(ctx).Debugf("parsing response from %s with content type %q as text", url, contentType)
 	}
 
+	// The body is parsed as it streams in rather than buffered first, so
+	// only the parsed families are held in memory; the counter feeds the
+	// response size metric and warning. Time spent waiting for the rest of
+	// the body is subtracted from the parse duration.
+	body := &countingReader{r: sniffer, clock: s.clock}
 	parser := expfmt.TextParser{}
-	parsed, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+	parseStart := s.clock.Now()
+	parsed, err := parser.TextToMetricFamilies(body)
+	s.metrics.observeParseDuration(s.targetName, (s.clock.Now().Sub(parseStart) - body.reading).Seconds())
 	if err != nil {
-		return fmt.Errorf("parse metrics: %w", err)
+		return nil, body.n, fmt.Errorf("parse metrics: %w", err)
 	}
+	return parsed, body.n, nil
+}
 
-	for name, family := range parsed {
-		withLabel := cloneAndLabelFamily(family, namespace)
-		if existing, ok := accumulator[name]; ok {
-			existing.Metric = append(existing.Metric, withLabel.Metric...)
-		} else {
-			accumulator[name] = withLabel
-		}
+// countingReader counts the bytes read through it and the time spent
+// reading them.
+type countingReader struct {
+	r       io.Reader
+	clock   clock.Clock
+	n       int64
+	reading time.Duration
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	start := c.clock.Now()
+	n, err := c.r.Read(p)
+	c.reading += c.clock.Now().Sub(start)
+	c.n += int64(n)
+	return n, err
+}
+
+// invalidBodyError reports a response body that is not a text exposition.
+type invalidBodyError struct {
+	reason string
+	// preview holds the first bytes of the body.
+	preview []byte
+}
+
+func (e *invalidBodyError) Error() string {
+	return "invalid response body: " + e.reason
+}
+
+// isTextContentType reports whether contentType is empty or names a text
+// exposition format.
+func isTextContentType(contentType string) bool {
+	if contentType == "" {
+		return true
 	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (strings.HasPrefix(mediaType, "text/") || mediaType == "application/openmetrics-text")
+}
 
+// checkTextBody rejects a response body that starts with NUL bytes or invalid
+// UTF-8. prefix is the start of the body; truncated reports whether the body
+// continues past it, in which case a rune cut off at its end is allowed.
+func checkTextBody(prefix []byte, truncated bool) error {
+	preview := bytes.Clone(prefix[:min(len(prefix), bodyPreviewLen)])
+	if bytes.IndexByte(prefix, 0) >= 0 {
+		return &invalidBodyError{reason: "contains NUL bytes", preview: preview}
+	}
+	for rest := prefix; len(rest) > 0; {
+		if truncated && !utf8.FullRune(rest) {
+			break
+		}
+		r, size := utf8.DecodeRune(rest)
+		if r == utf8.RuneError && size == 1 {
+			return &invalidBodyError{reason: "not valid UTF-8", preview: preview}
+		}
+		rest = rest[size:]
+	}
 	return nil
 }
 
-func cloneAndLabelFamily(family *dto.MetricFamily, namespace string) *dto.MetricFamily {
-	clone := proto.Clone(family).(*dto.MetricFamily)
+// podConditionTrue reports whether pod has a condition of conditionType with
+// status True. A missing condition counts as not true.
+func podConditionTrue(pod *corev1.Pod, conditionType corev1.PodConditionType) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podPorts returns the ports to scrape on pod. Without a port name pattern it is
+// just the configured port; with one, every matching declared container port
+// (including init/sidecar containers) is returned, de-duplicated.
+func (s *Scraper) podPorts(pod *corev1.Pod) []int {
+	if s.portNamePattern == "" {
+		return []int{s.port}
+	}
+
+	var ports []int
+	seen := make(map[int32]bool)
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, cp := range container.Ports {
+			if cp.Name == "" || seen[cp.ContainerPort] {
+				continue
+			}
+			if ok, _ := path.Match(s.portNamePattern, cp.Name); ok {
+				seen[cp.ContainerPort] = true
+				ports = append(ports, int(cp.ContainerPort))
+			}
+		}
+	}
+
+	if len(ports) == 0 && s.port > 0 {
+		return []int{s.port}
+	}
+	return ports
+}
+
+// podURL is the default URLBuilder.
+func (s *Scraper) podURL(pod *corev1.Pod, port int, path string) string {
+	return fmt.Sprintf("%s://%s:%d%s", s.scheme, pod.Status.PodIP, port, path)
+}
+
+// labelPair is a label injected onto every scraped metric.
+type labelPair struct {
+	name  string
+	value string
+}
+
+// injectedLabels returns the labels added to every metric scraped from pod. The
+// namespace label always reflects the pod's own namespace rather than the
+// namespace that was listed, so it stays correct for cross-namespace listings.
+// namespace is the pod's Namespace object, or nil when it is not known.
+func (s *Scraper) injectedLabels(pod *corev1.Pod, namespace *corev1.Namespace) []labelPair {
+	labels := []labelPair{{name: namespaceLabelKey, value: pod.Namespace}}
+	if s.productLabelFrom != "" {
+		if value, ok := pod.Labels[s.productLabelFrom]; ok {
+			labels = append(labels, labelPair{name: productLabelKey, value: value})
+		}
+	}
+	if namespace != nil {
+		for _, key := range s.copyNsLabels {
+			if value, ok := namespace.Labels[key]; ok {
+				labels = append(labels, labelPair{name: sanitizeLabelName(key), value: value})
+			}
+		}
+	}
+	return labels
+}
+
+// cloneAndLabelFamily copies family, applies rules to the scraped labels, and
+// sets each injected label on every metric, overwriting any value the pod
+// exported for the same name. It returns nil when family cannot be copied.
+func cloneAndLabelFamily(family *dto.MetricFamily, injected []labelPair, rules labelRules) *dto.MetricFamily {
+	clone := cloneFamily(family.GetName(), family)
+	if clone == nil {
+		return nil
+	}
 	for _, metric := range clone.Metric {
-		var hasNamespace bool
-		for _, label := range metric.Label {
-			if label.GetName() == namespaceLabelKey {
-				label.Value = proto.String(namespace)
-				hasNamespace = true
-				break
-			}
-		}
-		if !hasNamespace {
-			metric.Label = append(metric.Label, &dto.LabelPair{
-				Name:  proto.String(namespaceLabelKey),
-				Value: proto.String(namespace),
-			})
+		rules.apply(metric)
+		for _, inject := range injected {
+			var found bool
+			for _, label := range metric.Label {
+				if label.GetName() == inject.name {
+					label.Value = proto.String(inject.value)
+					found = true
+					break
+				}
+			}
+			if !found {
+				metric.Label = append(metric.Label, &dto.LabelPair{
+					Name:  proto.String(inject.name),
+					Value: proto.String(inject.value),
+				})
+			}
 		}
 	}
 	return clone
@@ -0,0 +1,1334 @@
+package productmetrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"vs_exporter/internal/clock"
+)
+
+const sampleExposition = `# HELP sample_requests_total Total requests.
+# TYPE sample_requests_total counter
+sample_requests_total{code="200"} 42
+`
+
+func TestScrapeOnceLabelsMetricsWithNamespace(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newNamespace("ns-other", map[string]string{"product": "other"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+		newPod("ns-other", "pod-2", "10.0.0.2", map[string]string{"app": "alpha"}),
+	)
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server)
+
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+
+	families := writeAndParse(t, store)
+	family, ok := families["sample_requests_total"]
+	if !ok {
+		t.Fatalf("expected sample_requests_total to be stored")
+	}
+	if len(family.GetMetric()) != 1 {
+		t.Fatalf("expected 1 metric, got %d", len(family.GetMetric()))
+	}
+	if ns := labelValue(family.GetMetric()[0], namespaceLabelKey); ns != "ns-a" {
+		t.Fatalf("expected namespace label ns-a, got %q", ns)
+	}
+	if got := testutil.ToFloat64(scraper.metrics.responseBytes.WithLabelValues("alpha")); got != float64(len(sampleExposition)) {
+		t.Fatalf("expected %d response bytes, got %v", len(sampleExposition), got)
+	}
+	if count := testutil.CollectAndCount(scraper.metrics.parseDuration); count != 1 {
+		t.Fatalf("expected a parse duration series for the target, got %d", count)
+	}
+}
+
+func TestScrapeOnceExcludesBodyTransferFromParseDuration(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	// The first chunk fills the sniffed prefix, so the parser reads the rest.
+	head := "# HELP padding " + strings.Repeat("x", bodySniffLen) + "\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, head)
+		w.(http.Flusher).Flush()
+		// Let the scraper block on the rest of the body, which then takes
+		// five seconds on its clock to arrive.
+		time.Sleep(50 * time.Millisecond)
+		fakeClock.Advance(5 * time.Second)
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+	scraper := newTestScraper(clientset, NewStore(), server, WithClock(fakeClock))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+
+	var metric dto.Metric
+	if err := scraper.metrics.parseDuration.WithLabelValues("alpha").(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if got := metric.GetHistogram().GetSampleSum(); got != 0 {
+		t.Fatalf("parse duration = %vs, want the transfer time excluded", got)
+	}
+}
+
+func TestScrapeOnceLabelsPodListedUnderAnotherNamespace(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(newNamespace("ns-a", map[string]string{"product": "alpha"}))
+	clientset.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.PodList{Items: []corev1.Pod{*newPod("ns-b", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"})}}, nil
+	})
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server)
+	logger, hook := logtest.NewNullLogger()
+	scraper.logger = logger
+
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	metrics := writeAndParse(t, store)["sample_requests_total"].GetMetric()
+	if len(metrics) != 1 || labelValue(metrics[0], namespaceLabelKey) != "ns-b" {
+		t.Fatalf("expected the pod's own namespace ns-b to be injected, got %v", metrics)
+	}
+	var warned bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, "listed under namespace ns-a") {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Fatalf("expected a warning about the namespace mismatch")
+	}
+}
+
+func TestScrapeOnceInjectsProductLabelFromPod(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha", "team-product": "checkout"}),
+	)
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithProductLabelFrom("team-product"))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+
+	metric := writeAndParse(t, store)["sample_requests_total"].GetMetric()[0]
+	if got := labelValue(metric, productLabelKey); got != "checkout" {
+		t.Fatalf("expected product label checkout, got %q", got)
+	}
+}
+
+func TestScrapeOnceCopiesNamespaceLabels(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha", "team": "payments", "cost-center": "cc-42"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithCopyNamespaceLabels([]string{"team", "cost-center", "missing"}))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+
+	metric := writeAndParse(t, store)["sample_requests_total"].GetMetric()[0]
+	if got := labelValue(metric, "team"); got != "payments" {
+		t.Fatalf("expected team label payments, got %q", got)
+	}
+	if got := labelValue(metric, "cost_center"); got != "cc-42" {
+		t.Fatalf("expected cost_center label cc-42, got %q", got)
+	}
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == "missing" {
+			t.Fatalf("expected no label for a namespace label that is not set")
+		}
+	}
+}
+
+func TestScrapeOnceRecordsPodStartTimestamps(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	started := newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"})
+	startTime := metav1.NewTime(time.Unix(1700000000, 0))
+	started.Status.StartTime = &startTime
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		started,
+		newPod("ns-a", "pod-2", "10.0.0.2", map[string]string{"app": "alpha"}),
+	)
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithPodStartTimestamps(true))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+
+	metrics := writeAndParse(t, store)[podStartMetricName].GetMetric()
+	if len(metrics) != 1 || labelValue(metrics[0], "pod") != "pod-1" {
+		t.Fatalf("expected a start timestamp only for the started pod, got %v", metrics)
+	}
+	if got := labelValue(metrics[0], "target"); got != scraper.targetName {
+		t.Fatalf("target label = %q, want %q", got, scraper.targetName)
+	}
+	if got := metrics[0].GetGauge().GetValue(); got != 1700000000 {
+		t.Fatalf("pod_start_timestamp_seconds = %v, want 1700000000", got)
+	}
+}
+
+func TestScrapeOnceInjectsInstanceLabel(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithInstanceLabel(true))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+
+	metric := writeAndParse(t, store)["sample_requests_total"].GetMetric()[0]
+	want := fmt.Sprintf("10.0.0.1:%d", scraper.port)
+	if got := labelValue(metric, instanceLabelKey); got != want {
+		t.Fatalf("instance = %q, want %q", got, want)
+	}
+}
+
+func TestScrapeOnceLabelsWithPodNamespace(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+	)
+	// Simulate a cross-namespace listing: the list for ns-a returns a pod that
+	// actually lives in ns-b.
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.PodList{Items: []corev1.Pod{
+			*newPod("ns-b", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+		}}, nil
+	})
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server)
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+
+	metric := writeAndParse(t, store)["sample_requests_total"].GetMetric()[0]
+	if got := labelValue(metric, namespaceLabelKey); got != "ns-b" {
+		t.Fatalf("expected namespace label from the pod (ns-b), got %q", got)
+	}
+}
+
+func TestScrapeOnceSkipsPodsWithoutIP(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pending", "", map[string]string{"app": "alpha"}),
+	)
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server)
+
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	if families := writeAndParse(t, store); len(families) != 0 {
+		t.Fatalf("expected no families, got %d", len(families))
+	}
+	if got := testutil.ToFloat64(scraper.metrics.skippedNoIP.WithLabelValues("alpha")); got != 1 {
+		t.Fatalf("expected 1 pod skipped for missing IP, got %v", got)
+	}
+}
+
+func TestScrapeOnceReportsPodErrors(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+
+	scraper := newTestScraper(clientset, NewStore(), server)
+
+	err := scraper.ScrapeOnce(context.Background())
+	if err == nil {
+		t.Fatalf("expected error for failing pod, got nil")
+	}
+	if !strings.Contains(err.Error(), "ns-a/pod-1") {
+		t.Fatalf("expected error to mention pod, got %v", err)
+	}
+}
+
+func TestScrapeOnceSkipsPodsWithoutReadyCondition(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	ready := newPod("ns-a", "ready", "10.0.0.1", map[string]string{"app": "alpha"})
+	ready.Status.Conditions = []corev1.PodCondition{{Type: "MetricsReady", Status: corev1.ConditionTrue}}
+	notReady := newPod("ns-a", "not-ready", "10.0.0.2", map[string]string{"app": "alpha"})
+	notReady.Status.Conditions = []corev1.PodCondition{{Type: "MetricsReady", Status: corev1.ConditionFalse}}
+	missing := newPod("ns-a", "missing", "10.0.0.3", map[string]string{"app": "alpha"})
+	missing.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		ready, notReady, missing,
+	)
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithOnlyReadyPods("MetricsReady"))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+
+	metrics := writeAndParse(t, store)[upMetricName].GetMetric()
+	if len(metrics) != 1 || labelValue(metrics[0], "pod") != "ready" {
+		t.Fatalf("expected only the ready pod to be scraped, got %v", metrics)
+	}
+}
+
+func TestScrapeOnceFiltersPodsByOwner(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	controller := true
+	owned := newPod("ns-a", "checkout-abc", "10.0.0.1", map[string]string{"app": "alpha"})
+	owned.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "checkout-7d9", Controller: &controller}}
+	other := newPod("ns-a", "cart-abc", "10.0.0.2", map[string]string{"app": "alpha"})
+	other.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "cart-5f2", Controller: &controller}}
+	stateful := newPod("ns-a", "checkout-0", "10.0.0.3", map[string]string{"app": "alpha"})
+	stateful.OwnerReferences = []metav1.OwnerReference{{Kind: "StatefulSet", Name: "checkout", Controller: &controller}}
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		owned, other, stateful,
+		newReplicaSet("ns-a", "checkout-7d9", "checkout"),
+		newReplicaSet("ns-a", "cart-5f2", "cart"),
+	)
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithOwner("Deployment", "checkout"))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+
+	metrics := writeAndParse(t, store)[upMetricName].GetMetric()
+	if len(metrics) != 1 || labelValue(metrics[0], "pod") != "checkout-abc" {
+		t.Fatalf("expected only the checkout Deployment's pod to be scraped, got %v", metrics)
+	}
+}
+
+func TestScrapeOnceDecompressesGzipBodies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		fmt.Fprint(gz, sampleExposition)
+		gz.Close()
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+
+	store := NewStore()
+	// Disabling compression keeps the transport from adding Accept-Encoding
+	// and decompressing on its own, as with pods that gzip unasked.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	scraper := newTestScraper(clientset, store, server)
+	scraper.httpClient = client
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	if _, ok := writeAndParse(t, store)["sample_requests_total"]; !ok {
+		t.Fatal("expected the gzip-compressed metrics to be parsed")
+	}
+}
+
+func TestRunScrapesOnEachTick(t *testing.T) {
+	hits := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sampleExposition)
+		hits <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	scraper := newTestScraper(clientset, NewStore(), server, WithClock(fakeClock))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scraper.Run(ctx)
+		close(done)
+	}()
+
+	waitForHit(t, hits)
+	if got := testutil.ToFloat64(scraper.metrics.interval.WithLabelValues("alpha")); got != 60 {
+		t.Fatalf("expected product_scrape_interval_seconds 60, got %v", got)
+	}
+	fakeClock.BlockUntil(1)
+	fakeClock.Advance(time.Minute)
+	waitForHit(t, hits)
+
+	cancel()
+	<-done
+}
+
+func TestIntervalOverrun(t *testing.T) {
+	slot := time.Unix(600, 0)
+	for _, tt := range []struct {
+		took time.Duration
+		want time.Duration
+	}{
+		{took: 10 * time.Second, want: 0},
+		{took: time.Minute, want: 0},
+		{took: 90 * time.Second, want: 30 * time.Second},
+	} {
+		if got := intervalOverrun(slot, slot.Add(tt.took), time.Minute); got != tt.want {
+			t.Errorf("intervalOverrun after %s = %s, want %s", tt.took, got, tt.want)
+		}
+	}
+}
+
+func TestRunHonoursPodScrapeIntervalAnnotation(t *testing.T) {
+	hits := make(chan string, 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sampleExposition)
+		hits <- r.URL.Query().Get("pod")
+	}))
+	t.Cleanup(server.Close)
+
+	fastPod := newPod("ns-a", "fast", "10.0.0.2", map[string]string{"app": "alpha"})
+	fastPod.Annotations = map[string]string{ScrapeIntervalAnnotation: "10s"}
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "shared", "10.0.0.1", map[string]string{"app": "alpha"}),
+		fastPod,
+	)
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithClock(fakeClock))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scraper.Run(ctx)
+		close(done)
+	}()
+
+	first := map[string]bool{waitForPod(t, hits): true, waitForPod(t, hits): true}
+	if !first["shared"] || !first["fast"] {
+		t.Fatalf("expected both pods scraped initially, got %v", first)
+	}
+
+	// One ticker for the shared cycle plus one for the annotated pod.
+	fakeClock.BlockUntil(2)
+	fakeClock.Advance(10 * time.Second)
+	if got := waitForPod(t, hits); got != "fast" {
+		t.Fatalf("expected only the annotated pod on its own tick, got %q", got)
+	}
+
+	cancel()
+	<-done
+
+	if got := len(writeAndParse(t, store)["sample_requests_total"].GetMetric()); got != 2 {
+		t.Fatalf("expected merged results for both pods, got %d metrics", got)
+	}
+}
+
+func TestRunWaitsForStartupDelay(t *testing.T) {
+	hits := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sampleExposition)
+		hits <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	scraper := newTestScraper(clientset, NewStore(), server, WithClock(fakeClock), WithStartupDelay(30*time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go scraper.Run(ctx)
+
+	fakeClock.BlockUntil(1)
+	select {
+	case <-hits:
+		t.Fatalf("scraped before the startup delay elapsed")
+	default:
+	}
+
+	fakeClock.Advance(30 * time.Second)
+	waitForHit(t, hits)
+}
+
+func TestLastCycleFollowsInjectedClock(t *testing.T) {
+	hits := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sampleExposition)
+		hits <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+	fakeClock := clock.NewFake(time.Unix(1000, 0))
+	scraper := newTestScraper(clientset, NewStore(), server, WithClock(fakeClock), WithStartupDelay(30*time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go scraper.Run(ctx)
+
+	fakeClock.BlockUntil(1)
+	if last := scraper.LastCycle(); !last.IsZero() {
+		t.Fatalf("expected no cycle during the startup delay, got %s", last)
+	}
+
+	fakeClock.Advance(30 * time.Second)
+	waitForHit(t, hits)
+	waitForLastCycle(t, scraper, time.Unix(1030, 0))
+
+	fakeClock.BlockUntil(1)
+	fakeClock.Advance(time.Minute)
+	waitForHit(t, hits)
+	waitForLastCycle(t, scraper, time.Unix(1090, 0))
+}
+
+func TestRetireStopsStoreWrites(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server)
+
+	scraper.Retire()
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	if families := writeAndParse(t, store); len(families) != 0 {
+		t.Fatalf("expected a retired scraper not to write to the store, got %d families", len(families))
+	}
+}
+
+func waitForLastCycle(t *testing.T, scraper *Scraper, want time.Time) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !scraper.LastCycle().Equal(want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected LastCycle %s, got %s", want, scraper.LastCycle())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitForPod(t *testing.T, hits <-chan string) string {
+	t.Helper()
+	select {
+	case pod := <-hits:
+		return pod
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for scrape")
+		return ""
+	}
+}
+
+func waitForHit(t *testing.T, hits <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-hits:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for scrape")
+	}
+}
+
+func TestWithSchemeKeepsCustomURLBuilder(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithScheme("https"))
+
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	if _, ok := writeAndParse(t, store)["sample_requests_total"]; !ok {
+		t.Fatalf("expected the custom URL builder to be used")
+	}
+}
+
+func TestWithSchemeSetsDefaultURLScheme(t *testing.T) {
+	pod := newPod("ns-a", "pod-1", "10.0.0.1", nil)
+	for scheme, want := range map[string]string{
+		"":      "http://10.0.0.1:8080/metrics",
+		"https": "https://10.0.0.1:8080/metrics",
+	} {
+		scraper := NewScraper("alpha", fake.NewSimpleClientset(), http.DefaultClient, NewStore(), time.Minute, 8080, "/metrics", "", "", nil, WithScheme(scheme))
+		if got := scraper.urlBuilder(pod, 8080, "/metrics"); got != want {
+			t.Errorf("scheme %q: URL = %q, want %q", scheme, got, want)
+		}
+	}
+}
+
+func TestPodPortsMatchesNamedContainerPorts(t *testing.T) {
+	pod := newPod("ns-a", "pod-1", "10.0.0.1", nil)
+	pod.Spec.InitContainers = []corev1.Container{
+		{Name: "sidecar", Ports: []corev1.ContainerPort{{Name: "envoy-metrics", ContainerPort: 15090}}},
+	}
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "app", Ports: []corev1.ContainerPort{
+			{Name: "http", ContainerPort: 8080},
+			{Name: "app-metrics", ContainerPort: 9090},
+		}},
+	}
+
+	scraper := &Scraper{port: 8080, portNamePattern: "*-metrics"}
+	got := scraper.podPorts(pod)
+	if len(got) != 2 || got[0] != 15090 || got[1] != 9090 {
+		t.Fatalf("unexpected ports %v", got)
+	}
+
+	scraper.portNamePattern = "nothing-*"
+	if got := scraper.podPorts(pod); len(got) != 1 || got[0] != 8080 {
+		t.Fatalf("expected fallback to configured port, got %v", got)
+	}
+}
+
+func TestScrapeOnceAcceptsConfiguredStatusCodes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+
+	if err := newTestScraper(clientset, NewStore(), server).ScrapeOnce(context.Background()); err == nil {
+		t.Fatalf("expected 202 to be rejected by default")
+	}
+
+	scraper := newTestScraper(clientset, NewStore(), server, WithAcceptStatusCodes([]int{200, 202}))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("expected 202 to be accepted, got %v", err)
+	}
+}
+
+func TestScrapeOnceTreatsAcceptedNoContentAsEmpty(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+
+	if err := newTestScraper(clientset, NewStore(), server).ScrapeOnce(context.Background()); err == nil {
+		t.Fatalf("expected 204 to be rejected by default")
+	}
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithAcceptStatusCodes([]int{200, 204}))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("expected an accepted 204 to be an empty scrape, got %v", err)
+	}
+	families, err := store.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	if len(families) != 1 || families[0].GetName() != "product_up" || families[0].Metric[0].GetGauge().GetValue() != 1 {
+		t.Fatalf("expected only product_up=1, got %v", families)
+	}
+}
+
+func TestScrapeOnceRefetchesAfterParseError(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1)%2 == 1 {
+			fmt.Fprint(w, "sample_requests_total{code=\"200\" 42\n")
+			return
+		}
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+
+	if err := newTestScraper(clientset, NewStore(), server).ScrapeOnce(context.Background()); err == nil {
+		t.Fatal("expected the malformed body to fail without reparse retries")
+	}
+
+	requests.Store(0)
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithReparseRetries(1))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Fatalf("expected 2 requests, got %d", got)
+	}
+	if _, ok := writeAndParse(t, store)["sample_requests_total"]; !ok {
+		t.Fatal("expected the re-fetched metrics to be stored")
+	}
+}
+
+func TestScrapeOnceJudgesResponseSizeByLastAttempt(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			fmt.Fprint(w, "sample_requests_total{code=\"200\" 42\n")
+			return
+		}
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+	// Both attempts together exceed the threshold; the last one alone does not.
+	scraper := newTestScraper(clientset, NewStore(), server, WithReparseRetries(1), WithSlowPodThresholds(0, int64(len(sampleExposition))))
+	logger, hook := logtest.NewNullLogger()
+	scraper.logger = logger
+
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	for _, entry := range hook.AllEntries() {
+		if strings.HasPrefix(entry.Message, "large scrape response") {
+			t.Fatalf("expected no large response warning after a retry, got %q", entry.Message)
+		}
+	}
+}
+
+func TestScrapeOnceWarnsAboutSlowAndLargePods(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response takes three seconds on the scraper's clock.
+		fakeClock.Advance(3 * time.Second)
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+
+	cases := []struct {
+		name      string
+		duration  time.Duration
+		size      int64
+		wantSlow  bool
+		wantLarge bool
+	}{
+		{name: "within thresholds", duration: 5 * time.Second, size: int64(len(sampleExposition))},
+		{name: "slow", duration: 2 * time.Second, size: int64(len(sampleExposition)), wantSlow: true},
+		{name: "large", duration: 5 * time.Second, size: int64(len(sampleExposition)) - 1, wantLarge: true},
+		{name: "disabled"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			scraper := newTestScraper(clientset, NewStore(), server, WithClock(fakeClock), WithSlowPodThresholds(tc.duration, tc.size))
+			logger, hook := logtest.NewNullLogger()
+			scraper.logger = logger
+
+			if err := scraper.ScrapeOnce(context.Background()); err != nil {
+				t.Fatalf("ScrapeOnce() error = %v", err)
+			}
+			var slow, large bool
+			for _, entry := range hook.AllEntries() {
+				slow = slow || strings.HasPrefix(entry.Message, "slow scrape of pod ns-a/pod-1: took 3s")
+				large = large || strings.HasPrefix(entry.Message, "large scrape response from pod ns-a/pod-1")
+			}
+			if slow != tc.wantSlow || large != tc.wantLarge {
+				t.Fatalf("slow warning = %v, large warning = %v; want %v, %v", slow, large, tc.wantSlow, tc.wantLarge)
+			}
+		})
+	}
+}
+
+func TestScrapeOnceSendsConfiguredRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || string(body) != `{"format":"prometheus"}` {
+			http.Error(w, "query required", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithRequest(http.MethodPost, `{"format":"prometheus"}`))
+
+	// Every cycle must send the body again, not a drained reader.
+	for i := 0; i < 2; i++ {
+		if err := scraper.ScrapeOnce(context.Background()); err != nil {
+			t.Fatalf("ScrapeOnce() error = %v", err)
+		}
+	}
+	if _, ok := writeAndParse(t, store)["sample_requests_total"]; !ok {
+		t.Fatal("expected the POSTed scrape to be stored")
+	}
+}
+
+func TestScrapeOnceRecordsStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pod") == "pod-2" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+		newPod("ns-a", "pod-2", "10.0.0.2", map[string]string{"app": "alpha"}),
+	)
+	scraper := newTestScraper(clientset, NewStore(), server)
+	if status := scraper.Status(); status.Target != "alpha" || status.LastScrape != nil {
+		t.Fatalf("unexpected status before the first cycle: %+v", status)
+	}
+
+	err := scraper.ScrapeOnce(context.Background())
+	if err == nil {
+		t.Fatal("expected pod-2 to fail")
+	}
+	status := scraper.Status()
+	if status.LastScrape == nil || status.Success || status.PodsUp != 1 || status.PodsDown != 1 || status.Error != err.Error() {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestScrapeOnceSetsTimestampOnFailure(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("list", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("apiserver unavailable")
+	})
+	scraper := newTestScraper(clientset, NewStore(), server, WithClock(clock.NewFake(time.Unix(1700, 0))))
+
+	if err := scraper.ScrapeOnce(context.Background()); err == nil {
+		t.Fatal("expected the namespace list to fail")
+	}
+	if got := testutil.ToFloat64(scraper.metrics.timestamp.WithLabelValues("alpha")); got != 1700 {
+		t.Fatalf("expected scrape timestamp 1700, got %v", got)
+	}
+}
+
+func TestScrapeOnceUnionsPodSelectors(t *testing.T) {
+	hits := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits <- r.URL.Query().Get("pod")
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	both := newPod("ns-a", "both", "10.0.0.1", map[string]string{"app": "alpha", "tier": "web"})
+	both.UID = "uid-both"
+	web := newPod("ns-a", "web", "10.0.0.2", map[string]string{"tier": "web"})
+	web.UID = "uid-web"
+	other := newPod("ns-a", "other", "10.0.0.3", map[string]string{"tier": "db"})
+	other.UID = "uid-other"
+	clientset := fake.NewSimpleClientset(newNamespace("ns-a", map[string]string{"product": "alpha"}), both, web, other)
+
+	scraper := newTestScraper(clientset, NewStore(), server, WithPodSelectors([]string{"tier=web"}))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	close(hits)
+	var scraped []string
+	for pod := range hits {
+		scraped = append(scraped, pod)
+	}
+	sort.Strings(scraped)
+	if len(scraped) != 2 || scraped[0] != "both" || scraped[1] != "web" {
+		t.Fatalf("expected both and web to be scraped once each, got %v", scraped)
+	}
+}
+
+func TestScrapeOnceLimitsPodsPerNamespace(t *testing.T) {
+	hits := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits <- r.URL.Query().Get("pod")
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-c", "10.0.0.3", map[string]string{"app": "alpha"}),
+		newPod("ns-a", "pod-a", "10.0.0.1", map[string]string{"app": "alpha"}),
+		newPod("ns-a", "pod-0", "", map[string]string{"app": "alpha"}),
+		newPod("ns-a", "pod-b", "10.0.0.2", map[string]string{"app": "alpha"}),
+	)
+
+	scraper := newTestScraper(clientset, NewStore(), server, WithMaxPodsPerNamespace(2))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	close(hits)
+	var scraped []string
+	for pod := range hits {
+		scraped = append(scraped, pod)
+	}
+	sort.Strings(scraped)
+	// pod-0 has no IP and does not count against the limit.
+	if len(scraped) != 2 || scraped[0] != "pod-a" || scraped[1] != "pod-b" {
+		t.Fatalf("expected pod-a and pod-b to be scraped, got %v", scraped)
+	}
+}
+
+func TestScrapeOnceLogsPodLimitOnlyWhenItChanges(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-a", "10.0.0.1", map[string]string{"app": "alpha"}),
+		newPod("ns-a", "pod-b", "10.0.0.2", map[string]string{"app": "alpha"}),
+	)
+	scraper := newTestScraper(clientset, NewStore(), server, WithMaxPodsPerNamespace(1))
+	logger, hook := logtest.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	scraper.logger = logger
+
+	infoLogs := func() int {
+		var count int
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.InfoLevel && strings.HasPrefix(entry.Message, "limiting namespace") {
+				count++
+			}
+		}
+		return count
+	}
+	for i := 0; i < 2; i++ {
+		if err := scraper.ScrapeOnce(context.Background()); err != nil {
+			t.Fatalf("ScrapeOnce() error = %v", err)
+		}
+	}
+	if got := infoLogs(); got != 1 {
+		t.Fatalf("expected an unchanged limit to be logged at info once, got %d", got)
+	}
+
+	if _, err := clientset.CoreV1().Pods("ns-a").Create(context.Background(), newPod("ns-a", "pod-c", "10.0.0.3", map[string]string{"app": "alpha"}), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create pod: %v", err)
+	}
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	if got := infoLogs(); got != 2 {
+		t.Fatalf("expected a changed skip count to be logged at info, got %d", got)
+	}
+}
+
+func TestScrapeOnceSkipsTerminatingPods(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	deleted := metav1.Now()
+	terminating := newPod("ns-a", "pod-2", "10.0.0.2", map[string]string{"app": "alpha"})
+	terminating.DeletionTimestamp = &deleted
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+		terminating,
+	)
+
+	for _, enabled := range []bool{false, true} {
+		store := NewStore()
+		scraper := newTestScraper(clientset, store, server, WithScrapeTerminatingPods(enabled))
+		if err := scraper.ScrapeOnce(context.Background()); err != nil {
+			t.Fatalf("ScrapeOnce() error = %v", err)
+		}
+		want := 1
+		if enabled {
+			want = 2
+		}
+		if got := len(writeAndParse(t, store)[upMetricName].GetMetric()); got != want {
+			t.Fatalf("scrapeTerminatingPods=%v: expected %d scraped pods, got %d", enabled, want, got)
+		}
+	}
+}
+
+func TestReadyWaitsForMinReadyPods(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() && r.URL.Query().Get("pod") == "pod-2" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+		newPod("ns-a", "pod-2", "10.0.0.2", map[string]string{"app": "alpha"}),
+	)
+
+	scraper := newTestScraper(clientset, NewStore(), server, WithMinReadyPods(2))
+	// Run marks the first cycle as done; ScrapeOnce alone does not.
+	scraper.ready.Store(true)
+	for i, down := range []bool{true, false, true} {
+		failing.Store(down)
+		_ = scraper.ScrapeOnce(context.Background())
+		if want := i > 0; scraper.Ready() != want {
+			t.Fatalf("cycle %d: expected Ready() = %v", i, want)
+		}
+	}
+}
+
+func TestScrapeOnceRejectsBinaryBodiesOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("pod") {
+		case "garbage":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte{0xff, 0xfe, 'u', 'p'})
+		case "octet":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, sampleExposition)
+		default:
+			fmt.Fprint(w, sampleExposition)
+		}
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "garbage", "10.0.0.1", map[string]string{"app": "alpha"}),
+		newPod("ns-a", "octet", "10.0.0.2", map[string]string{"app": "alpha"}),
+		newPod("ns-a", "healthy", "10.0.0.3", map[string]string{"app": "alpha"}),
+	)
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server)
+	err := scraper.ScrapeOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "garbage") || !strings.Contains(err.Error(), "not valid UTF-8") {
+		t.Fatalf("expected an invalid body error for the garbage pod, got %v", err)
+	}
+	if strings.Contains(err.Error(), "octet") {
+		t.Fatalf("expected text served as application/octet-stream to be accepted, got %v", err)
+	}
+	if got := testutil.ToFloat64(scraper.metrics.invalidBody.WithLabelValues("alpha")); got != 1 {
+		t.Fatalf("expected 1 invalid body, got %v", got)
+	}
+	if got := len(writeAndParse(t, store)["sample_requests_total"].GetMetric()); got != 2 {
+		t.Fatalf("expected samples from the octet and healthy pods, got %d", got)
+	}
+}
+
+func TestScrapeOnceReportsBodyCutShortAsReadError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The connection closes after a rune cut in half, well short of the
+		// announced length.
+		w.Header().Set("Content-Length", "1000")
+		w.Write([]byte("up 1\n\xe2\x82"))
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+	)
+
+	scraper := newTestScraper(clientset, NewStore(), server)
+	err := scraper.ScrapeOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "read response") {
+		t.Fatalf("expected a read error, got %v", err)
+	}
+	if got := testutil.ToFloat64(scraper.metrics.invalidBody.WithLabelValues("alpha")); got != 0 {
+		t.Fatalf("expected the cut body not to count as invalid, got %v", got)
+	}
+}
+
+func TestCheckTextBody(t *testing.T) {
+	cut := []byte("ok_metric 1\n\xe2\x82")
+	cases := []struct {
+		name      string
+		prefix    []byte
+		truncated bool
+		valid     bool
+	}{
+		{name: "text", prefix: []byte("up 1\n"), valid: true},
+		{name: "rune cut by the sniff limit", prefix: cut, truncated: true, valid: true},
+		{name: "rune cut at the end of the body", prefix: cut},
+		{name: "nul bytes", prefix: []byte("up\x001")},
+	}
+	for _, tc := range cases {
+		if err := checkTextBody(tc.prefix, tc.truncated); (err == nil) != tc.valid {
+			t.Errorf("%s: checkTextBody() error = %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
+
+func TestIsTextContentType(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"":                          true,
+		"text/plain; version=0.0.4": true,
+		"application/openmetrics-text; version=1.0.0": true,
+		"application/octet-stream":                    false,
+		"application/vnd.google.protobuf":             false,
+	} {
+		if got := isTextContentType(contentType); got != want {
+			t.Errorf("isTextContentType(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestScrapeOnceClusterScopeSkipsNamespaceList(t *testing.T) {
+	server := newMetricsServer(t, map[string]string{"/metrics": sampleExposition})
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", nil),
+		newNamespace("ns-b", nil),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+		newPod("ns-b", "pod-2", "10.0.0.2", map[string]string{"app": "alpha"}),
+		newPod("ns-b", "other", "10.0.0.3", map[string]string{"app": "beta"}),
+	)
+	clientset.PrependReactor("list", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+		t.Error("cluster scope must not list namespaces")
+		return true, nil, fmt.Errorf("unexpected namespace list")
+	})
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithClusterScope(true))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+	up := map[string]string{}
+	for _, metric := range writeAndParse(t, store)[upMetricName].GetMetric() {
+		up[labelValue(metric, "pod")] = labelValue(metric, namespaceLabelKey)
+	}
+	if len(up) != 2 || up["pod-1"] != "ns-a" || up["pod-2"] != "ns-b" {
+		t.Fatalf("expected pod-1 in ns-a and pod-2 in ns-b, got %v", up)
+	}
+}
+
+func TestScrapeOnceKeepsPreviousDataOnPartialFailure(t *testing.T) {
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() && r.URL.Query().Get("pod") == "pod-2" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newNamespace("ns-b", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+		newPod("ns-b", "pod-2", "10.0.0.2", map[string]string{"app": "alpha"}),
+	)
+
+	for _, keep := range []bool{false, true} {
+		failing.Store(false)
+		store := NewStore()
+		scraper := newTestScraper(clientset, store, server, WithKeepOnPartialFailure(keep))
+		if err := scraper.ScrapeOnce(context.Background()); err != nil {
+			t.Fatalf("ScrapeOnce() error = %v", err)
+		}
+
+		failing.Store(true)
+		if err := scraper.ScrapeOnce(context.Background()); err == nil {
+			t.Fatalf("expected error while pod-2 is failing")
+		}
+
+		want := 1
+		if keep {
+			want = 2
+		}
+		families := writeAndParse(t, store)
+		if got := len(families["sample_requests_total"].GetMetric()); got != want {
+			t.Fatalf("keepOnPartialFailure=%v: expected %d metrics, got %d", keep, want, got)
+		}
+
+		up := make(map[string]float64)
+		for _, metric := range families[upMetricName].GetMetric() {
+			up[labelValue(metric, "pod")] = metric.GetGauge().GetValue()
+		}
+		if up["pod-1"] != 1 || up["pod-2"] != 0 || len(up) != 2 {
+			t.Fatalf("keepOnPartialFailure=%v: unexpected %s values %v", keep, upMetricName, up)
+		}
+	}
+}
+
+func TestScrapeOnceDropsRemovedPodsDespiteKeepOnPartialFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pod") == "pod-3" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, sampleExposition)
+	}))
+	t.Cleanup(server.Close)
+	clientset := fake.NewSimpleClientset(
+		newNamespace("ns-a", map[string]string{"product": "alpha"}),
+		newNamespace("ns-b", map[string]string{"product": "alpha"}),
+		newPod("ns-a", "pod-1", "10.0.0.1", map[string]string{"app": "alpha"}),
+		newPod("ns-b", "pod-2", "10.0.0.2", map[string]string{"app": "alpha"}),
+	)
+
+	store := NewStore()
+	scraper := newTestScraper(clientset, store, server, WithKeepOnPartialFailure(true))
+	if err := scraper.ScrapeOnce(context.Background()); err != nil {
+		t.Fatalf("ScrapeOnce() error = %v", err)
+	}
+
+	// pod-2 is scaled away while a new pod fails, so the cycle is partial.
+	if err := clientset.CoreV1().Pods("ns-b").Delete(context.Background(), "pod-2", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete pod-2: %v", err)
+	}
+	if _, err := clientset.CoreV1().Pods("ns-a").Create(context.Background(), newPod("ns-a", "pod-3", "10.0.0.3", map[string]string{"app": "alpha"}), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create pod-3: %v", err)
+	}
+	if err := scraper.ScrapeOnce(context.Background()); err == nil {
+		t.Fatalf("expected error while pod-3 is failing")
+	}
+
+	if got := len(writeAndParse(t, store)["sample_requests_total"].GetMetric()); got != 1 {
+		t.Fatalf("expected only pod-1's series after pod-2 was removed, got %d", got)
+	}
+}
+
+// newMetricsServer serves the given path -> body mapping and 404s everything else.
+func newMetricsServer(t *testing.T, bodies map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := bodies[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestScraper wires a Scraper against a fake clientset, routing every pod to server.
+func newTestScraper(clientset *fake.Clientset, store *Store, server *httptest.Server, opts ...ScraperOption) *Scraper {
+	serverURL, _ := url.Parse(server.URL)
+	builder := func(pod *corev1.Pod, _ int, path string) string {
+		return fmt.Sprintf("http://%s%s?pod=%s", serverURL.Host, path, url.QueryEscape(pod.Name))
+	}
+	logger := logrus.New()
+	logger.SetOutput(&bytes.Buffer{})
+
+	return NewScraper(
+		"alpha",
+		clientset,
+		server.Client(),
+		store,
+		time.Minute,
+		8080,
+		"/metrics",
+		"product=alpha",
+		"app=alpha",
+		logger,
+		append([]ScraperOption{WithURLBuilder(builder)}, opts...)...,
+	)
+}
+
+func newNamespace(name string, labels map[string]string) runtime.Object {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func newPod(namespace, name, ip string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Status:     corev1.PodStatus{PodIP: ip},
+	}
+}
+
+func newReplicaSet(namespace, name, deployment string) *appsv1.ReplicaSet {
+	controller := true
+	return &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Namespace:       namespace,
+		Name:            name,
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: deployment, Controller: &controller}},
+	}}
+}
+
+func writeAndParse(t *testing.T, store *Store) map[string]*dto.MetricFamily {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := store.WriteAll(&buf); err != nil {
+		t.Fatalf("WriteAll() error = %v", err)
+	}
+	parser := expfmt.TextParser{}
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse metrics output: %v", err)
+	}
+	return families
+}
+
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestScrapePodLogsWithTargetAndNamespace(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	scraper := NewScraper("alpha", fake.NewSimpleClientset(), http.DefaultClient, NewStore(),
+		time.Minute, 8080, "/metrics", "", "", logger)
+
+	ctx := scraper.withNamespaceLogger(context.Background(), "shop")
+	scraper.loggerFrom(ctx).Warn("probe")
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Data["target"] != "alpha" || entry.Data["namespace"] != "shop" {
+		t.Fatalf("expected target and namespace fields, got %+v", entry)
+	}
+	if scraper.loggerFrom(context.Background()) != scraper.logger {
+		t.Fatal("expected the scraper logger when the context carries none")
+	}
+}
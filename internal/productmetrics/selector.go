@@ -0,0 +1,167 @@
+package productmetrics
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// labelMatcher is one name/op/value term of a series selector.
+type labelMatcher struct {
+	name  string
+	op    string
+	value string
+	regex *regexp.Regexp
+}
+
+func (m labelMatcher) matches(value string) bool {
+	switch m.op {
+	case "=":
+		return value == m.value
+	case "!=":
+		return value != m.value
+	case "=~":
+		return m.regex.MatchString(value)
+	default: // "!~"
+		return !m.regex.MatchString(value)
+	}
+}
+
+// Selector is a parsed series selector such as
+// `http_requests_total{namespace="shop",code=~"5.."}`. It supports the =, !=,
+// =~ and !~ operators; __name__ may be matched like any other label.
+type Selector struct {
+	matchers []labelMatcher
+}
+
+// ParseSelector parses a PromQL-style instant vector selector without offsets
+// or range suffixes.
+func ParseSelector(input string) (Selector, error) {
+	rest := strings.TrimSpace(input)
+	var selector Selector
+
+	name, rest := scanIdentifier(rest, true)
+	if name != "" {
+		selector.matchers = append(selector.matchers, labelMatcher{name: metricNameLabel, op: "=", value: name})
+	}
+
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, "{") {
+		rest = strings.TrimSpace(rest[1:])
+		for !strings.HasPrefix(rest, "}") {
+			var matcher labelMatcher
+			var err error
+			matcher, rest, err = parseMatcher(rest)
+			if err != nil {
+				return Selector{}, fmt.Errorf("selector %q: %w", input, err)
+			}
+			selector.matchers = append(selector.matchers, matcher)
+
+			rest = strings.TrimSpace(rest)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "}") {
+				return Selector{}, fmt.Errorf("selector %q: expected , or }", input)
+			}
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+
+	if rest != "" {
+		return Selector{}, fmt.Errorf("selector %q: unexpected %q", input, rest)
+	}
+	if len(selector.matchers) == 0 {
+		return Selector{}, fmt.Errorf("selector %q: empty selector", input)
+	}
+	return selector, nil
+}
+
+func parseMatcher(input string) (labelMatcher, string, error) {
+	name, rest := scanIdentifier(input, false)
+	if name == "" {
+		return labelMatcher{}, "", fmt.Errorf("expected label name at %q", input)
+	}
+	rest = strings.TrimSpace(rest)
+
+	var op string
+	for _, candidate := range []string{"=~", "!~", "!=", "="} {
+		if strings.HasPrefix(rest, candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return labelMatcher{}, "", fmt.Errorf("expected operator after %q", name)
+	}
+	rest = strings.TrimSpace(rest[len(op):])
+
+	quoted, err := strconv.QuotedPrefix(rest)
+	if err != nil {
+		return labelMatcher{}, "", fmt.Errorf("expected quoted value for %q", name)
+	}
+	value, err := strconv.Unquote(quoted)
+	if err != nil {
+		return labelMatcher{}, "", fmt.Errorf("invalid value for %q: %w", name, err)
+	}
+
+	matcher := labelMatcher{name: name, op: op, value: value}
+	if op == "=~" || op == "!~" {
+		matcher.regex, err = regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return labelMatcher{}, "", fmt.Errorf("invalid regex for %q: %w", name, err)
+		}
+	}
+	return matcher, rest[len(quoted):], nil
+}
+
+// scanIdentifier splits a leading metric (allowColon) or label name off input.
+func scanIdentifier(input string, allowColon bool) (string, string) {
+	end := 0
+	for end < len(input) {
+		c := input[end]
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(end > 0 && c >= '0' && c <= '9') || (allowColon && c == ':')
+		if !valid {
+			break
+		}
+		end++
+	}
+	return input[:end], input[end:]
+}
+
+// matchesMetric reports whether a sample of family name with metric's labels
+// satisfies every matcher. Absent labels match as the empty string.
+func (s Selector) matchesMetric(name string, metric *dto.Metric) bool {
+	for _, matcher := range s.matchers {
+		if !matcher.matches(sampleLabelValue(name, metric, matcher.name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterFamilies returns copies of families holding only the samples matched
+// by at least one selector; families left empty are omitted.
+func FilterFamilies(families []*dto.MetricFamily, selectors []Selector) []*dto.MetricFamily {
+	var result []*dto.MetricFamily
+	for _, family := range families {
+		var kept []*dto.Metric
+		for _, metric := range family.Metric {
+			for _, selector := range selectors {
+				if selector.matchesMetric(family.GetName(), metric) {
+					kept = append(kept, metric)
+					break
+				}
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		filtered := &dto.MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type, Metric: kept}
+		result = append(result, filtered)
+	}
+	return result
+}
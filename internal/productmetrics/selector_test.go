@@ -0,0 +1,56 @@
+package productmetrics
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseSelectorRejectsMalformedInput(t *testing.T) {
+	for _, input := range []string{"", "{}", `up{namespace}`, `up{namespace="a"`, `up{code=~"("}`, `up{a="b"} extra`} {
+		if _, err := ParseSelector(input); err == nil {
+			t.Errorf("ParseSelector(%q) succeeded, want error", input)
+		}
+	}
+}
+
+func TestFilterFamilies(t *testing.T) {
+	families := []*dto.MetricFamily{
+		{
+			Name: proto.String("http_requests_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				newRelabelMetric("namespace", "shop", "code", "200"),
+				newRelabelMetric("namespace", "shop", "code", "503"),
+				newRelabelMetric("namespace", "billing", "code", "500"),
+			},
+		},
+		{
+			Name:   proto.String("product_up"),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{newRelabelMetric("namespace", "shop")},
+		},
+	}
+
+	var selectors []Selector
+	for _, input := range []string{`http_requests_total{namespace="shop", code=~"5.."}`, `{__name__="product_up",namespace!="billing"}`} {
+		selector, err := ParseSelector(input)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q) error = %v", input, err)
+		}
+		selectors = append(selectors, selector)
+	}
+
+	filtered := FilterFamilies(families, selectors)
+	if len(filtered) != 2 {
+		t.Fatalf("expected 2 families, got %d", len(filtered))
+	}
+	requests := filtered[0].GetMetric()
+	if len(requests) != 1 || sampleLabelValue("", requests[0], "code") != "503" {
+		t.Fatalf("expected only the shop 503 series, got %v", requests)
+	}
+	if len(families[0].Metric) != 3 {
+		t.Fatal("expected the input families to be left untouched")
+	}
+}
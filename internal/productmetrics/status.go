@@ -0,0 +1,59 @@
+package productmetrics
+
+import "time"
+
+// ScrapeStatus summarises a target's latest shared scrape cycle for status
+// pages and tooling.
+type ScrapeStatus struct {
+	Target string `json:"target"`
+	// LastScrape is when the cycle finished; nil before the first cycle.
+	LastScrape *time.Time `json:"lastScrape,omitempty"`
+	Duration   float64    `json:"durationSeconds"`
+	Success    bool       `json:"success"`
+	// PodsUp and PodsDown count the pods scraped successfully and
+	// unsuccessfully; pods skipped by filters are not counted.
+	PodsUp   int `json:"podsUp"`
+	PodsDown int `json:"podsDown"`
+	// Error joins the cycle's errors, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Status returns the outcome of the scraper's latest shared cycle.
+func (s *Scraper) Status() ScrapeStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Target = s.targetName
+	return status
+}
+
+// recordStatus stores the outcome of a shared cycle that started at start and
+// exports when it finished.
+// result is nil when the cycle failed before scraping any pod.
+func (s *Scraper) recordStatus(start time.Time, result *scrapeResult, err error) {
+	end := s.clock.Now()
+	s.metrics.setScrapeTimestamp(s.targetName, end)
+	status := ScrapeStatus{
+		LastScrape: &end,
+		Duration:   end.Sub(start).Seconds(),
+		Success:    err == nil,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if result != nil {
+		result.mu.Lock()
+		for _, up := range result.up {
+			if up.GetGauge().GetValue() == 1 {
+				status.PodsUp++
+			} else {
+				status.PodsDown++
+			}
+		}
+		result.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
@@ -1,30 +1,138 @@
 package productmetrics
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 	"sync"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
 )
 
 // MetricsContentType represents the HTTP content type for the exposed metrics endpoint.
 const MetricsContentType = string(expfmt.FmtText)
 
+// conflictSuffix is appended to a stored family name that collides with an
+// exporter-defined family of a different type.
+const conflictSuffix = "_product"
+
+// jobLabel identifies the owning target of every series in isolated stores.
+const jobLabel = "job"
+
+// allNamespaces is the namespace label value of namespace rollup series.
+const allNamespaces = "__all__"
+
 // Store caches metric families gathered from product pods, grouped by scraping target.
 type Store struct {
 	mu      sync.RWMutex
 	targets map[string]map[string]*dto.MetricFamily
+	// gatherers are in-process pseudo-targets gathered on every read.
+	gatherers map[string]prometheus.Gatherer
+	isolate   bool
+	rollup    bool
+	// raw, when non-nil, retains each target's pre-merge families keyed by
+	// namespace/pod for debugging.
+	raw map[string]map[string]map[string]*dto.MetricFamily
+
+	helpPolicy     HelpConflictPolicy
+	onHelpConflict func(family string)
+	// generation counts changes to targets; see Generation.
+	generation uint64
+	// typeClashes records the "family/target" pairs already warned about
+	// for exporting a family under a different type than another target.
+	typeClashes sync.Map
+}
+
+// StoreOption customises optional Store behaviour.
+type StoreOption func(*Store)
+
+// WithIsolateTargets labels every series with job=<target> instead of pooling
+// same-named families from different targets anonymously, following
+// Prometheus's per-job model. A series' own job label is kept as exported_job.
+func WithIsolateTargets(isolate bool) StoreOption {
+	return func(s *Store) {
+		s.isolate = isolate
+	}
+}
+
+// WithNamespaceRollup adds to every product family a namespace="__all__" copy
+// of its series, summed across namespaces, so that queries need no
+// sum without(namespace). Summaries are not rolled up.
+func WithNamespaceRollup(enabled bool) StoreOption {
+	return func(s *Store) {
+		s.rollup = enabled
+	}
+}
+
+// WithRawSnapshots retains every target's per-pod families before they are
+// merged, for WriteRaw.
+func WithRawSnapshots(enabled bool) StoreOption {
+	return func(s *Store) {
+		if enabled {
+			s.raw = make(map[string]map[string]map[string]*dto.MetricFamily)
+		}
+	}
+}
+
+// HelpConflictPolicy decides the HELP text of a family that different targets
+// describe differently.
+type HelpConflictPolicy string
+
+// Supported HelpConflictPolicy values. Targets are considered in name order.
+const (
+	// HelpFirst keeps the first target's HELP text.
+	HelpFirst HelpConflictPolicy = "first"
+	// HelpLongest keeps the longest HELP text.
+	HelpLongest HelpConflictPolicy = "longest"
+	// HelpDrop omits the HELP line of conflicting families.
+	HelpDrop HelpConflictPolicy = "drop"
+	// HelpWarn keeps the first HELP text like HelpFirst; it exists so that
+	// callers can pair reporting with the default resolution.
+	HelpWarn HelpConflictPolicy = "warn"
+)
+
+// resolve returns the HELP text to keep when current and incoming differ.
+func (p HelpConflictPolicy) resolve(current, incoming string) *string {
+	switch p {
+	case HelpDrop:
+		return nil
+	case HelpLongest:
+		if len(incoming) > len(current) || (len(incoming) == len(current) && incoming < current) {
+			return proto.String(incoming)
+		}
+	}
+	return proto.String(current)
+}
+
+// WithHelpConflictPolicy sets how HELP conflicts between targets are resolved
+// and calls onConflict, if non-nil, once per conflicting family each time the
+// store is rendered. It defaults to HelpFirst.
+func WithHelpConflictPolicy(policy HelpConflictPolicy, onConflict func(family string)) StoreOption {
+	return func(s *Store) {
+		if policy != "" {
+			s.helpPolicy = policy
+		}
+		s.onHelpConflict = onConflict
+	}
 }
 
 // NewStore returns an initialized Store.
-func NewStore() *Store {
-	return &Store{
-		targets: make(map[string]map[string]*dto.MetricFamily),
+func NewStore(opts ...StoreOption) *Store {
+	s := &Store{
+		targets:    make(map[string]map[string]*dto.MetricFamily),
+		gatherers:  make(map[string]prometheus.Gatherer),
+		helpPolicy: HelpFirst,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // Replace updates the cached metric families for a specific scraping target.
@@ -32,29 +140,221 @@ func (s *Store) Replace(target string, all map[string]*dto.MetricFamily) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.targets[target] = all
+	s.generation++
+}
+
+// Generation returns a number that changes whenever a target's families are
+// replaced or deleted, so callers can tell whether output rendered earlier is
+// outdated. Gatherers are not covered.
+func (s *Store) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.generation
+}
+
+// Delete drops the cached metric families for a scraping target that is no longer running.
+func (s *Store) Delete(target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.targets, target)
+	s.generation++
+	if s.raw != nil {
+		delete(s.raw, target)
+	}
 }
 
-// WriteAll renders every cached metric family to the provided writer in text format.
+// retainsRaw reports whether ReplaceRaw snapshots are kept.
+func (s *Store) retainsRaw() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.raw != nil
+}
+
+// ReplaceRaw records target's per-pod families, keyed by namespace/pod, when
+// the store was created WithRawSnapshots; otherwise it does nothing.
+func (s *Store) ReplaceRaw(target string, pods map[string]map[string]*dto.MetricFamily) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.raw != nil {
+		s.raw[target] = pods
+	}
+}
+
+// ErrUnknownTarget is returned by WriteRaw for targets without a snapshot.
+var ErrUnknownTarget = errors.New("no raw snapshot for target")
+
+// WriteRaw renders target's per-pod families in text format, one section per
+// pod introduced by a "# pod: namespace/name" comment, in pod and family name
+// order.
+func (s *Store) WriteRaw(w io.Writer, target string) error {
+	s.mu.RLock()
+	pods, ok := s.raw[target]
+	s.mu.RUnlock()
+	if !ok {
+		return ErrUnknownTarget
+	}
+
+	keys := make([]string, 0, len(pods))
+	for key := range pods {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(w, "# pod: %s\n", key); err != nil {
+			return err
+		}
+		names := make([]string, 0, len(pods[key]))
+		for name := range pods[key] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := encoder.Encode(pods[key][name]); err != nil {
+				return fmt.Errorf("encode metric family %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// AddGatherer registers an in-process gatherer, such as the exporter's own
+// registry, as the pseudo-target name. Its families are gathered on every read
+// and take precedence over scraped families: scraped families of the same name
+// and type are merged into them, and those of a conflicting type are renamed
+// with a "_product" suffix (see MergeFamilies).
+func (s *Store) AddGatherer(name string, gatherer prometheus.Gatherer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gatherers[name] = gatherer
+}
+
+// WriteAll renders every family returned by Gather to the provided writer in text format.
+// Output is reproducible: families are sorted by name and the metrics within a
+// family by their label set (see sortMetrics).
 func (s *Store) WriteAll(w io.Writer) error {
-	combined := s.snapshot()
-	if len(combined) == 0 {
-		return nil
+	_, err := s.WriteAllSeries(w)
+	return err
+}
+
+// WriteAllSeries is WriteAll that also returns the number of series written,
+// counting a histogram or summary as one series.
+func (s *Store) WriteAllSeries(w io.Writer) (int, error) {
+	families, err := s.Gather()
+	if err != nil {
+		return 0, err
 	}
 
+	var series int
 	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return series, fmt.Errorf("encode metric family %s: %w", family.GetName(), err)
+		}
+		series += len(family.Metric)
+	}
+
+	return series, nil
+}
+
+// Gather implements prometheus.Gatherer, returning the pseudo-target families
+// merged with the cached families, sorted by name.
+func (s *Store) Gather() ([]*dto.MetricFamily, error) {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.gatherers))
+	for name := range s.gatherers {
+		names = append(names, name)
+	}
+	gatherers := make([]prometheus.Gatherer, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		gatherers = append(gatherers, s.gatherers[name])
+	}
+	s.mu.RUnlock()
+
+	var primary []*dto.MetricFamily
+	for i, gatherer := range gatherers {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return nil, fmt.Errorf("gather %s: %w", names[i], err)
+		}
+		if s.isolate {
+			for _, family := range families {
+				labelJob(family, names[i])
+			}
+		}
+		primary = MergeFamilies(primary, families)
+	}
+
+	products := s.gatherProducts()
+	if len(primary) == 0 {
+		return products, nil
+	}
+	return MergeFamilies(primary, products), nil
+}
+
+// gatherProducts returns the cached scraped families sorted by name.
+func (s *Store) gatherProducts() []*dto.MetricFamily {
+	combined := s.snapshot()
 	names := make([]string, 0, len(combined))
 	for name := range combined {
 		names = append(names, name)
 	}
 	sort.Strings(names)
 
+	result := make([]*dto.MetricFamily, 0, len(names))
 	for _, name := range names {
-		if err := encoder.Encode(combined[name]); err != nil {
-			return fmt.Errorf("encode metric family %s: %w", name, err)
+		result = append(result, combined[name])
+	}
+	return result
+}
+
+// MergeFamilies combines exporter-defined families with product families so
+// that every family name appears exactly once. Families sharing a name and
+// type are combined (keeping the primary HELP text); a product family whose
+// type conflicts is renamed with a "_product" suffix. The result is sorted by name.
+func MergeFamilies(primary, secondary []*dto.MetricFamily) []*dto.MetricFamily {
+	merged := make(map[string]*dto.MetricFamily, len(primary)+len(secondary))
+	for _, family := range primary {
+		merged[family.GetName()] = family
+	}
+
+	for _, family := range secondary {
+		name := family.GetName()
+		existing, ok := merged[name]
+		if !ok {
+			merged[name] = family
+			continue
+		}
+		if existing.GetType() == family.GetType() {
+			existing.Metric = append(existing.Metric, family.Metric...)
+			continue
+		}
+
+		renamed := cloneFamily(name, family)
+		if renamed == nil {
+			continue
+		}
+		renamed.Name = proto.String(name + conflictSuffix)
+		if other, ok := merged[renamed.GetName()]; ok && other.GetType() == renamed.GetType() {
+			other.Metric = append(other.Metric, renamed.Metric...)
+		} else {
+			merged[renamed.GetName()] = renamed
 		}
 	}
 
-	return nil
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		result = append(result, merged[name])
+	}
+	return result
 }
 
 func (s *Store) snapshot() map[string]*dto.MetricFamily {
@@ -65,17 +365,159 @@ func (s *Store) snapshot() map[string]*dto.MetricFamily {
 		return nil
 	}
 
+	// Targets are merged in name order so that HELP resolution is stable.
+	targets := make([]string, 0, len(s.targets))
+	for target := range s.targets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
 	result := make(map[string]*dto.MetricFamily)
-	for _, families := range s.targets {
-		for name, family := range families {
-			familyClone := proto.Clone(family).(*dto.MetricFamily)
-			if existing, ok := result[name]; ok {
-				existing.Metric = append(existing.Metric, familyClone.Metric...)
-			} else {
+	conflicts := make(map[string]bool)
+	for _, target := range targets {
+		for name, family := range s.targets[target] {
+			familyClone := cloneFamily(name, family)
+			if familyClone == nil {
+				continue
+			}
+			if s.isolate {
+				labelJob(familyClone, target)
+			}
+			existing, ok := result[name]
+			if !ok {
 				result[name] = familyClone
+				continue
+			}
+			// A family can only hold one type, so a clash with an earlier
+			// target's family would make the whole exposition unencodable.
+			if existing.GetType() != familyClone.GetType() {
+				if _, warned := s.typeClashes.LoadOrStore(name+"/"+target, true); !warned {
+					logrus.WithField("component", "product-metrics").Warnf("skipping metric family %q of target %s: it is a %s, but an earlier target exports it as a %s",
+						name, target, familyClone.GetType(), existing.GetType())
+				}
+				continue
+			}
+			existing.Metric = append(existing.Metric, familyClone.Metric...)
+			if conflicts[name] || existing.GetHelp() != familyClone.GetHelp() {
+				if !conflicts[name] && s.onHelpConflict != nil {
+					s.onHelpConflict(name)
+				}
+				conflicts[name] = true
+				existing.Help = s.helpPolicy.resolve(existing.GetHelp(), familyClone.GetHelp())
 			}
 		}
 	}
 
+	for _, family := range result {
+		if s.rollup {
+			rollupNamespaces(family)
+		}
+		sortMetrics(family)
+	}
+
 	return result
 }
+
+// rollupNamespaces appends to family one series per label set, ignoring the
+// namespace label, that sums the series of every namespace and carries
+// namespace="__all__". Sums that cannot be formed, e.g. histograms with
+// different buckets, are left out rather than published partially.
+func rollupNamespaces(family *dto.MetricFamily) {
+	if family.GetType() == dto.MetricType_SUMMARY {
+		return
+	}
+
+	index := make(map[string]int)
+	var rollups []*dto.Metric
+	broken := make(map[string]bool)
+	for _, metric := range family.Metric {
+		namespace := -1
+		for i, pair := range metric.Label {
+			if pair.GetName() == namespaceLabelKey {
+				namespace = i
+				break
+			}
+		}
+		if namespace < 0 || metric.Label[namespace].GetValue() == allNamespaces {
+			continue
+		}
+
+		rollup, ok := proto.Clone(metric).(*dto.Metric)
+		if !ok || rollup == nil {
+			continue
+		}
+		rollup.Label[namespace].Value = proto.String(allNamespaces)
+		rollup.TimestampMs = nil
+		key := labelKey(rollup)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(rollups)
+			rollups = append(rollups, rollup)
+			continue
+		}
+		if !sumSeries(family.GetType(), rollups[i], rollup) {
+			broken[key] = true
+		}
+	}
+
+	for _, rollup := range rollups {
+		if !broken[labelKey(rollup)] {
+			family.Metric = append(family.Metric, rollup)
+		}
+	}
+}
+
+// sumSeries adds the values of from into into like addSeries, additionally
+// summing gauges and untyped samples, and reports whether it could.
+func sumSeries(typ dto.MetricType, into, from *dto.Metric) bool {
+	switch typ {
+	case dto.MetricType_GAUGE:
+		if into.Gauge == nil || from.Gauge == nil {
+			return false
+		}
+		into.Gauge.Value = proto.Float64(into.Gauge.GetValue() + from.Gauge.GetValue())
+		return true
+	case dto.MetricType_UNTYPED:
+		if into.Untyped == nil || from.Untyped == nil {
+			return false
+		}
+		into.Untyped.Value = proto.Float64(into.Untyped.GetValue() + from.Untyped.GetValue())
+		return true
+	}
+	return addSeries(typ, into, from)
+}
+
+// labelJob sets job=target on every metric of family, moving a scraped job
+// label to exported_job as Prometheus does without honor_labels.
+func labelJob(family *dto.MetricFamily, target string) {
+	for _, metric := range family.Metric {
+		for _, pair := range metric.Label {
+			if pair.GetName() == jobLabel {
+				pair.Name = proto.String("exported_" + jobLabel)
+			}
+		}
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(jobLabel), Value: proto.String(target)})
+	}
+}
+
+// sortMetrics orders a family's metrics by their label pairs, compared in label
+// name order, so that output does not depend on scrape or map iteration order.
+func sortMetrics(family *dto.MetricFamily) {
+	keys := make(map[*dto.Metric]string, len(family.Metric))
+	for _, metric := range family.Metric {
+		keys[metric] = labelKey(metric)
+	}
+	sort.SliceStable(family.Metric, func(i, j int) bool {
+		return keys[family.Metric[i]] < keys[family.Metric[j]]
+	})
+}
+
+// labelKey renders a metric's labels as a canonical name-sorted string.
+func labelKey(metric *dto.Metric) string {
+	pairs := make([]string, 0, len(metric.Label))
+	for _, label := range metric.Label {
+		pairs = append(pairs, label.GetName()+"\xff"+label.GetValue())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xfe")
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 )
@@ -57,6 +58,245 @@ func TestStoreWriteAllMergesTargets(t *testing.T) {
 	}
 }
 
+func TestStoreIsolateTargetsAddsJobLabel(t *testing.T) {
+	store := NewStore(WithIsolateTargets(true))
+
+	store.Replace("alpha", map[string]*dto.MetricFamily{
+		"requests_total": newGaugeFamily("requests_total", "ns-a", 1),
+	})
+	beta := newGaugeFamily("requests_total", "ns-a", 2)
+	beta.Metric[0].Label = append(beta.Metric[0].Label, &dto.LabelPair{Name: proto.String("job"), Value: proto.String("app")})
+	store.Replace("beta", map[string]*dto.MetricFamily{"requests_total": beta})
+
+	families, err := store.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	if len(families) != 1 || len(families[0].Metric) != 2 {
+		t.Fatalf("expected one family with 2 series, got %v", families)
+	}
+
+	found := map[string]float64{}
+	for _, metric := range families[0].Metric {
+		labels := map[string]string{}
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["job"] == "beta" && labels["exported_job"] != "app" {
+			t.Fatalf("expected the scraped job label to move to exported_job, got %v", labels)
+		}
+		found[labels["job"]] = metric.GetGauge().GetValue()
+	}
+	if found["alpha"] != 1 || found["beta"] != 2 {
+		t.Fatalf("unexpected per-job values: %+v", found)
+	}
+
+	// The stored families must not be modified by the snapshot.
+	if len(beta.Metric[0].Label) != 2 || beta.Metric[0].Label[1].GetName() != "job" {
+		t.Fatalf("stored family was mutated: %v", beta.Metric[0].Label)
+	}
+}
+
+func TestStoreIsolateTargetsSkipsTypeClashes(t *testing.T) {
+	store := NewStore(WithIsolateTargets(true))
+	counter := newGaugeFamily("requests_total", "ns-a", 1)
+	counter.Type = dto.MetricType_COUNTER.Enum()
+	counter.Metric[0].Gauge = nil
+	counter.Metric[0].Counter = &dto.Counter{Value: proto.Float64(3)}
+	store.Replace("alpha", map[string]*dto.MetricFamily{"requests_total": counter})
+	store.Replace("beta", map[string]*dto.MetricFamily{"requests_total": newGaugeFamily("requests_total", "ns-b", 2)})
+
+	var buf bytes.Buffer
+	if err := store.WriteAll(&buf); err != nil {
+		t.Fatalf("WriteAll() error = %v", err)
+	}
+	families, err := (&expfmt.TextParser{}).TextToMetricFamilies(&buf)
+	if err != nil {
+		t.Fatalf("failed to parse metrics output: %v", err)
+	}
+	family := families["requests_total"]
+	if family.GetType() != dto.MetricType_COUNTER || len(family.GetMetric()) != 1 {
+		t.Fatalf("expected only alpha's counter series, got %v", family)
+	}
+	if job := labelValue(family.GetMetric()[0], jobLabel); job != "alpha" {
+		t.Fatalf("expected the series of target alpha, got job=%q", job)
+	}
+}
+
+func TestStoreHelpConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy HelpConflictPolicy
+		want   string
+	}{
+		{HelpFirst, "Requests."},
+		{HelpLongest, "Total requests served."},
+		{HelpDrop, ""},
+		{HelpWarn, "Requests."},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			var conflicts []string
+			store := NewStore(WithHelpConflictPolicy(tt.policy, func(family string) {
+				conflicts = append(conflicts, family)
+			}))
+			alpha := newGaugeFamily("requests_total", "ns-a", 1)
+			alpha.Help = proto.String("Requests.")
+			beta := newGaugeFamily("requests_total", "ns-b", 2)
+			beta.Help = proto.String("Total requests served.")
+			gamma := newGaugeFamily("requests_total", "ns-c", 3)
+			gamma.Help = proto.String("Requests.")
+			// Inserted out of order: resolution follows target names.
+			store.Replace("gamma", map[string]*dto.MetricFamily{"requests_total": gamma})
+			store.Replace("beta", map[string]*dto.MetricFamily{"requests_total": beta})
+			store.Replace("alpha", map[string]*dto.MetricFamily{"requests_total": alpha})
+
+			families, err := store.Gather()
+			if err != nil {
+				t.Fatalf("Gather() error = %v", err)
+			}
+			if len(families) != 1 || len(families[0].Metric) != 3 {
+				t.Fatalf("expected one family with 3 series, got %v", families)
+			}
+			if got := families[0].GetHelp(); got != tt.want {
+				t.Fatalf("HELP = %q, want %q", got, tt.want)
+			}
+			if len(conflicts) != 1 || conflicts[0] != "requests_total" {
+				t.Fatalf("expected one conflict report for requests_total, got %v", conflicts)
+			}
+		})
+	}
+}
+
+func TestStoreGatherMergesPseudoTargets(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	custom := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_metric", Help: "In-process gauge."})
+	custom.Set(3)
+	conflicting := prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_total", Help: "In-process counter."})
+	registry.MustRegister(custom, conflicting)
+
+	store := NewStore()
+	store.AddGatherer("inprocess", registry)
+	store.Replace("alpha", map[string]*dto.MetricFamily{
+		"test_metric":    newGaugeFamily("test_metric", "ns-a", 1),
+		"requests_total": newGaugeFamily("requests_total", "ns-a", 2),
+	})
+
+	families, err := store.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	byName := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	if got := len(byName["test_metric"].GetMetric()); got != 2 {
+		t.Fatalf("expected test_metric to merge both sources, got %d series", got)
+	}
+	if byName["test_metric"].GetHelp() != "In-process gauge." {
+		t.Fatalf("expected the pseudo-target HELP to win, got %q", byName["test_metric"].GetHelp())
+	}
+	if byName["requests_total"].GetType() != dto.MetricType_COUNTER || byName["requests_total"+conflictSuffix] == nil {
+		t.Fatalf("expected the scraped gauge to be renamed on type conflict, got %v", byName)
+	}
+}
+
+func TestStoreGenerationChangesOnUpdate(t *testing.T) {
+	store := NewStore()
+	initial := store.Generation()
+
+	store.Replace("alpha", map[string]*dto.MetricFamily{})
+	replaced := store.Generation()
+	if replaced == initial {
+		t.Fatal("expected Replace to change the generation")
+	}
+	store.AddGatherer("self", prometheus.NewRegistry())
+	if store.Generation() != replaced {
+		t.Fatal("expected AddGatherer to keep the generation")
+	}
+	store.Delete("alpha")
+	if store.Generation() == replaced {
+		t.Fatal("expected Delete to change the generation")
+	}
+}
+
+func TestStoreNamespaceRollup(t *testing.T) {
+	store := NewStore(WithNamespaceRollup(true))
+	store.Replace("alpha", map[string]*dto.MetricFamily{
+		"test_metric": newGaugeFamily("test_metric", "ns-a", 1),
+		"test_summary": {
+			Name: proto.String("test_summary"),
+			Type: dto.MetricType_SUMMARY.Enum(),
+			Metric: []*dto.Metric{{
+				Label:   []*dto.LabelPair{{Name: proto.String(namespaceLabelKey), Value: proto.String("ns-a")}},
+				Summary: &dto.Summary{SampleCount: proto.Uint64(1), SampleSum: proto.Float64(1)},
+			}},
+		},
+	})
+	store.Replace("beta", map[string]*dto.MetricFamily{
+		"test_metric": newGaugeFamily("test_metric", "ns-b", 2),
+	})
+
+	families := writeAndParse(t, store)
+	found := map[string]float64{}
+	for _, metric := range families["test_metric"].GetMetric() {
+		found[labelValue(metric, namespaceLabelKey)] = metric.GetGauge().GetValue()
+	}
+	if len(found) != 3 || found["ns-a"] != 1 || found["ns-b"] != 2 || found[allNamespaces] != 3 {
+		t.Fatalf("unexpected rolled-up values: %+v", found)
+	}
+	if got := len(families["test_summary"].GetMetric()); got != 1 {
+		t.Fatalf("expected summaries not to be rolled up, got %d series", got)
+	}
+}
+
+func TestStoreWriteRaw(t *testing.T) {
+	store := NewStore(WithRawSnapshots(true))
+	store.ReplaceRaw("alpha", map[string]map[string]*dto.MetricFamily{
+		"ns-b/pod-2": {"test_metric": newGaugeFamily("test_metric", "ns-b", 2)},
+		"ns-a/pod-1": {"test_metric": newGaugeFamily("test_metric", "ns-a", 1)},
+	})
+
+	var buf bytes.Buffer
+	if err := store.WriteRaw(&buf, "alpha"); err != nil {
+		t.Fatalf("WriteRaw() error = %v", err)
+	}
+	out := buf.String()
+	first, second := bytes.Index(buf.Bytes(), []byte("# pod: ns-a/pod-1\n")), bytes.Index(buf.Bytes(), []byte("# pod: ns-b/pod-2\n"))
+	if first < 0 || second < first {
+		t.Fatalf("expected one section per pod in pod order, got:\n%s", out)
+	}
+
+	if err := store.WriteRaw(&buf, "beta"); err != ErrUnknownTarget {
+		t.Fatalf("WriteRaw() for an unknown target error = %v, want ErrUnknownTarget", err)
+	}
+	if NewStore().retainsRaw() {
+		t.Fatal("expected raw snapshots to be off by default")
+	}
+}
+
+func TestStoreWriteAllSortsMetricsWithinFamily(t *testing.T) {
+	store := NewStore()
+	family := newGaugeFamily("test_metric", "ns-c", 3)
+	family.Metric = append(family.Metric,
+		newGaugeFamily("test_metric", "ns-a", 1).Metric[0],
+		newGaugeFamily("test_metric", "ns-b", 2).Metric[0],
+	)
+	store.Replace("alpha", map[string]*dto.MetricFamily{"test_metric": family})
+
+	families, err := store.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	var order []string
+	for _, metric := range families[0].GetMetric() {
+		order = append(order, metric.GetLabel()[0].GetValue())
+	}
+	if len(order) != 3 || order[0] != "ns-a" || order[1] != "ns-b" || order[2] != "ns-c" {
+		t.Fatalf("expected metrics sorted by label set, got %v", order)
+	}
+}
+
 func TestStoreWriteAllEmpty(t *testing.T) {
 	store := NewStore()
 	var buf bytes.Buffer
@@ -68,6 +308,63 @@ func TestStoreWriteAllEmpty(t *testing.T) {
 	}
 }
 
+func TestStoreWriteAllSeriesCountsSeries(t *testing.T) {
+	store := NewStore()
+	store.Replace("alpha", map[string]*dto.MetricFamily{
+		"test_metric":  newGaugeFamily("test_metric", "ns-a", 1),
+		"other_metric": newGaugeFamily("other_metric", "ns-a", 1),
+	})
+	store.Replace("beta", map[string]*dto.MetricFamily{
+		"test_metric": newGaugeFamily("test_metric", "ns-b", 2),
+	})
+
+	var buf bytes.Buffer
+	series, err := store.WriteAllSeries(&buf)
+	if err != nil {
+		t.Fatalf("WriteAllSeries() error = %v", err)
+	}
+	if series != 3 {
+		t.Fatalf("expected 3 series, got %d", series)
+	}
+}
+
+func TestMergeFamiliesCombinesAndSuffixesConflicts(t *testing.T) {
+	counter := newGaugeFamily("up", "ns-a", 1)
+	counter.Type = dto.MetricType_COUNTER.Enum()
+	counter.Metric[0].Gauge = nil
+	counter.Metric[0].Counter = &dto.Counter{Value: proto.Float64(3)}
+
+	primary := []*dto.MetricFamily{
+		newGaugeFamily("shared", "exporter", 1),
+		newGaugeFamily("up", "exporter", 1),
+	}
+	secondary := []*dto.MetricFamily{
+		newGaugeFamily("shared", "ns-a", 2),
+		counter,
+	}
+
+	merged := MergeFamilies(primary, secondary)
+
+	byName := make(map[string]*dto.MetricFamily)
+	for _, family := range merged {
+		if _, dup := byName[family.GetName()]; dup {
+			t.Fatalf("family %s appears more than once", family.GetName())
+		}
+		byName[family.GetName()] = family
+	}
+
+	if got := len(byName["shared"].GetMetric()); got != 2 {
+		t.Fatalf("expected shared family to combine 2 metrics, got %d", got)
+	}
+	if got := len(byName["up"].GetMetric()); got != 1 {
+		t.Fatalf("expected exporter up family untouched, got %d metrics", got)
+	}
+	renamed, ok := byName["up"+conflictSuffix]
+	if !ok || renamed.GetType() != dto.MetricType_COUNTER {
+		t.Fatalf("expected conflicting product family to be renamed, got %v", renamed)
+	}
+}
+
 func newGaugeFamily(name, namespace string, value float64) *dto.MetricFamily {
 	return &dto.MetricFamily{
 		Name: proto.String(name),
@@ -0,0 +1,102 @@
+package productmetrics
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// TransportOptions describes how the HTTP client used for scraping is built.
+type TransportOptions struct {
+	// Timeout bounds each request, including reading the body.
+	Timeout time.Duration
+	// DialTimeout bounds establishing the TCP connection, so pods on dead
+	// nodes fail fast; zero keeps the 30s default.
+	DialTimeout time.Duration
+	// HTTP2 speaks cleartext HTTP/2 with prior knowledge (h2c) instead of HTTP/1.1.
+	HTTP2 bool
+	// SourceIP, when set, is the local address scrape connections originate from.
+	SourceIP net.IP
+	// DNSServer, when set, is the host:port of the DNS server used to resolve
+	// hostnames in scrape URLs instead of the system resolver. Pod IPs are
+	// dialled directly and never resolved.
+	DNSServer string
+	// TLS, when set, configures HTTPS scrapes, e.g. a CA pool or ServerName
+	// override. It is ignored for HTTP2, which is cleartext only.
+	TLS *tls.Config
+	// MinTLSVersion and CipherSuites, when set, override the corresponding
+	// fields of TLS for every HTTPS scrape, e.g. to enforce TLS 1.2 or later.
+	MinTLSVersion uint16
+	CipherSuites  []uint16
+	// Proxy, when set, is the HTTP proxy scrapes are sent through instead of
+	// the one named by the environment. It is ignored for HTTP2.
+	Proxy *url.URL
+}
+
+// tlsConfig returns the client TLS configuration with the version and cipher
+// suite restrictions applied, or nil when there is nothing to configure.
+func (opts TransportOptions) tlsConfig() *tls.Config {
+	if opts.MinTLSVersion == 0 && len(opts.CipherSuites) == 0 {
+		return opts.TLS
+	}
+	config := &tls.Config{}
+	if opts.TLS != nil {
+		config = opts.TLS.Clone()
+	}
+	if opts.MinTLSVersion != 0 {
+		config.MinVersion = opts.MinTLSVersion
+	}
+	if len(opts.CipherSuites) > 0 {
+		config.CipherSuites = opts.CipherSuites
+	}
+	return config
+}
+
+// NewHTTPClient builds a scrape client from opts.
+func NewHTTPClient(opts TransportOptions) *http.Client {
+	client := &http.Client{Timeout: opts.Timeout}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if opts.DialTimeout > 0 {
+		dialer.Timeout = opts.DialTimeout
+	}
+	if opts.SourceIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: opts.SourceIP}
+	}
+	if opts.DNSServer != "" {
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, opts.DNSServer)
+			},
+		}
+	}
+
+	switch {
+	case opts.HTTP2:
+		client.Transport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}
+	case opts.SourceIP != nil || opts.tlsConfig() != nil || opts.DialTimeout > 0 || opts.DNSServer != "" || opts.Proxy != nil:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = dialer.DialContext
+		transport.TLSClientConfig = opts.tlsConfig()
+		if opts.Proxy != nil {
+			transport.Proxy = http.ProxyURL(opts.Proxy)
+		}
+		client.Transport = transport
+	}
+	return client
+}
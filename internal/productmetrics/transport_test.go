@@ -0,0 +1,170 @@
+package productmetrics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestNewHTTPClientSpeaksH2C(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+	server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	t.Cleanup(server.Close)
+
+	client := NewHTTPClient(TransportOptions{Timeout: 5 * time.Second, HTTP2: true})
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2 response, got %s", resp.Proto)
+	}
+}
+
+func TestNewHTTPClientVerifiesTLSServerName(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	// The test certificate is issued for example.com, so verification only
+	// passes when ServerName overrides the dialled IP.
+	for serverName, wantErr := range map[string]bool{"example.com": false, "other.example": true} {
+		client := NewHTTPClient(TransportOptions{
+			Timeout: 5 * time.Second,
+			TLS:     &tls.Config{RootCAs: roots, ServerName: serverName},
+		})
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != wantErr {
+			t.Errorf("ServerName %q: error = %v, wantErr %v", serverName, err, wantErr)
+		}
+	}
+}
+
+func TestNewHTTPClientEnforcesMinTLSVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	for minVersion, wantErr := range map[uint16]bool{tls.VersionTLS12: false, tls.VersionTLS13: true} {
+		client := NewHTTPClient(TransportOptions{
+			Timeout:       5 * time.Second,
+			TLS:           &tls.Config{RootCAs: roots, ServerName: "example.com"},
+			MinTLSVersion: minVersion,
+		})
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != wantErr {
+			t.Errorf("MinTLSVersion %s: error = %v, wantErr %v", tls.VersionName(minVersion), err, wantErr)
+		}
+	}
+}
+
+func TestNewHTTPClientUsesProxy(t *testing.T) {
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+	}))
+	t.Cleanup(proxy.Close)
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	client := NewHTTPClient(TransportOptions{Timeout: 5 * time.Second, Proxy: proxyURL})
+	resp, err := client.Get("http://10.0.0.1:8080/metrics")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	resp.Body.Close()
+	if got := <-proxied; got != "http://10.0.0.1:8080/metrics" {
+		t.Fatalf("expected the scrape to be sent through the proxy, got %q", got)
+	}
+}
+
+func TestNewHTTPClientUsesDNSServer(t *testing.T) {
+	dns, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { dns.Close() })
+
+	queried := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 512)
+		if _, _, err := dns.ReadFrom(buf); err == nil {
+			queried <- struct{}{}
+		}
+	}()
+
+	client := NewHTTPClient(TransportOptions{Timeout: time.Second, DNSServer: dns.LocalAddr().String()})
+	go func() {
+		if resp, err := client.Get("http://static-target.test/metrics"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	select {
+	case <-queried:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the hostname to be resolved via the configured DNS server")
+	}
+}
+
+func TestNewHTTPClientBindsSourceIP(t *testing.T) {
+	remote := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote <- r.RemoteAddr
+	}))
+	t.Cleanup(server.Close)
+
+	// 127.0.0.2 is not the address the kernel would pick on its own.
+	client := NewHTTPClient(TransportOptions{Timeout: 5 * time.Second, SourceIP: net.ParseIP("127.0.0.2")})
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Skipf("cannot originate connections from 127.0.0.2 here: %v", err)
+	}
+	resp.Body.Close()
+	host, _, err := net.SplitHostPort(<-remote)
+	if err != nil {
+		t.Fatalf("SplitHostPort() error = %v", err)
+	}
+	if host != "127.0.0.2" {
+		t.Fatalf("request came from %s, want 127.0.0.2", host)
+	}
+}
+
+func TestNewHTTPClientAppliesDialTimeout(t *testing.T) {
+	client := NewHTTPClient(TransportOptions{Timeout: 30 * time.Second, DialTimeout: 100 * time.Millisecond})
+
+	start := time.Now()
+	// 10.255.255.1 is a non-routable address, so the connection attempt hangs.
+	_, err := client.Get("http://10.255.255.1:9090/metrics")
+	elapsed := time.Since(start)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Skipf("dialling a non-routable address did not time out here: %v", err)
+	}
+	if elapsed > 5*time.Second {
+		t.Fatalf("dial gave up after %s, want about the 100ms dial timeout", elapsed)
+	}
+}